@@ -1,6 +1,7 @@
 package apiserver
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -11,10 +12,13 @@ import (
 	"cprofiler/pkg/apiserver/ui"
 	"cprofiler/pkg/apiserver/ui/pprof"
 	"cprofiler/pkg/apiserver/ui/trace"
+	"cprofiler/pkg/collector"
+	"cprofiler/pkg/profiles"
 	"cprofiler/pkg/storage"
 	"cprofiler/pkg/utils"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/pprof/profile"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -25,17 +29,43 @@ type APIServer struct {
 	srv    *http.Server
 	pprof  *ui.Server
 	trace  *ui.Server
+	// tracePProf redirects the pprof UI of the profiles computed from traces to the trace UI, which serves them
+	tracePProf http.HandlerFunc
+	// warmer warms the latest profiles into pprof and trace ui, nil if it is disabled
+	warmer *warmer
 }
 
 func NewAPIServer(opt Options) *APIServer {
 	pprofPath := "/api/pprof/ui"
 	tracePath := "/api/trace/ui"
+	tracePProfPath := trace.PProfPath(tracePath)
+
+	// query federation mode, the apis and uis query the profiles of the peers as well
+	store := opt.Store
+	if len(opt.Peers) > 0 {
+		store = newFederatedStore(opt.Store, opt.Peers, opt.FederationTimeout)
+	}
 
 	apiServer := &APIServer{
 		opt:   opt,
-		store: opt.Store,
-		pprof: ui.NewServer(pprofPath, opt.Store, opt.GCInternal, pprof.Driver),
-		trace: ui.NewServer(tracePath, opt.Store, opt.GCInternal, trace.Driver),
+		store: store,
+		pprof: ui.NewServer(pprofPath, store, opt.uiOptions(), ui.Weighted(pprof.Driver, pprof.MemoryWeight)),
+		trace: ui.NewServer(tracePath, store, opt.uiOptions(), ui.Weighted(ui.FromData(trace.Driver), trace.MemoryWeight)),
+
+		tracePProf: trace.PProfRedirect(tracePath),
+	}
+
+	if opt.WarmInternal > 0 {
+		// only the local profiles are warmed, the replicas warm their own
+		apiServer.warmer = &warmer{
+			store:    opt.Store,
+			pprof:    apiServer.pprof.Warm,
+			trace:    apiServer.trace.Warm,
+			count:    opt.WarmCount,
+			maxBytes: opt.WarmMaxBytes,
+			lookback: opt.WarmLookback,
+			exitChan: make(chan struct{}),
+		}
 	}
 
 	router := gin.Default()
@@ -43,16 +73,39 @@ func NewAPIServer(opt Options) *APIServer {
 		c.String(200, "I'm fine")
 	})
 	router.Use(HandleCors).GET("/api/targets", apiServer.listTarget)
+	router.Use(HandleCors).GET("/api/scrape_status", apiServer.listScrapeStatus)
 	router.Use(HandleCors).GET("/api/group_labels", apiServer.listGroupLabel)
 	router.Use(HandleCors).GET("/api/sample_types", apiServer.listSampleTypes)
 	router.Use(HandleCors).GET("/api/group_sample_types", apiServer.listGroupSampleTypes)
 	router.Use(HandleCors).GET("/api/profile_meta/:sample_type", apiServer.listProfileMeta)
 	router.Use(HandleCors).GET("/api/download/:id", apiServer.downloadProfile)
+	router.Use(HandleCors).GET("/api/merged_download/:sample_type", apiServer.downloadMergedProfile)
+	router.Use(HandleCors).GET("/api/sample_labels/:id", apiServer.aggregateSampleLabels)
+	router.Use(HandleCors).GET("/api/label_series/:sample_type", apiServer.listLabelSeries)
+	router.Use(HandleCors).GET("/api/flamegraph/:id", apiServer.flameGraph)
+	router.Use(HandleCors).GET("/api/merged_flamegraph/:sample_type", apiServer.mergedFlameGraph)
+
+	router.Use(HandleCors).GET("/api/trace/json/:id", apiServer.exportTraceJSON)
+	router.Use(HandleCors).GET("/api/debug/pprof/:type", apiServer.debugPProf)
+	// pyroscope compatible ingestion
+	router.POST("/ingest", apiServer.ingest)
+	// profiles forwarded by the remote write of the other cprofilers
+	router.POST("/api/remote_write", apiServer.remoteWrite)
+	// local profiles queried by the federated peers
+	router.GET("/api/federate/profile_meta/:sample_type", apiServer.federateProfileMeta)
+	router.GET("/api/federate/profile/:id", apiServer.federateProfile)
+	router.GET("/api/federate/sample_types", apiServer.federateSampleTypes)
+	router.GET("/api/federate/targets", apiServer.federateTargets)
+	router.GET("/api/federate/labels", apiServer.federateLabels)
+	// OTLP/HTTP profiles receiver
+	router.POST(otlpProfilesPath, apiServer.otlpProfiles)
 
 	// register pprof page
 	router.Use(HandleCors).GET(pprofPath+"/*any", apiServer.webPProf)
 	// register trace page
 	router.Use(HandleCors).GET(tracePath+"/*any", apiServer.webTrace)
+	// redirect pprof page of the profiles computed from traces to the trace page
+	router.Use(HandleCors).GET(tracePProfPath+"/*any", apiServer.webTracePProf)
 
 	srv := &http.Server{
 		Addr:    opt.Addr,
@@ -65,6 +118,9 @@ func NewAPIServer(opt Options) *APIServer {
 }
 
 func (s *APIServer) Stop() {
+	if s.warmer != nil {
+		s.warmer.exit()
+	}
 	s.pprof.Exit()
 	s.trace.Exit()
 
@@ -77,6 +133,9 @@ func (s *APIServer) Stop() {
 }
 
 func (s *APIServer) Run() {
+	if s.warmer != nil {
+		go s.warmer.run(s.opt.WarmInternal)
+	}
 	go func() {
 		if err := s.srv.ListenAndServe(); err != nil {
 			if errors.Is(err, http.ErrServerClosed) {
@@ -97,6 +156,23 @@ func (s *APIServer) listTarget(c *gin.Context) {
 	c.JSON(http.StatusOK, targets)
 }
 
+// listScrapeStatus List the status of the last scrapes, filtered by query job and health
+func (s *APIServer) listScrapeStatus(c *gin.Context) {
+	res := make([]collector.ScrapeStatus, 0)
+	if s.opt.ScrapeStatus == nil {
+		c.JSON(http.StatusOK, res)
+		return
+	}
+
+	job, health := c.Query("job"), c.Query("health")
+	for _, status := range s.opt.ScrapeStatus() {
+		if (job == "" || status.Job == job) && (health == "" || status.Health == health) {
+			res = append(res, status)
+		}
+	}
+	c.JSON(http.StatusOK, res)
+}
+
 func (s *APIServer) listGroupLabel(c *gin.Context) {
 	labels, err := s.store.ListLabel()
 	if err != nil {
@@ -144,10 +220,124 @@ func (s *APIServer) listGroupSampleTypes(c *gin.Context) {
 }
 
 func (s *APIServer) listProfileMeta(c *gin.Context) {
-	var startTime, endTime time.Time
-	var err error
+	sampleType := c.Param("sample_type")
+
+	startTime, endTime, ok := timeRangeQuery(c)
+	if !ok {
+		return
+	}
+
+	filters, ok := labelFiltersQuery(c)
+	if !ok {
+		return
+	}
+
+	metas, err := s.store.ListProfileMeta(sampleType, startTime, endTime, filters...)
+
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, metas)
+}
+
+func (s *APIServer) downloadProfile(c *gin.Context) {
+	format := c.DefaultQuery("format", formatPProf)
+	if !validFormat(format) {
+		c.String(http.StatusBadRequest, "format must be one of: %s", strings.Join(formats, ", "))
+		return
+	}
+
+	id := c.Param("id")
+	name, data, err := s.store.GetProfile(id)
+	if err != nil {
+		if errors.Is(err, storage.ErrProfileNotFound) {
+			c.String(http.StatusNotFound, "Profile not found")
+			return
+		}
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	filename := fmt.Sprintf("%s-%s", name, id)
+
+	// The raw profile, it can also be a trace
+	if format == formatPProf && len(sampleLabelsQuery(c)) == 0 {
+		c.Writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment;filename=%s.prof", filename))
+		c.Data(200, "application/octet-stream", data)
+		return
+	}
+
+	p, err := profile.ParseData(data)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	writeExport(c, filename, format, p, c.Query("si"))
+}
+
+// downloadMergedProfile Download the merged profile of sample type in the time range
+func (s *APIServer) downloadMergedProfile(c *gin.Context) {
+	format := c.DefaultQuery("format", formatPProf)
+	if !validFormat(format) {
+		c.String(http.StatusBadRequest, "format must be one of: %s", strings.Join(formats, ", "))
+		return
+	}
 
 	sampleType := c.Param("sample_type")
+	p, ok := s.mergedProfile(c, sampleType)
+	if !ok {
+		return
+	}
+
+	si := c.Query("si")
+	if si == "" {
+		si = sampleType
+	}
+	writeExport(c, "merged-"+sampleType, format, p, si)
+}
+
+// exportTraceJSON Download the trace of id in the Chrome Trace Event JSON format
+func (s *APIServer) exportTraceJSON(c *gin.Context) {
+	id := c.Param("id")
+	name, data, err := s.store.GetProfile(id)
+	if err != nil {
+		if errors.Is(err, storage.ErrProfileNotFound) {
+			c.String(http.StatusNotFound, "Profile not found")
+			return
+		}
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	b := &bytes.Buffer{}
+	if err = trace.ExportJSON(b, data); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.Writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment;filename=%s-%s.json", name, id))
+	c.Data(http.StatusOK, "application/json", b.Bytes())
+}
+
+// aggregateSampleLabels Sum the profile sample values grouped by pprof label
+func (s *APIServer) aggregateSampleLabels(c *gin.Context) {
+	p, ok := s.getProfile(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	sampleIndex, err := profiles.SampleIndex(p, c.Query("si"))
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	p = profiles.FilterByLabels(p, sampleLabelsQuery(c))
+	c.JSON(http.StatusOK, profiles.AggregateByLabel(p, sampleIndex, c.QueryArray("key")...))
+}
+
+// timeRangeQuery Get RFC3339 start_time and end_time from query, write the error response if failed
+func timeRangeQuery(c *gin.Context) (startTime, endTime time.Time, ok bool) {
+	var err error
 
 	if c.Query("start_time") == "" || c.Query("end_time") == "" {
 		c.String(http.StatusBadRequest, "start_time or end_time is empty")
@@ -163,7 +353,12 @@ func (s *APIServer) listProfileMeta(c *gin.Context) {
 		c.String(http.StatusBadRequest, "%s ,%s", "The time format must be RFC3339", err.Error())
 		return
 	}
+	return startTime, endTime, true
+}
 
+// labelFiltersQuery Get label filters from query lbs[key]=value (or labels[]) and sample_lbs[key]=value,
+// write the error response if failed
+func labelFiltersQuery(c *gin.Context) ([]storage.LabelFilter, bool) {
 	req := struct {
 		Filters []storage.LabelFilter `json:"labels[]" form:"labels[]"`
 	}{}
@@ -184,32 +379,50 @@ func (s *APIServer) listProfileMeta(c *gin.Context) {
 	} else {
 		if err := c.ShouldBind(&req); err != nil {
 			c.String(http.StatusInternalServerError, err.Error())
-			return
+			return nil, false
 		}
 	}
 
-	metas, err := s.store.ListProfileMeta(sampleType, startTime, endTime, req.Filters...)
-
-	if err != nil {
-		c.String(http.StatusInternalServerError, err.Error())
-		return
+	for key, val := range c.QueryMap("sample_lbs") {
+		req.Filters = append(req.Filters, storage.LabelFilter{
+			Label: storage.Label{
+				Key:   storage.SampleLabelKey(key),
+				Value: val,
+			},
+			Condition: c.Query("condition"),
+		})
 	}
-	c.JSON(http.StatusOK, metas)
+	return req.Filters, true
 }
 
-func (s *APIServer) downloadProfile(c *gin.Context) {
-	id := c.Param("id")
-	name, data, err := s.store.GetProfile(id)
+// getProfile Get and parse profile by id, write the error response if failed
+func (s *APIServer) getProfile(c *gin.Context, id string) (*profile.Profile, bool) {
+	_, data, err := s.store.GetProfile(id)
 	if err != nil {
 		if errors.Is(err, storage.ErrProfileNotFound) {
 			c.String(http.StatusNotFound, "Profile not found")
-			return
+			return nil, false
 		}
 		c.String(http.StatusInternalServerError, err.Error())
-		return
+		return nil, false
 	}
-	c.Writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment;filename=%s-%s.prof", name, id))
-	c.Data(200, "application/octet-stream", data)
+
+	p, err := profile.ParseData(data)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	return p, true
+}
+
+// sampleLabelsQuery Get pprof sample labels from query sample_lbs[key]=value
+func sampleLabelsQuery(c *gin.Context) []storage.Label {
+	lbs := c.QueryMap("sample_lbs")
+	labels := make([]storage.Label, 0, len(lbs))
+	for key, val := range lbs {
+		labels = append(labels, storage.Label{Key: key, Value: val})
+	}
+	return labels
 }
 
 func (s *APIServer) webPProf(c *gin.Context) {
@@ -221,3 +434,7 @@ func (s *APIServer) webTrace(c *gin.Context) {
 	c.Request.URL.RawQuery = utils.RemovePrefixSampleType(c.Request.URL.RawQuery)
 	s.trace.Web(c.Writer, c.Request)
 }
+
+func (s *APIServer) webTracePProf(c *gin.Context) {
+	s.tracePProf(c.Writer, c.Request)
+}
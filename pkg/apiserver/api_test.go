@@ -1,18 +1,26 @@
 package apiserver
 
 import (
+	"bytes"
+	"compress/gzip"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"cprofiler/pkg/collector"
+	"cprofiler/pkg/internal/otlpprofile"
+	"cprofiler/pkg/profiles"
 	"cprofiler/pkg/storage"
 	"cprofiler/pkg/storage/badger"
 
 	"github.com/gavv/httpexpect/v2"
 	"github.com/gin-gonic/gin"
+	"github.com/google/pprof/profile"
 	"github.com/stretchr/testify/require"
 )
 
@@ -26,6 +34,7 @@ var (
 			SampleType:     "heap_alloc_objects",
 			ProfileType:    "heap",
 			JobName:        "profiler-server",
+			Host:           "127.0.0.1:9000",
 			Value:          100,
 		},
 		{
@@ -36,6 +45,7 @@ var (
 			SampleType:     "heap_alloc_space",
 			ProfileType:    "heap",
 			JobName:        "profiler-server",
+			Host:           "127.0.0.1:9000",
 			Value:          200,
 		},
 		{
@@ -46,6 +56,7 @@ var (
 			SampleType:     "heap_inuse_objects",
 			ProfileType:    "heap",
 			JobName:        "server2",
+			Host:           "127.0.0.1:9001",
 			Value:          300,
 		},
 		{
@@ -56,6 +67,7 @@ var (
 			SampleType:     "heap_inuse_space",
 			ProfileType:    "heap",
 			JobName:        "server2",
+			Host:           "127.0.0.1:9001",
 			Value:          400,
 		},
 		{
@@ -66,6 +78,7 @@ var (
 			SampleType:     "heap_inuse_space",
 			ProfileType:    "heap",
 			JobName:        "server3",
+			Host:           "127.0.0.1:9002",
 			Value:          400,
 		},
 	}
@@ -132,7 +145,7 @@ func TestBasisAPI(t *testing.T) {
 
 	e.GET("/api/targets").
 		Expect().
-		Status(http.StatusOK).JSON().Array().Contains("profiler-server", "server2", "server3")
+		Status(http.StatusOK).JSON().Array().Contains("127.0.0.1:9000", "127.0.0.1:9001", "127.0.0.1:9002")
 
 	e.GET("/api/sample_types").
 		Expect().
@@ -145,6 +158,39 @@ func TestBasisAPI(t *testing.T) {
 	res.Path("$.heap").Array().Contains("heap_alloc_objects", "heap_alloc_space", "heap_inuse_space", "heap_inuse_space")
 }
 
+func TestScrapeStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	s := badger.NewStore(badger.DefaultOptions(dir))
+
+	e := getExpect(NewAPIServer(DefaultOptions(s)), t)
+	e.GET("/api/scrape_status").
+		Expect().
+		Status(http.StatusOK).JSON().Array().Empty()
+
+	statuses := []collector.ScrapeStatus{
+		{Job: "job1", Host: "127.0.0.1:9000", ProfileType: "heap", Health: collector.HealthUp, Attempts: 1},
+		{Job: "job1", Host: "127.0.0.1:9000", ProfileType: "profile", Health: collector.HealthDown, LastError: "http resp status code is 502", Attempts: 3, Failures: 2},
+		{Job: "job2", Host: "127.0.0.1:9001", ProfileType: "heap", Health: collector.HealthUnknown},
+	}
+	e = getExpect(NewAPIServer(DefaultOptions(s).WithScrapeStatus(func() []collector.ScrapeStatus { return statuses })), t)
+
+	e.GET("/api/scrape_status").
+		Expect().
+		Status(http.StatusOK).JSON().Array().Length().Equal(3)
+
+	e.GET("/api/scrape_status").WithQuery("job", "job1").
+		Expect().
+		Status(http.StatusOK).JSON().Array().Length().Equal(2)
+
+	res := e.GET("/api/scrape_status").WithQuery("health", collector.HealthDown).
+		Expect().
+		Status(http.StatusOK).JSON().Array()
+	res.Length().Equal(1)
+	res.Element(0).Object().ValueEqual("ProfileType", "profile").ValueEqual("Failures", 2).ValueEqual("LastError", "http resp status code is 502")
+}
+
 func TestListProfileMeta(t *testing.T) {
 	dir, err := ioutil.TempDir("./", "temp-*")
 	require.Equal(t, nil, err)
@@ -219,6 +265,202 @@ func TestDownloadProfile(t *testing.T) {
 		Status(http.StatusOK).Header("Content-Type").Equal("application/octet-stream")
 }
 
+// initLabelProfileData Save a cpu profile whose samples carry the pprof label tenant
+// initLabelProfileData Save a profile labeled by tenant, its meta records the values of the labels
+func initLabelProfileData(s storage.Store, t *testing.T, n int64) string {
+	return saveLabelProfile(s, t, n, true)
+}
+
+// saveLabelProfile Save a profile labeled by tenant, its meta records the values of the labels if withValues,
+// as the metas saved before the values are recorded do not
+func saveLabelProfile(s storage.Store, t *testing.T, n int64, withValues bool) string {
+	fn := &profile.Function{ID: 1, Name: "main.handle"}
+	loc := &profile.Location{ID: 1, Line: []profile.Line{{Function: fn}}}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}, {Type: "cpu", Unit: "nanoseconds"}},
+		Function:   []*profile.Function{fn},
+		Location:   []*profile.Location{loc},
+		Sample: []*profile.Sample{
+			{Location: []*profile.Location{loc}, Value: []int64{1 * n, 10 * n}, Label: map[string][]string{"tenant": {"acme"}}},
+			{Location: []*profile.Location{loc}, Value: []int64{2 * n, 20 * n}, Label: map[string][]string{"tenant": {"other"}}},
+		},
+	}
+	b := &bytes.Buffer{}
+	require.Equal(t, nil, p.Write(b))
+
+	metas := []*storage.ProfileMeta{{
+		ProfileType:  "profile",
+		SampleType:   "profile_cpu",
+		JobName:      "profiler-server",
+		Host:         "127.0.0.1:9000",
+		Timestamp:    time.Now().UnixNano() / time.Millisecond.Nanoseconds(),
+		Value:        30 * n,
+		SampleLabels: profiles.SampleLabels(p),
+	}}
+	if withValues {
+		metas[0].SampleLabelValues = []int64{10 * n, 20 * n}
+	}
+	id, err := s.SaveProfileWithMeta("", b.Bytes(), metas, time.Hour)
+	require.Equal(t, nil, err)
+	return id
+}
+
+func TestLabelSeries(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	s := badger.NewStore(badger.DefaultOptions(dir))
+	id1 := initLabelProfileData(s, t, 1)
+	id2 := initLabelProfileData(s, t, 2)
+	// The profile is parsed if its meta does not record the values, and a bad profile is skipped
+	id3 := saveLabelProfile(s, t, 3, false)
+	_, err = s.SaveProfileWithMeta("", []byte("bad profile"), []*storage.ProfileMeta{{
+		ProfileType:  "profile",
+		SampleType:   "profile_cpu",
+		JobName:      "profiler-server",
+		Host:         "127.0.0.1:9000",
+		Timestamp:    time.Now().UnixNano() / time.Millisecond.Nanoseconds(),
+		SampleLabels: []storage.Label{{Key: "tenant", Value: "acme"}},
+	}}, time.Hour)
+	require.Equal(t, nil, err)
+
+	apiServer := NewAPIServer(DefaultOptions(s))
+	e := getExpect(apiServer, t)
+
+	startTime := time.Now().Add(-1 * time.Minute).Format(time.RFC3339)
+	endTime := time.Now().Add(time.Minute).Format(time.RFC3339)
+
+	e.GET("/api/label_series/profile_cpu").
+		WithQuery("start_time", startTime).WithQuery("end_time", endTime).
+		Expect().
+		Status(http.StatusBadRequest).Text().Equal("key is empty")
+
+	e.GET("/api/label_series/profile_cpu").WithQuery("key", "tenant").
+		Expect().
+		Status(http.StatusBadRequest).Text().Equal("start_time or end_time is empty")
+
+	res := e.GET("/api/label_series/profile_cpu").
+		WithQuery("start_time", startTime).WithQuery("end_time", endTime).WithQuery("key", "tenant").
+		Expect().
+		Status(http.StatusOK).JSON().Array()
+	res.Length().Equal(1)
+
+	target := res.Element(0).Object()
+	target.Value("TargetName").Equal("127.0.0.1:9000")
+	series := target.Value("Series").Array()
+	series.Length().Equal(2)
+	series.Element(0).Object().Value("LabelValue").Equal("acme")
+	series.Element(0).Object().Value("Points").Array().Length().Equal(3)
+	series.Element(1).Object().Value("LabelValue").Equal("other")
+
+	values := map[string]float64{}
+	for _, point := range series.Element(1).Object().Value("Points").Array().Iter() {
+		values[point.Object().Value("ProfileID").String().Raw()] = point.Object().Value("Value").Number().Raw()
+	}
+	require.Equal(t, map[string]float64{id1: 20, id2: 40, id3: 60}, values)
+
+	// The samples without the label are summed under the empty value
+	res = e.GET("/api/label_series/profile_cpu").
+		WithQuery("start_time", startTime).WithQuery("end_time", endTime).WithQuery("key", "endpoint").
+		Expect().
+		Status(http.StatusOK).JSON().Array()
+	series = res.Element(0).Object().Value("Series").Array()
+	series.Length().Equal(1)
+	series.Element(0).Object().Value("LabelValue").Equal("")
+	series.Element(0).Object().Value("Points").Array().Length().Equal(3)
+}
+
+func TestLabelSeriesTruncated(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	s := badger.NewStore(badger.DefaultOptions(dir))
+	apiServer := NewAPIServer(DefaultOptions(s))
+	e := getExpect(apiServer, t)
+
+	// More label values than the meta records
+	fn := &profile.Function{ID: 1, Name: "main.handle"}
+	loc := &profile.Location{ID: 1, Line: []profile.Line{{Function: fn}}}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "cpu", Unit: "nanoseconds"}},
+		Function:   []*profile.Function{fn},
+		Location:   []*profile.Location{loc},
+	}
+	for i := 0; i < 20; i++ {
+		p.Sample = append(p.Sample, &profile.Sample{
+			Location: []*profile.Location{loc},
+			Value:    []int64{int64(i + 1)},
+			Label:    map[string][]string{"tenant": {fmt.Sprintf("tenant-%02d", i)}},
+		})
+	}
+	b := &bytes.Buffer{}
+	require.Equal(t, nil, p.Write(b))
+	src := collector.Source{JobName: "profiler-server", Host: "127.0.0.1:9000", Expiration: time.Hour}
+	require.Equal(t, nil, collector.SaveProfile(s, src, "profile", b.Bytes()))
+
+	// Every label value gets its own series, none of them is summed under the empty value
+	series := e.GET("/api/label_series/profile").
+		WithQuery("start_time", time.Now().Add(-1*time.Minute).Format(time.RFC3339)).
+		WithQuery("end_time", time.Now().Add(time.Minute).Format(time.RFC3339)).
+		WithQuery("key", "tenant").
+		Expect().
+		Status(http.StatusOK).JSON().Array().Element(0).Object().Value("Series").Array()
+	series.Length().Equal(20)
+	for i := 0; i < 20; i++ {
+		series.Element(i).Object().Value("LabelValue").Equal(fmt.Sprintf("tenant-%02d", i))
+		series.Element(i).Object().Value("Points").Array().Element(0).Object().Value("Value").Equal(i + 1)
+	}
+}
+
+func TestSampleLabels(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	s := badger.NewStore(badger.DefaultOptions(dir))
+	id := initLabelProfileData(s, t, 1)
+
+	apiServer := NewAPIServer(DefaultOptions(s))
+	e := getExpect(apiServer, t)
+
+	e.GET("/api/sample_labels/999").
+		Expect().
+		Status(http.StatusNotFound)
+
+	e.GET(fmt.Sprintf("/api/sample_labels/%s", id)).WithQuery("si", "profile_cpu").
+		Expect().
+		Status(http.StatusOK).JSON().Path("$.tenant").Object().Equal(map[string]int64{"acme": 10, "other": 20})
+
+	e.GET(fmt.Sprintf("/api/sample_labels/%s", id)).WithQuery("si", "samples").WithQuery("sample_lbs[tenant]", "acme").
+		Expect().
+		Status(http.StatusOK).JSON().Path("$.tenant").Object().Equal(map[string]int64{"acme": 1})
+
+	e.GET(fmt.Sprintf("/api/sample_labels/%s", id)).WithQuery("si", "alloc_space").
+		Expect().
+		Status(http.StatusBadRequest)
+
+	data := e.GET(fmt.Sprintf("/api/download/%s", id)).WithQuery("sample_lbs[tenant]", "acme").
+		Expect().
+		Status(http.StatusOK).Body().Raw()
+	filtered, err := profile.ParseData([]byte(data))
+	require.Equal(t, nil, err)
+	require.Equal(t, 1, len(filtered.Sample))
+
+	startTime := time.Now().Add(-1 * time.Minute).Format(time.RFC3339)
+	endTime := time.Now().Add(time.Minute).Format(time.RFC3339)
+	e.GET("/api/profile_meta/profile_cpu").
+		WithQuery("start_time", startTime).WithQuery("end_time", endTime).WithQuery("sample_lbs[tenant]", "acme").
+		Expect().
+		Status(http.StatusOK).JSON().Array().Length().Equal(1)
+
+	e.GET("/api/profile_meta/profile_cpu").
+		WithQuery("start_time", startTime).WithQuery("end_time", endTime).WithQuery("sample_lbs[tenant]", "none").
+		Expect().
+		Status(http.StatusOK).JSON().Array().Length().Equal(0)
+}
+
 func TestWebProfile(t *testing.T) {
 	dir, err := ioutil.TempDir("./", "temp-*")
 	require.Equal(t, nil, err)
@@ -256,3 +498,690 @@ func getExpect(apiServer *APIServer, t *testing.T) *httpexpect.Expect {
 		Reporter: httpexpect.NewAssertReporter(t),
 	})
 }
+
+func TestFlameGraph(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	s := badger.NewStore(badger.DefaultOptions(dir))
+	id1 := initLabelProfileData(s, t, 1)
+	id2 := initLabelProfileData(s, t, 2)
+
+	apiServer := NewAPIServer(DefaultOptions(s))
+	e := getExpect(apiServer, t)
+
+	e.GET("/api/flamegraph/999").
+		Expect().
+		Status(http.StatusNotFound)
+
+	res := e.GET(fmt.Sprintf("/api/flamegraph/%s", id1)).WithQuery("si", "cpu").
+		Expect().
+		Status(http.StatusOK).JSON().Object()
+	res.Value("SampleType").Equal("cpu")
+	res.Value("Unit").Equal("nanoseconds")
+	res.Path("$.Root.Total").Equal(30)
+	res.Path("$.Root.Children[0].Name").Equal("main.handle")
+	res.Path("$.Root.Children[0].Self").Equal(30)
+
+	e.GET(fmt.Sprintf("/api/flamegraph/%s", id1)).WithQuery("si", "cpu").WithQuery("sample_lbs[tenant]", "acme").
+		Expect().
+		Status(http.StatusOK).JSON().Path("$.Root.Total").Equal(10)
+
+	e.GET(fmt.Sprintf("/api/flamegraph/%s", id1)).WithQuery("si", "cpu").WithQuery("ignore", "handle").
+		Expect().
+		Status(http.StatusOK).JSON().Path("$.Root.Total").Equal(0)
+
+	e.GET(fmt.Sprintf("/api/flamegraph/%s+%s", id1, id2)).WithQuery("si", "samples").
+		Expect().
+		Status(http.StatusOK).JSON().Path("$.Root.Total").Equal(9)
+
+	e.GET(fmt.Sprintf("/api/flamegraph/%s", id1)).WithQuery("focus", "(").
+		Expect().
+		Status(http.StatusBadRequest)
+
+	e.GET(fmt.Sprintf("/api/flamegraph/%s", id1)).WithQuery("node_fraction", "2").
+		Expect().
+		Status(http.StatusBadRequest)
+
+	e.GET(fmt.Sprintf("/api/flamegraph/%s", id1)).WithQuery("max_depth", "-1").
+		Expect().
+		Status(http.StatusBadRequest)
+
+	// Merged in the time range
+	startTime := time.Now().Add(-1 * time.Minute).Format(time.RFC3339)
+	endTime := time.Now().Add(time.Minute).Format(time.RFC3339)
+
+	e.GET("/api/merged_flamegraph/profile_cpu").
+		Expect().
+		Status(http.StatusBadRequest).Text().Equal("start_time or end_time is empty")
+
+	res = e.GET("/api/merged_flamegraph/profile_cpu").
+		WithQuery("start_time", startTime).WithQuery("end_time", endTime).
+		Expect().
+		Status(http.StatusOK).JSON().Object()
+	res.Value("SampleType").Equal("cpu")
+	res.Path("$.Root.Total").Equal(90)
+
+	e.GET("/api/merged_flamegraph/profile_cpu").
+		WithQuery("start_time", startTime).WithQuery("end_time", endTime).WithQuery("max_depth", "1").
+		Expect().
+		Status(http.StatusOK).JSON().Path("$.Root.Children[0]").Object().NotContainsKey("Children")
+
+	e.GET("/api/merged_flamegraph/profile_cpu").
+		WithQuery("start_time", time.Now().Add(-2*time.Hour).Format(time.RFC3339)).
+		WithQuery("end_time", time.Now().Add(-time.Hour).Format(time.RFC3339)).
+		Expect().
+		Status(http.StatusNotFound)
+}
+
+func TestDownloadFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	s := badger.NewStore(badger.DefaultOptions(dir))
+	id1 := initLabelProfileData(s, t, 1)
+	initLabelProfileData(s, t, 2)
+	traceID, err := s.SaveProfile("", []byte("trace"), time.Hour)
+	require.Equal(t, nil, err)
+
+	apiServer := NewAPIServer(DefaultOptions(s))
+	e := getExpect(apiServer, t)
+
+	e.GET(fmt.Sprintf("/api/download/%s", id1)).WithQuery("format", "svg").
+		Expect().
+		Status(http.StatusBadRequest).Text().Equal("format must be one of: pprof, folded, speedscope")
+
+	e.GET(fmt.Sprintf("/api/download/%s", traceID)).WithQuery("format", "folded").
+		Expect().
+		Status(http.StatusBadRequest)
+
+	resp := e.GET(fmt.Sprintf("/api/download/%s", id1)).WithQuery("format", "folded").WithQuery("si", "cpu").
+		Expect().
+		Status(http.StatusOK)
+	resp.Header("Content-Disposition").Equal(fmt.Sprintf("attachment;filename=-%s.folded", id1))
+	resp.Text().Equal("main.handle 30\n")
+
+	e.GET(fmt.Sprintf("/api/download/%s", id1)).WithQuery("format", "folded").WithQuery("si", "alloc_space").
+		Expect().
+		Status(http.StatusBadRequest)
+
+	resp = e.GET(fmt.Sprintf("/api/download/%s", id1)).WithQuery("format", "speedscope").
+		Expect().
+		Status(http.StatusOK)
+	resp.Header("Content-Disposition").Equal(fmt.Sprintf("attachment;filename=-%s.speedscope.json", id1))
+	res := resp.JSON().Object()
+	res.Value("$schema").Equal("https://www.speedscope.app/file-format-schema.json")
+	res.Path("$.shared.frames[0].name").Equal("main.handle")
+	res.Value("profiles").Array().Length().Equal(2)
+	res.Path("$.profiles[1].unit").Equal("nanoseconds")
+	res.Path("$.profiles[1].endValue").Equal(30)
+
+	e.GET(fmt.Sprintf("/api/download/%s", id1)).WithQuery("format", "speedscope").WithQuery("si", "samples").
+		WithQuery("sample_lbs[tenant]", "acme").
+		Expect().
+		Status(http.StatusOK).JSON().Path("$.profiles").Array().Length().Equal(1)
+
+	// Merged in the time range
+	startTime := time.Now().Add(-1 * time.Minute).Format(time.RFC3339)
+	endTime := time.Now().Add(time.Minute).Format(time.RFC3339)
+
+	e.GET("/api/merged_download/profile_cpu").WithQuery("format", "svg").
+		Expect().
+		Status(http.StatusBadRequest)
+
+	e.GET("/api/merged_download/profile_cpu").
+		WithQuery("start_time", startTime).WithQuery("end_time", endTime).WithQuery("format", "folded").
+		Expect().
+		Status(http.StatusOK).Text().Equal("main.handle 90\n")
+
+	res = e.GET("/api/merged_download/profile_cpu").
+		WithQuery("start_time", startTime).WithQuery("end_time", endTime).WithQuery("format", "speedscope").
+		Expect().
+		Status(http.StatusOK).JSON().Object()
+	res.Value("profiles").Array().Length().Equal(1)
+	res.Path("$.profiles[0].name").Equal("cpu")
+
+	data := e.GET("/api/merged_download/profile_cpu").
+		WithQuery("start_time", startTime).WithQuery("end_time", endTime).
+		Expect().
+		Status(http.StatusOK).Body().Raw()
+	merged, err := profile.ParseData([]byte(data))
+	require.Equal(t, nil, err)
+	var total int64
+	for _, sample := range merged.Sample {
+		total += sample.Value[1]
+	}
+	require.Equal(t, int64(90), total)
+}
+
+func TestExportTraceJSON(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	s := badger.NewStore(badger.DefaultOptions(dir))
+	_, invalidID, id, traceID := initProfileData(s, t)
+	traceBytes, err := ioutil.ReadFile("./testdata/trace_go126.out.testdata")
+	require.Equal(t, nil, err)
+	newTraceID, err := s.SaveProfile("", traceBytes, time.Hour)
+	require.Equal(t, nil, err)
+
+	apiServer := NewAPIServer(DefaultOptions(s))
+	e := getExpect(apiServer, t)
+
+	e.GET("/api/trace/json/999").
+		Expect().
+		Status(http.StatusNotFound)
+
+	e.GET(fmt.Sprintf("/api/trace/json/%s", invalidID)).
+		Expect().
+		Status(http.StatusBadRequest)
+
+	e.GET(fmt.Sprintf("/api/trace/json/%s", id)).
+		Expect().
+		Status(http.StatusBadRequest)
+
+	for _, traceID := range []string{traceID, newTraceID} {
+		resp := e.GET(fmt.Sprintf("/api/trace/json/%s", traceID)).
+			Expect().
+			Status(http.StatusOK)
+		resp.Header("Content-Disposition").Equal(fmt.Sprintf("attachment;filename=-%s.json", traceID))
+		res := resp.JSON().Object()
+		res.Value("displayTimeUnit").Equal("ns")
+		res.Value("traceEvents").Array().NotEmpty()
+		res.ContainsKey("stackFrames")
+	}
+}
+
+func TestDebugPProf(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	s := badger.NewStore(badger.DefaultOptions(dir))
+	initLabelProfileData(s, t, 1)
+	time.Sleep(2 * time.Millisecond)
+	initLabelProfileData(s, t, 2)
+
+	apiServer := NewAPIServer(DefaultOptions(s))
+	e := getExpect(apiServer, t)
+
+	cpu := func(data string) int64 {
+		p, err := profile.ParseData([]byte(data))
+		require.Equal(t, nil, err)
+		var total int64
+		for _, sample := range p.Sample {
+			total += sample.Value[1]
+		}
+		return total
+	}
+
+	e.GET("/api/debug/pprof/profile").
+		Expect().
+		Status(http.StatusBadRequest).Text().Equal("job or host is empty")
+
+	e.GET("/api/debug/pprof/heap").WithQuery("job", "profiler-server").
+		Expect().
+		Status(http.StatusNotFound)
+
+	e.GET("/api/debug/pprof/profile").WithQuery("job", "none").
+		Expect().
+		Status(http.StatusNotFound)
+
+	// The latest profile
+	data := e.GET("/api/debug/pprof/profile").WithQuery("job", "profiler-server").
+		Expect().
+		Status(http.StatusOK).Body().Raw()
+	require.Equal(t, int64(60), cpu(data))
+
+	data = e.GET("/api/debug/pprof/profile").WithQuery("host", "127.0.0.1:9000").WithQuery("job", "profiler-server").
+		Expect().
+		Status(http.StatusOK).Body().Raw()
+	require.Equal(t, int64(60), cpu(data))
+
+	// Both the job and the host must match
+	other := &bytes.Buffer{}
+	require.Equal(t, nil, (&profile.Profile{SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}, {Type: "cpu", Unit: "nanoseconds"}}}).Write(other))
+	_, err = s.SaveProfileWithMeta("", other.Bytes(), []*storage.ProfileMeta{{
+		ProfileType: "profile",
+		SampleType:  "profile_cpu",
+		JobName:     "server2",
+		Host:        "127.0.0.1:9001",
+		Timestamp:   time.Now().UnixNano() / time.Millisecond.Nanoseconds(),
+	}}, time.Hour)
+	require.Equal(t, nil, err)
+
+	e.GET("/api/debug/pprof/profile").WithQuery("job", "profiler-server").WithQuery("host", "127.0.0.1:9001").
+		Expect().
+		Status(http.StatusNotFound)
+
+	e.GET("/api/debug/pprof/profile").WithQuery("job", "none").WithQuery("host", "127.0.0.1:9000").
+		Expect().
+		Status(http.StatusNotFound)
+
+	data = e.GET("/api/debug/pprof/profile").WithQuery("job", "profiler-server").
+		Expect().
+		Status(http.StatusOK).Body().Raw()
+	require.Equal(t, int64(60), cpu(data))
+
+	data = e.GET("/api/debug/pprof/profile").WithQuery("host", "127.0.0.1:9001").
+		Expect().
+		Status(http.StatusOK).Body().Raw()
+	require.Equal(t, int64(0), cpu(data))
+
+	// Merged in the time range
+	from := time.Now().Add(-1 * time.Minute).Format(time.RFC3339)
+	to := time.Now().Add(time.Minute).Format(time.RFC3339)
+	data = e.GET("/api/debug/pprof/profile").WithQuery("job", "profiler-server").
+		WithQuery("from", from).WithQuery("to", to).
+		Expect().
+		Status(http.StatusOK).Body().Raw()
+	require.Equal(t, int64(90), cpu(data))
+
+	e.GET("/api/debug/pprof/profile").WithQuery("job", "profiler-server").WithQuery("from", "yesterday").
+		Expect().
+		Status(http.StatusBadRequest)
+
+	e.GET("/api/debug/pprof/trace").WithQuery("job", "profiler-server").WithQuery("from", from).
+		Expect().
+		Status(http.StatusBadRequest).Text().Equal("trace can not be merged")
+}
+
+func TestParseIngestName(t *testing.T) {
+	name, err := parseIngestName("shop.cpu{env=prod, hostname=h1}")
+	require.Equal(t, nil, err)
+	require.Equal(t, &ingestName{App: "shop", Suffix: "cpu", ProfileType: "profile", SampleType: "cpu", Labels: map[string]string{"env": "prod", "hostname": "h1"}}, name)
+
+	name, err = parseIngestName("shop.v2.inuse_space")
+	require.Equal(t, nil, err)
+	require.Equal(t, "shop.v2", name.App)
+	require.Equal(t, "heap", name.ProfileType)
+	require.Equal(t, "inuse_space", name.SampleType)
+
+	name, err = parseIngestName("shop.mutex_duration")
+	require.Equal(t, nil, err)
+	require.Equal(t, "mutex", name.ProfileType)
+	require.Equal(t, "delay", name.SampleType)
+
+	name, err = parseIngestName("shop.v2{}")
+	require.Equal(t, nil, err)
+	require.Equal(t, "shop.v2", name.App)
+	require.Equal(t, "", name.Suffix)
+
+	for _, invalid := range []string{"", "{env=prod}", "shop{env", "shop{env}"} {
+		_, err = parseIngestName(invalid)
+		require.NotEqual(t, nil, err, invalid)
+	}
+}
+
+func TestIngest(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	s := badger.NewStore(badger.DefaultOptions(dir))
+	apiServer := NewAPIServer(DefaultOptions(s))
+	e := getExpect(apiServer, t)
+
+	startTime := time.Now().Add(-1 * time.Minute).Format(time.RFC3339)
+	endTime := time.Now().Add(time.Minute).Format(time.RFC3339)
+	listMeta := func(sampleType string) *httpexpect.Array {
+		return e.GET(fmt.Sprintf("/api/profile_meta/%s", sampleType)).
+			WithQuery("start_time", startTime).WithQuery("end_time", endTime).
+			Expect().
+			Status(http.StatusOK).JSON().Array()
+	}
+
+	// Collapsed cpu samples
+	e.POST("/ingest").WithQuery("name", "shop.cpu{env=prod,hostname=h1}").WithQuery("sampleRate", "100").
+		WithQuery("from", time.Now().Add(-10*time.Second).Unix()).WithQuery("until", time.Now().Unix()).
+		WithText("main.main;main.a 3\nmain.main;main.b 2\n").
+		Expect().
+		Status(http.StatusOK)
+
+	metas := listMeta("profile_cpu")
+	metas.Length().Equal(1)
+	meta := metas.Element(0).Object().Value("ProfileMetas").Array().Element(0).Object()
+	meta.Value("JobName").Equal("shop")
+	meta.Value("App").Equal("shop")
+	meta.Value("Host").Equal("h1")
+	meta.Value("ProfileType").Equal("profile")
+	meta.Value("Value").Equal(50000000)
+	meta.Value("Duration").Equal(10 * time.Second.Nanoseconds())
+	meta.Value("Labels").Array().ContainsOnly(map[string]string{"Key": "env", "Value": "prod"}, map[string]string{"Key": "hostname", "Value": "h1"})
+	listMeta("profile_samples").Path("$[0].ProfileMetas[0].Value").Equal(5)
+
+	e.POST("/ingest").WithQuery("name", "shop.alloc_space").WithQuery("units", "bytes").
+		WithText("main.main;main.a 1024\n").
+		Expect().
+		Status(http.StatusOK)
+	listMeta("heap_alloc_space").Path("$[0].ProfileMetas[0].SampleTypeUnit").Equal("bytes")
+	listMeta("heap").Length().Equal(0)
+
+	e.POST("/ingest").WithQuery("name", "shop.mutex_duration").WithQuery("units", "lock_nanoseconds").
+		WithText("main.main;sync.(*Mutex).Lock 2000\n").
+		Expect().
+		Status(http.StatusOK)
+	listMeta("mutex_delay").Path("$[0].ProfileMetas[0].Value").Equal(2000)
+	listMeta("mutex_delay").Path("$[0].ProfileMetas[0].SampleTypeUnit").Equal("nanoseconds")
+
+	// The lines format has one sample per line
+	e.POST("/ingest").WithQuery("name", "shop.goroutines").WithQuery("format", "lines").
+		WithText("main.main;main.a\nmain.main;main.a\nmain.main;main.b\n").
+		Expect().
+		Status(http.StatusOK)
+	listMeta("goroutine").Path("$[0].ProfileMetas[0].Value").Equal(3)
+
+	// pprof in the body and in the multipart form
+	profileBytes, err := ioutil.ReadFile("./testdata/profile.out.testdata")
+	require.Equal(t, nil, err)
+	e.POST("/ingest").WithQuery("name", "api.inuse_space{}").WithQuery("format", "pprof").
+		WithBytes(profileBytes).
+		Expect().
+		Status(http.StatusOK)
+	e.POST("/ingest").WithQuery("name", "api.inuse_space").
+		WithMultipart().WithFile("profile", "profile.pprof", bytes.NewReader(profileBytes)).
+		Expect().
+		Status(http.StatusOK)
+	listMeta("heap_inuse_space").Path("$[0].ProfileMetas").Array().Length().Equal(2)
+
+	e.POST("/ingest").WithQuery("name", "api.cpu").WithQuery("format", "pprof").
+		WithText("main.main 1\n").
+		Expect().
+		Status(http.StatusBadRequest)
+
+	e.POST("/ingest").WithQuery("name", "api.cpu").WithQuery("format", "jfr").
+		WithText("main.main 1\n").
+		Expect().
+		Status(http.StatusBadRequest).Text().Equal("unsupported format \"jfr\"")
+
+	e.POST("/ingest").
+		WithText("main.main 1\n").
+		Expect().
+		Status(http.StatusBadRequest)
+}
+
+func TestOTLPProfiles(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	s := badger.NewStore(badger.DefaultOptions(dir))
+	apiServer := NewAPIServer(DefaultOptions(s))
+	e := getExpect(apiServer, t)
+
+	// The requests are built with the official generated types go.opentelemetry.io/proto/otlp v1.7.0
+	req, err := ioutil.ReadFile("./testdata/otlp_request.pb.testdata")
+	require.Equal(t, nil, err)
+
+	e.POST(otlpProfilesPath).WithHeader("Content-Type", "application/x-protobuf").
+		WithBytes(req).
+		Expect().
+		Status(http.StatusOK).ContentType("application/x-protobuf")
+
+	// gzip body
+	gz := &bytes.Buffer{}
+	w := gzip.NewWriter(gz)
+	_, err = w.Write(req)
+	require.Equal(t, nil, err)
+	require.Equal(t, nil, w.Close())
+	e.POST(otlpProfilesPath).WithHeader("Content-Type", "application/x-protobuf").WithHeader("Content-Encoding", "gzip").
+		WithBytes(gz.Bytes()).
+		Expect().
+		Status(http.StatusOK)
+
+	metas := e.GET("/api/profile_meta/profile_samples").
+		WithQuery("start_time", time.Now().Add(-1*time.Minute).Format(time.RFC3339)).
+		WithQuery("end_time", time.Now().Add(time.Minute).Format(time.RFC3339)).
+		Expect().
+		Status(http.StatusOK).JSON().Array()
+	metas.Length().Equal(1)
+	metas.Element(0).Object().Value("ProfileMetas").Array().Length().Equal(2)
+	meta := metas.Element(0).Object().Value("ProfileMetas").Array().Element(0).Object()
+	meta.Value("JobName").Equal("checkout")
+	meta.Value("Host").Equal("h1")
+	meta.Value("Value").Equal(7)
+	meta.Value("Labels").Array().ContainsOnly(map[string]string{"Key": "deployment.environment", "Value": "prod"})
+
+	e.POST(otlpProfilesPath).WithHeader("Content-Type", "application/json").
+		WithBytes([]byte("{}")).
+		Expect().
+		Status(http.StatusUnsupportedMediaType)
+
+	e.POST(otlpProfilesPath).WithHeader("Content-Type", "application/x-protobuf").
+		WithBytes([]byte{0x0a, 0x05, 0x01}).
+		Expect().
+		Status(http.StatusBadRequest)
+
+	// Nothing is saved if any profile of the request is rejected, the second profile has a too long label
+	batch, err := ioutil.ReadFile("./testdata/otlp_batch.pb.testdata")
+	require.Equal(t, nil, err)
+	e.POST(otlpProfilesPath).WithHeader("Content-Type", "application/x-protobuf").
+		WithBytes(batch).
+		Expect().
+		Status(http.StatusBadRequest)
+	e.GET("/api/profile_meta/profile_samples").
+		WithQuery("start_time", time.Now().Add(-1*time.Minute).Format(time.RFC3339)).
+		WithQuery("end_time", time.Now().Add(time.Minute).Format(time.RFC3339)).
+		WithQuery("lbs[_job]", "valid").
+		Expect().
+		Status(http.StatusOK).JSON().Array().Length().Equal(0)
+}
+
+func TestOTLPProfileType(t *testing.T) {
+	contention := func(leaf string) *profile.Profile {
+		fn := &profile.Function{ID: 1, Name: leaf}
+		loc := &profile.Location{ID: 1, Line: []profile.Line{{Function: fn}}}
+		return &profile.Profile{
+			SampleType: []*profile.ValueType{{Type: "contentions", Unit: "count"}, {Type: "delay", Unit: "nanoseconds"}},
+			PeriodType: &profile.ValueType{Type: "contentions", Unit: "count"},
+			Sample:     []*profile.Sample{{Location: []*profile.Location{loc}, Value: []int64{1, 100}}},
+			Location:   []*profile.Location{loc},
+			Function:   []*profile.Function{fn},
+		}
+	}
+	require.Equal(t, "mutex", otlpProfileType(&otlpprofile.Converted{Profile: contention("sync.(*Mutex).Unlock")}))
+	require.Equal(t, "block", otlpProfileType(&otlpprofile.Converted{Profile: contention("sync.(*Mutex).Lock")}))
+	require.Equal(t, "mutex", otlpProfileType(&otlpprofile.Converted{Profile: contention("runtime.chanrecv1"),
+		Attributes: map[string]string{otlpProfileTypeAttr: "mutex"}}))
+	require.Equal(t, "block", otlpProfileType(&otlpprofile.Converted{Profile: contention("sync.(*Mutex).Unlock"),
+		Resource: map[string]string{otlpProfileTypeAttr: "block"}}))
+
+	p := contention("main.main")
+	p.SampleType = []*profile.ValueType{{Type: "inuse_space", Unit: "bytes"}}
+	require.Equal(t, "heap", otlpProfileType(&otlpprofile.Converted{Profile: p}))
+}
+
+func TestRemoteWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	s := badger.NewStore(badger.DefaultOptions(dir))
+	apiServer := NewAPIServer(DefaultOptions(s))
+	e := getExpect(apiServer, t)
+
+	profileBytes, err := ioutil.ReadFile("./testdata/profile.out.testdata")
+	require.Equal(t, nil, err)
+	e.POST("/api/remote_write").
+		WithQuery("job", "cluster-a").WithQuery("host", "10.0.0.1:9000").WithQuery("app", "shop").
+		WithQuery("profile_type", "heap").WithQuery("expiration", "1h").WithQuery("lbs[env]", "prod").
+		WithBytes(profileBytes).
+		Expect().
+		Status(http.StatusOK)
+
+	metas := e.GET("/api/profile_meta/heap_inuse_space").
+		WithQuery("start_time", time.Now().Add(-1*time.Minute).Format(time.RFC3339)).
+		WithQuery("end_time", time.Now().Add(time.Minute).Format(time.RFC3339)).
+		Expect().
+		Status(http.StatusOK).JSON().Array()
+	metas.Length().Equal(1)
+	meta := metas.Element(0).Object().Value("ProfileMetas").Array().Element(0).Object()
+	meta.Value("JobName").Equal("cluster-a")
+	meta.Value("Host").Equal("10.0.0.1:9000")
+	meta.Value("App").Equal("shop")
+	meta.Value("Labels").Array().ContainsOnly(map[string]string{"Key": "env", "Value": "prod"})
+
+	// Saved and indexed at the scrape time
+	scraped := time.Now().Add(-2 * time.Hour).Truncate(time.Millisecond)
+	e.POST("/api/remote_write").
+		WithQuery("job", "cluster-b").WithQuery("host", "10.0.0.2:9000").WithQuery("profile_type", "heap").
+		WithQuery("timestamp", scraped.UnixNano()/time.Millisecond.Nanoseconds()).
+		WithBytes(profileBytes).
+		Expect().
+		Status(http.StatusOK)
+	metas = e.GET("/api/profile_meta/heap_inuse_space").
+		WithQuery("start_time", scraped.Add(-1*time.Minute).Format(time.RFC3339)).
+		WithQuery("end_time", scraped.Add(time.Minute).Format(time.RFC3339)).
+		Expect().
+		Status(http.StatusOK).JSON().Array()
+	metas.Length().Equal(1)
+	metas.Path("$[0].ProfileMetas[0].JobName").Equal("cluster-b")
+	metas.Path("$[0].ProfileMetas[0].Timestamp").Equal(scraped.UnixNano() / time.Millisecond.Nanoseconds())
+
+	e.POST("/api/remote_write").
+		WithQuery("job", "cluster-a").WithQuery("host", "10.0.0.1:9000").WithQuery("profile_type", "heap").WithQuery("timestamp", "x").
+		WithBytes(profileBytes).
+		Expect().
+		Status(http.StatusBadRequest).Text().Equal("invalid timestamp \"x\"")
+
+	e.POST("/api/remote_write").
+		WithQuery("job", "cluster-a").WithQuery("host", "10.0.0.1:9000").WithQuery("profile_type", "heap").
+		WithBytes([]byte("invalid")).
+		Expect().
+		Status(http.StatusBadRequest)
+
+	e.POST("/api/remote_write").
+		WithQuery("job", "cluster-a").WithQuery("host", "10.0.0.1:9000").WithQuery("profile_type", "heap").WithQuery("expiration", "1d").
+		WithBytes(profileBytes).
+		Expect().
+		Status(http.StatusBadRequest)
+
+	e.POST("/api/remote_write").
+		WithQuery("job", "cluster-a").
+		WithBytes(profileBytes).
+		Expect().
+		Status(http.StatusBadRequest).Text().Equal("job, host or profile_type is empty")
+}
+
+func TestRemoteWriteToken(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	s := badger.NewStore(badger.DefaultOptions(dir))
+	apiServer := NewAPIServer(DefaultOptions(s).WithRemoteWriteToken("secret"))
+	e := getExpect(apiServer, t)
+
+	profileBytes, err := ioutil.ReadFile("./testdata/profile.out.testdata")
+	require.Equal(t, nil, err)
+	write := func() *httpexpect.Request {
+		return e.POST("/api/remote_write").
+			WithQuery("job", "cluster-a").WithQuery("host", "10.0.0.1:9000").WithQuery("profile_type", "heap").
+			WithBytes(profileBytes)
+	}
+	write().Expect().Status(http.StatusUnauthorized)
+	write().WithHeader("Authorization", "Bearer wrong").Expect().Status(http.StatusUnauthorized)
+	write().WithHeader("Authorization", "Bearer secret").Expect().Status(http.StatusOK)
+}
+
+func TestFederation(t *testing.T) {
+	peerDir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(peerDir)
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	profileBytes, err := ioutil.ReadFile("./testdata/profile.out.testdata")
+	require.Equal(t, nil, err)
+	save := func(s storage.Store, host string) {
+		err := collector.SaveProfile(s, collector.Source{JobName: "shard", Host: host, App: "shard", Expiration: time.Hour}, "heap", profileBytes)
+		require.Equal(t, nil, err)
+	}
+
+	peerStore := badger.NewStore(badger.DefaultOptions(peerDir))
+	save(peerStore, "10.0.0.2:9000")
+	peer := httptest.NewServer(NewAPIServer(DefaultOptions(peerStore)).router)
+	defer peer.Close()
+
+	s := badger.NewStore(badger.DefaultOptions(dir))
+	save(s, "10.0.0.1:9000")
+	// the unreachable peer is skipped
+	peers := []Peer{{Name: "peer-a", URL: peer.URL}, {Name: "peer-b", URL: "http://127.0.0.1:1"}}
+	apiServer := NewAPIServer(DefaultOptions(s).WithPeers(peers).WithFederationTimeout(time.Second))
+	e := getExpect(apiServer, t)
+
+	metas := e.GET("/api/profile_meta/heap_inuse_space").
+		WithQuery("start_time", time.Now().Add(-1*time.Minute).Format(time.RFC3339)).
+		WithQuery("end_time", time.Now().Add(time.Minute).Format(time.RFC3339)).
+		WithQuery("lbs[_job]", "shard").
+		Expect().
+		Status(http.StatusOK).JSON().Array()
+	metas.Length().Equal(2)
+
+	var peerID string
+	for _, v := range metas.Iter() {
+		meta := v.Object().Value("ProfileMetas").Array().Element(0).Object()
+		if meta.Value("Host").String().Raw() == "10.0.0.2:9000" {
+			peerID = meta.Value("ProfileID").String().Raw()
+		}
+	}
+	require.Regexp(t, `^\d+@peer-a$`, peerID)
+
+	_, peerData, err := peerStore.GetProfile(strings.TrimSuffix(peerID, "@peer-a"))
+	require.Equal(t, nil, err)
+	e.GET(fmt.Sprintf("/api/download/%s", peerID)).
+		Expect().
+		Status(http.StatusOK).Body().Equal(string(peerData))
+	e.GET("/api/download/1000@peer-a").
+		Expect().
+		Status(http.StatusNotFound)
+	e.GET("/api/download/1@none").
+		Expect().
+		Status(http.StatusNotFound)
+
+	// The ids do not depend on the order of the peers
+	reordered := NewAPIServer(DefaultOptions(s).WithPeers([]Peer{peers[1], peers[0]}).WithFederationTimeout(time.Second))
+	getExpect(reordered, t).GET(fmt.Sprintf("/api/download/%s", peerID)).
+		Expect().
+		Status(http.StatusOK).Body().Equal(string(peerData))
+
+	// The targets, labels and sample types of the peers are listed as well
+	e.GET("/api/targets").
+		Expect().
+		Status(http.StatusOK).JSON().Array().ContainsOnly("10.0.0.1:9000", "10.0.0.2:9000")
+	e.GET("/api/sample_types").
+		Expect().
+		Status(http.StatusOK).JSON().Array().Contains("heap_inuse_space")
+	e.GET("/api/group_labels").
+		Expect().
+		Status(http.StatusOK).JSON().Object().Value("_host").Array().Length().Equal(2)
+
+	// The peers only list their local profiles
+	getExpect(apiServer, t).GET("/api/federate/profile_meta/heap_inuse_space").
+		WithQuery("start_time", time.Now().Add(-1*time.Minute).Format(time.RFC3339Nano)).
+		WithQuery("end_time", time.Now().Add(time.Minute).Format(time.RFC3339Nano)).
+		Expect().
+		Status(http.StatusOK).JSON().Array().Length().Equal(1)
+	getExpect(apiServer, t).GET("/api/federate/targets").
+		Expect().
+		Status(http.StatusOK).JSON().Array().ContainsOnly("10.0.0.1:9000")
+}
+
+func TestParsePeer(t *testing.T) {
+	peer, err := ParsePeer("http://cprofiler-1:8080")
+	require.Equal(t, nil, err)
+	require.Equal(t, Peer{Name: "cprofiler-1:8080", URL: "http://cprofiler-1:8080"}, peer)
+
+	peer, err = ParsePeer("shard-1=http://10.0.0.1:8080/")
+	require.Equal(t, nil, err)
+	require.Equal(t, Peer{Name: "shard-1", URL: "http://10.0.0.1:8080/"}, peer)
+
+	for _, invalid := range []string{"cprofiler-1:8080", "a+b=http://cprofiler-1:8080", "a@b=http://cprofiler-1:8080", "a=b"} {
+		_, err = ParsePeer(invalid)
+		require.NotEqual(t, nil, err, invalid)
+	}
+}
@@ -0,0 +1,150 @@
+package apiserver
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"cprofiler/pkg/apiserver/ui/pprof"
+	"cprofiler/pkg/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// debugLookback The time range looking back from now for the latest profile
+const debugLookback = 7 * 24 * time.Hour
+
+// debugPProf Serve the profiles of a job or host like /debug/pprof/<type> of net/http/pprof,
+// so that go tool pprof can fetch them directly.
+// It is the latest profile of type, or the merged profile in the time range from and to
+func (s *APIServer) debugPProf(c *gin.Context) {
+	profileType := c.Param("type")
+
+	// The profiles must match both the job and the host
+	filters := make([]storage.LabelFilter, 0, 2)
+	if job := c.Query("job"); job != "" {
+		filters = append(filters, storage.LabelFilter{Label: storage.Label{Key: storage.JobLabel, Value: job}, Condition: storage.FilterAND})
+	}
+	if host := c.Query("host"); host != "" {
+		filters = append(filters, storage.LabelFilter{Label: storage.Label{Key: storage.HostLabel, Value: host}, Condition: storage.FilterAND})
+	}
+	if len(filters) == 0 {
+		c.String(http.StatusBadRequest, "job or host is empty")
+		return
+	}
+
+	merge := c.Query("from") != ""
+	endTime := time.Now()
+	startTime := endTime.Add(-debugLookback)
+	var err error
+	if merge {
+		if startTime, err = time.Parse(time.RFC3339, c.Query("from")); err != nil {
+			c.String(http.StatusBadRequest, "%s ,%s", "The time format must be RFC3339", err.Error())
+			return
+		}
+	}
+	if c.Query("to") != "" {
+		if endTime, err = time.Parse(time.RFC3339, c.Query("to")); err != nil {
+			c.String(http.StatusBadRequest, "%s ,%s", "The time format must be RFC3339", err.Error())
+			return
+		}
+	}
+	if merge && profileType == "trace" {
+		c.String(http.StatusBadRequest, "trace can not be merged")
+		return
+	}
+
+	sampleType, err := s.sampleTypeOf(profileType)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if sampleType == "" {
+		c.String(http.StatusNotFound, "Profile not found")
+		return
+	}
+
+	ids, ok := s.profileIDs(c, sampleType, startTime, endTime, filters)
+	if !ok {
+		return
+	}
+
+	c.Writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", profileType))
+	if !merge {
+		_, data, err := s.store.GetProfile(ids[len(ids)-1])
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		c.Data(http.StatusOK, "application/octet-stream", data)
+		return
+	}
+
+	if len(ids) > pprof.MaxMerge {
+		c.String(http.StatusBadRequest, "%d profiles in the time range, more than %d", len(ids), pprof.MaxMerge)
+		return
+	}
+	p, ok := s.fetchProfile(c, strings.Join(ids, pprof.MergeSep))
+	if !ok {
+		return
+	}
+	writeExport(c, profileType, formatPProf, p, "")
+}
+
+// sampleTypeOf Return a sample type of the profile type, the sample types of a profile share its id.
+// Empty if no profile of the type is collected
+func (s *APIServer) sampleTypeOf(profileType string) (string, error) {
+	sampleTypes, err := s.store.ListSampleType()
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(sampleTypes)
+
+	res := ""
+	for _, sampleType := range sampleTypes {
+		if sampleType == profileType {
+			return sampleType, nil
+		}
+		// The trace summary metrics are not the trace
+		if res == "" && profileType != "trace" && strings.HasPrefix(sampleType, profileType+"_") {
+			res = sampleType
+		}
+	}
+	return res, nil
+}
+
+// profileIDs List the distinct profile ids of sample type in the time range from the oldest to the latest,
+// write the error response if failed or none is found
+func (s *APIServer) profileIDs(c *gin.Context, sampleType string, startTime, endTime time.Time, filters []storage.LabelFilter) ([]string, bool) {
+	metaByTargets, err := s.store.ListProfileMeta(sampleType, startTime, endTime, filters...)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+
+	metas := make([]*storage.ProfileMeta, 0)
+	seen := make(map[string]bool)
+	for _, metaByTarget := range metaByTargets {
+		for _, meta := range metaByTarget.ProfileMetas {
+			if !seen[meta.ProfileID] {
+				seen[meta.ProfileID] = true
+				metas = append(metas, meta)
+			}
+		}
+	}
+	if len(metas) == 0 {
+		c.String(http.StatusNotFound, "Profile not found")
+		return nil, false
+	}
+
+	sort.SliceStable(metas, func(i, j int) bool {
+		return metas[i].Timestamp < metas[j].Timestamp
+	})
+	ids := make([]string, 0, len(metas))
+	for _, meta := range metas {
+		ids = append(ids, meta.ProfileID)
+	}
+	return ids, true
+}
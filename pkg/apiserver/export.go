@@ -0,0 +1,77 @@
+package apiserver
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+
+	"cprofiler/pkg/profiles"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/pprof/profile"
+)
+
+// The download formats
+const (
+	formatPProf      = "pprof"
+	formatFolded     = "folded"
+	formatSpeedscope = "speedscope"
+)
+
+var formats = []string{formatPProf, formatFolded, formatSpeedscope}
+
+func validFormat(format string) bool {
+	for _, f := range formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// writeExport Write p in format as an attachment, the samples are filtered by the pprof labels from query.
+// si selects the sample type of folded, and of speedscope if it is not empty, or else all sample types are exported
+func writeExport(c *gin.Context, filename, format string, p *profile.Profile, si string) {
+	p = profiles.FilterByLabels(p, sampleLabelsQuery(c))
+
+	b := &bytes.Buffer{}
+	switch format {
+	case formatPProf:
+		if err := p.Write(b); err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		c.Writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment;filename=%s.prof", filename))
+		c.Data(http.StatusOK, "application/octet-stream", b.Bytes())
+
+	case formatFolded:
+		sampleIndex, err := profiles.SampleIndex(p, si)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		if err = profiles.WriteFolded(b, p, sampleIndex); err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		c.Writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment;filename=%s.folded", filename))
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", b.Bytes())
+
+	case formatSpeedscope:
+		sampleIndexes := make([]int, 0, len(p.SampleType))
+		if si == "" {
+			for i := range p.SampleType {
+				sampleIndexes = append(sampleIndexes, i)
+			}
+		} else {
+			sampleIndex, err := profiles.SampleIndex(p, si)
+			if err != nil {
+				c.String(http.StatusBadRequest, err.Error())
+				return
+			}
+			sampleIndexes = append(sampleIndexes, sampleIndex)
+		}
+		c.Writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment;filename=%s.speedscope.json", filename))
+		c.JSON(http.StatusOK, profiles.ToSpeedscope(p, filename, sampleIndexes...))
+	}
+}
@@ -0,0 +1,343 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cprofiler/pkg/storage"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// peerIDSep Separates the profile id of a peer from the name of the peer, as id@peer
+	peerIDSep = "@"
+
+	// profileNameHeader The profile name of the federated profile data
+	profileNameHeader = "X-Cprofiler-Profile-Name"
+)
+
+// peerNameReg The valid peer names, which are in the profile ids of the peer, like the profile ids in the ui paths
+var peerNameReg = regexp.MustCompile(`^[\w.:-]+$`)
+
+// Peer A peer replica of the query federation
+type Peer struct {
+	// Name The name in the ids of the peer profiles, it must be the same on all replicas
+	Name string
+	// URL The base url of the peer
+	URL string
+}
+
+// ParsePeer Parse the peer of name=url, the name is the host of the url if it is omitted
+func ParsePeer(s string) (Peer, error) {
+	peer := Peer{URL: s}
+	if i := strings.Index(s, "="); i >= 0 {
+		peer.Name, peer.URL = s[:i], s[i+1:]
+	}
+	u, err := url.Parse(peer.URL)
+	if err != nil {
+		return peer, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return peer, fmt.Errorf("invalid peer url %q", peer.URL)
+	}
+	if peer.Name == "" {
+		peer.Name = u.Host
+	}
+	if !peerNameReg.MatchString(peer.Name) {
+		return peer, fmt.Errorf("invalid peer name %q", peer.Name)
+	}
+	return peer, nil
+}
+
+// federatedStore Query the profiles, targets, labels and sample types of the local store and the peer replicas.
+// The profiles of a peer have ids of id@peer, where peer is the name of the peer.
+// The peers are queried by the /api/federate apis, which only query their local store
+type federatedStore struct {
+	storage.Store
+	peers      []Peer
+	httpClient *http.Client
+}
+
+func newFederatedStore(local storage.Store, peers []Peer, timeout time.Duration) *federatedStore {
+	return &federatedStore{
+		Store:      local,
+		peers:      peers,
+		httpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+// get Get the federate api of the peer, and decode the json response into v
+func (s *federatedStore) get(peer Peer, path string, query url.Values, v interface{}) (http.Header, []byte, error) {
+	u := strings.TrimSuffix(peer.URL, "/") + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	resp, err := s.httpClient.Get(u)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil, storage.ErrProfileNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("peer %s: http resp status code is %d: %s", peer.Name, resp.StatusCode, b)
+	}
+	if v != nil {
+		return resp.Header, b, json.Unmarshal(b, v)
+	}
+	return resp.Header, b, nil
+}
+
+// queryPeers Get the federate api of all peers in parallel, newResult returns the value to decode the response
+// of the peer into. It returns whether each peer succeeded, a failed peer is logged and skipped
+func (s *federatedStore) queryPeers(path string, query url.Values, newResult func(i int) interface{}) []bool {
+	ok := make([]bool, len(s.peers))
+	wg := sync.WaitGroup{}
+	for i := range s.peers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, _, err := s.get(s.peers[i], path, query, newResult(i)); err != nil {
+				log.WithError(err).Warnf("query peer %s %s error", s.peers[i].Name, path)
+				return
+			}
+			ok[i] = true
+		}(i)
+	}
+	wg.Wait()
+	return ok
+}
+
+// GetProfile Get the profile from the peer of the id, or the local store
+func (s *federatedStore) GetProfile(id string) (string, []byte, error) {
+	i := strings.LastIndex(id, peerIDSep)
+	if i < 0 {
+		return s.Store.GetProfile(id)
+	}
+	for _, peer := range s.peers {
+		if peer.Name != id[i+1:] {
+			continue
+		}
+		header, data, err := s.get(peer, "/api/federate/profile/"+url.PathEscape(id[:i]), nil, nil)
+		if err != nil {
+			return "", nil, err
+		}
+		return header.Get(profileNameHeader), data, nil
+	}
+	return "", nil, storage.ErrProfileNotFound
+}
+
+// ListProfileMeta List the profile metas of the local store and all peers, the metas of the same target are merged.
+// A failed peer is logged and skipped, so the others are still listed
+func (s *federatedStore) ListProfileMeta(sampleType string, startTime, endTime time.Time, filters ...storage.LabelFilter) ([]*storage.ProfileMetaByTarget, error) {
+	local, err := s.Store.ListProfileMeta(sampleType, startTime, endTime, filters...)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	query.Set("start_time", startTime.Format(time.RFC3339Nano))
+	query.Set("end_time", endTime.Format(time.RFC3339Nano))
+	if len(filters) > 0 {
+		b, err := json.Marshal(filters)
+		if err != nil {
+			return nil, err
+		}
+		query.Set("filters", string(b))
+	}
+
+	results := make([][]*storage.ProfileMetaByTarget, len(s.peers))
+	succeeded := s.queryPeers("/api/federate/profile_meta/"+url.PathEscape(sampleType), query, func(i int) interface{} {
+		return &results[i]
+	})
+
+	targets := make(map[string]*storage.ProfileMetaByTarget, len(local))
+	for _, target := range local {
+		targets[target.TargetName] = target
+	}
+	for i, res := range results {
+		if !succeeded[i] {
+			continue
+		}
+		for _, target := range res {
+			for _, meta := range target.ProfileMetas {
+				meta.ProfileID = meta.ProfileID + peerIDSep + s.peers[i].Name
+			}
+			if t, ok := targets[target.TargetName]; ok {
+				t.ProfileMetas = append(t.ProfileMetas, target.ProfileMetas...)
+				continue
+			}
+			targets[target.TargetName] = target
+			local = append(local, target)
+		}
+	}
+	return local, nil
+}
+
+// ListSampleType List the sample types of the local store and all peers
+func (s *federatedStore) ListSampleType() ([]string, error) {
+	local, err := s.Store.ListSampleType()
+	if err != nil {
+		return nil, err
+	}
+	return s.mergeStrings(local, "/api/federate/sample_types"), nil
+}
+
+// ListTarget List the targets of the local store and all peers
+func (s *federatedStore) ListTarget() ([]string, error) {
+	local, err := s.Store.ListTarget()
+	if err != nil {
+		return nil, err
+	}
+	return s.mergeStrings(local, "/api/federate/targets"), nil
+}
+
+// ListLabel List the labels of the local store and all peers
+func (s *federatedStore) ListLabel() ([]storage.Label, error) {
+	local, err := s.Store.ListLabel()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([][]storage.Label, len(s.peers))
+	succeeded := s.queryPeers("/api/federate/labels", nil, func(i int) interface{} {
+		return &results[i]
+	})
+	seen := make(map[storage.Label]bool, len(local))
+	for _, label := range local {
+		seen[label] = true
+	}
+	for i, res := range results {
+		if !succeeded[i] {
+			continue
+		}
+		for _, label := range res {
+			if !seen[label] {
+				seen[label] = true
+				local = append(local, label)
+			}
+		}
+	}
+	sort.Slice(local, func(i, j int) bool {
+		if local[i].Key != local[j].Key {
+			return local[i].Key < local[j].Key
+		}
+		return local[i].Value < local[j].Value
+	})
+	return local, nil
+}
+
+// mergeStrings Merge the local values with the values of the federate api of all peers, sorted and deduplicated
+func (s *federatedStore) mergeStrings(local []string, path string) []string {
+	results := make([][]string, len(s.peers))
+	succeeded := s.queryPeers(path, nil, func(i int) interface{} {
+		return &results[i]
+	})
+	seen := make(map[string]bool, len(local))
+	for _, v := range local {
+		seen[v] = true
+	}
+	for i, res := range results {
+		if !succeeded[i] {
+			continue
+		}
+		for _, v := range res {
+			if !seen[v] {
+				seen[v] = true
+				local = append(local, v)
+			}
+		}
+	}
+	sort.Strings(local)
+	return local
+}
+
+// federateProfileMeta List the profile metas of the local store for the federated peers
+func (s *APIServer) federateProfileMeta(c *gin.Context) {
+	startTime, err := time.Parse(time.RFC3339Nano, c.Query("start_time"))
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	endTime, err := time.Parse(time.RFC3339Nano, c.Query("end_time"))
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	var filters []storage.LabelFilter
+	if f := c.Query("filters"); f != "" {
+		if err = json.Unmarshal([]byte(f), &filters); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	res, err := s.opt.Store.ListProfileMeta(c.Param("sample_type"), startTime, endTime, filters...)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// federateSampleTypes List the sample types of the local store for the federated peers
+func (s *APIServer) federateSampleTypes(c *gin.Context) {
+	res, err := s.opt.Store.ListSampleType()
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// federateTargets List the targets of the local store for the federated peers
+func (s *APIServer) federateTargets(c *gin.Context) {
+	res, err := s.opt.Store.ListTarget()
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// federateLabels List the labels of the local store for the federated peers
+func (s *APIServer) federateLabels(c *gin.Context) {
+	res, err := s.opt.Store.ListLabel()
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// federateProfile Get the profile data of the local store for the federated peers
+func (s *APIServer) federateProfile(c *gin.Context) {
+	name, data, err := s.opt.Store.GetProfile(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, storage.ErrProfileNotFound) {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Header(profileNameHeader, name)
+	c.Data(http.StatusOK, "application/octet-stream", data)
+}
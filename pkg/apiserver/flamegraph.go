@@ -0,0 +1,137 @@
+package apiserver
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"cprofiler/pkg/apiserver/ui/pprof"
+	"cprofiler/pkg/profiles"
+	"cprofiler/pkg/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/pprof/profile"
+)
+
+// FlameGraph The flame graph of a sample type
+type FlameGraph struct {
+	SampleType string
+	Unit       string
+	Root       *profiles.FlameNode
+}
+
+// flameGraph The flame graph of the profile id, which can also be the profile ids joined by "+"
+func (s *APIServer) flameGraph(c *gin.Context) {
+	p, ok := s.fetchProfile(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	s.writeFlameGraph(c, p, c.Query("si"))
+}
+
+// mergedFlameGraph The flame graph of the merged profiles of sample type in the time range
+func (s *APIServer) mergedFlameGraph(c *gin.Context) {
+	sampleType := c.Param("sample_type")
+	p, ok := s.mergedProfile(c, sampleType)
+	if !ok {
+		return
+	}
+
+	si := c.Query("si")
+	if si == "" {
+		si = sampleType
+	}
+	s.writeFlameGraph(c, p, si)
+}
+
+// writeFlameGraph Build the flame graph of p with the options from query
+func (s *APIServer) writeFlameGraph(c *gin.Context, p *profile.Profile, si string) {
+	var (
+		opt profiles.FlameGraphOptions
+		err error
+	)
+	if opt.SampleIndex, err = profiles.SampleIndex(p, si); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if opt.Focus, err = regexpQuery(c, "focus"); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if opt.Ignore, err = regexpQuery(c, "ignore"); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if v := c.Query("node_fraction"); v != "" {
+		if opt.NodeFraction, err = strconv.ParseFloat(v, 64); err != nil || opt.NodeFraction < 0 || opt.NodeFraction > 1 {
+			c.String(http.StatusBadRequest, "node_fraction must be a number between 0 and 1")
+			return
+		}
+	}
+	if v := c.Query("max_depth"); v != "" {
+		if opt.MaxDepth, err = strconv.Atoi(v); err != nil || opt.MaxDepth < 0 {
+			c.String(http.StatusBadRequest, "max_depth must be a non-negative integer")
+			return
+		}
+	}
+
+	p = profiles.FilterByLabels(p, sampleLabelsQuery(c))
+	c.JSON(http.StatusOK, &FlameGraph{
+		SampleType: p.SampleType[opt.SampleIndex].Type,
+		Unit:       p.SampleType[opt.SampleIndex].Unit,
+		Root:       profiles.FlameGraph(p, opt),
+	})
+}
+
+// regexpQuery Compile the regexp from query key, nil if it is empty
+func regexpQuery(c *gin.Context, key string) (*regexp.Regexp, error) {
+	expr := c.Query(key)
+	if expr == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return re, nil
+}
+
+// fetchProfile Get the profile by id, or the merged profile of the ids joined by "+", write the error response if failed
+func (s *APIServer) fetchProfile(c *gin.Context, src string) (*profile.Profile, bool) {
+	p, _, err := pprof.NewFetcher(s.store).Fetch(src, 0, 0)
+	if err != nil {
+		if errors.Is(err, storage.ErrProfileNotFound) {
+			c.String(http.StatusNotFound, "Profile not found")
+			return nil, false
+		}
+		c.String(http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+	return p, true
+}
+
+// mergedProfile Get the merged profile of sample type in the time range, with the label filters from query,
+// write the error response if failed
+func (s *APIServer) mergedProfile(c *gin.Context, sampleType string) (*profile.Profile, bool) {
+	startTime, endTime, ok := timeRangeQuery(c)
+	if !ok {
+		return nil, false
+	}
+	filters, ok := labelFiltersQuery(c)
+	if !ok {
+		return nil, false
+	}
+
+	ids, ok := s.profileIDs(c, sampleType, startTime, endTime, filters)
+	if !ok {
+		return nil, false
+	}
+	if len(ids) > pprof.MaxMerge {
+		c.String(http.StatusBadRequest, "%d profiles in the time range, more than %d", len(ids), pprof.MaxMerge)
+		return nil, false
+	}
+	return s.fetchProfile(c, strings.Join(ids, pprof.MergeSep))
+}
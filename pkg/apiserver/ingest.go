@@ -0,0 +1,228 @@
+package apiserver
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cprofiler/pkg/collector"
+	"cprofiler/pkg/profiles"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/pprof/profile"
+)
+
+// maxIngestBytes The max size of a pushed profile
+const maxIngestBytes = 64 << 20
+
+// ingestProfileTypes The cprofiler profile types of the pyroscope profile name suffixes
+var ingestProfileTypes = map[string]string{
+	"cpu":            "profile",
+	"itimer":         "profile",
+	"alloc_objects":  "heap",
+	"alloc_space":    "heap",
+	"inuse_objects":  "heap",
+	"inuse_space":    "heap",
+	"goroutines":     "goroutine",
+	"mutex_count":    "mutex",
+	"mutex_duration": "mutex",
+	"block_count":    "block",
+	"block_duration": "block",
+}
+
+// ingestSampleTypes The go pprof sample types of the pyroscope profile name suffixes,
+// the other suffixes are the same as the sample types
+var ingestSampleTypes = map[string]string{
+	"goroutines":     "goroutine",
+	"mutex_count":    "contentions",
+	"mutex_duration": "delay",
+	"block_count":    "contentions",
+	"block_duration": "delay",
+}
+
+// ingestName The parsed pyroscope name app.suffix{key=value,...}
+type ingestName struct {
+	App         string
+	Suffix      string
+	ProfileType string
+	SampleType  string
+	Labels      collector.LabelConfig
+}
+
+// parseIngestName Parse the pyroscope name, the suffix is split from the app if it is a known profile type
+func parseIngestName(name string) (*ingestName, error) {
+	res := &ingestName{App: name, ProfileType: "profile", Labels: collector.LabelConfig{}}
+	if i := strings.IndexByte(name, '{'); i >= 0 {
+		if !strings.HasSuffix(name, "}") {
+			return nil, fmt.Errorf("invalid name %q", name)
+		}
+		res.App = name[:i]
+		for _, pair := range strings.Split(name[i+1:len(name)-1], ",") {
+			pair = strings.TrimSpace(pair)
+			if pair == "" {
+				continue
+			}
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 || kv[0] == "" {
+				return nil, fmt.Errorf("invalid label %q", pair)
+			}
+			res.Labels[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+
+	if i := strings.LastIndexByte(res.App, '.'); i >= 0 {
+		if profileType, ok := ingestProfileTypes[res.App[i+1:]]; ok {
+			res.Suffix = res.App[i+1:]
+			res.ProfileType = profileType
+			res.SampleType = res.Suffix
+			if sampleType, ok := ingestSampleTypes[res.Suffix]; ok {
+				res.SampleType = sampleType
+			}
+			res.App = res.App[:i]
+		}
+	}
+	if res.App == "" {
+		return nil, fmt.Errorf("invalid name %q", name)
+	}
+	return res, nil
+}
+
+// ingest Receive the profiles pushed by the pyroscope clients, in pprof, collapsed (folded) or lines format.
+// The app of the name is the job, and the host is the label hostname or the client ip
+func (s *APIServer) ingest(c *gin.Context) {
+	name, err := parseIngestName(c.Query("name"))
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxIngestBytes)
+	var body io.Reader = c.Request.Body
+	format := c.Query("format")
+	if strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		file, err := c.FormFile("profile")
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		f, err := file.Open()
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		defer f.Close()
+		body = f
+		if format == "" {
+			format = "pprof"
+		}
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var p *profile.Profile
+	switch format {
+	case "pprof":
+		p, err = profile.ParseData(data)
+	case "", "folded":
+		p, err = parseIngestFolded(c, name, data)
+	case "lines":
+		p, err = parseIngestFolded(c, name, linesToFolded(data))
+	default:
+		c.String(http.StatusBadRequest, "unsupported format %q", format)
+		return
+	}
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	from, _ := strconv.ParseInt(c.Query("from"), 10, 64)
+	until, _ := strconv.ParseInt(c.Query("until"), 10, 64)
+	if from > 0 && p.TimeNanos == 0 {
+		p.TimeNanos = from * time.Second.Nanoseconds()
+	}
+	if from > 0 && until > from && p.DurationNanos == 0 {
+		p.DurationNanos = (until - from) * time.Second.Nanoseconds()
+	}
+
+	b := &bytes.Buffer{}
+	if err = p.Write(b); err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	host := name.Labels["hostname"]
+	if host == "" {
+		host = c.ClientIP()
+	}
+	err = collector.SaveProfile(s.store, collector.Source{
+		JobName:    name.App,
+		Host:       host,
+		App:        name.App,
+		Labels:     name.Labels,
+		Expiration: s.opt.IngestExpiration,
+		Pushed:     true,
+	}, name.ProfileType, b.Bytes())
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// linesToFolded Convert the lines format, one stack per line without value, to the collapsed format
+func linesToFolded(data []byte) []byte {
+	b := &bytes.Buffer{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		b.WriteString(line)
+		b.WriteString(" 1\n")
+	}
+	return b.Bytes()
+}
+
+// parseIngestFolded Parse the collapsed profile, the sample type is the go pprof sample type of the name suffix, or the units.
+// The cpu samples are also converted to nanoseconds by sampleRate, as the go cpu profile
+func parseIngestFolded(c *gin.Context, name *ingestName, data []byte) (*profile.Profile, error) {
+	units := c.DefaultQuery("units", "samples")
+	sampleType := name.SampleType
+	if sampleType == "" {
+		sampleType = units
+	}
+
+	unit := "count"
+	switch units {
+	case "bytes":
+		unit = "bytes"
+	case "lock_nanoseconds":
+		unit = "nanoseconds"
+	}
+
+	if name.ProfileType == "profile" && units == "samples" {
+		sampleRate, err := strconv.ParseInt(c.DefaultQuery("sampleRate", "100"), 10, 64)
+		if err != nil || sampleRate <= 0 {
+			return nil, fmt.Errorf("invalid sampleRate %q", c.Query("sampleRate"))
+		}
+		period := time.Second.Nanoseconds() / sampleRate
+		p, err := profiles.ParseFolded(bytes.NewReader(data),
+			[]*profile.ValueType{{Type: "samples", Unit: "count"}, {Type: "cpu", Unit: "nanoseconds"}}, []int64{1, period})
+		if err != nil {
+			return nil, err
+		}
+		p.PeriodType = &profile.ValueType{Type: "cpu", Unit: "nanoseconds"}
+		p.Period = period
+		return p, nil
+	}
+	return profiles.ParseFolded(bytes.NewReader(data), []*profile.ValueType{{Type: sampleType, Unit: unit}}, []int64{1})
+}
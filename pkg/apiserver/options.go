@@ -3,6 +3,8 @@ package apiserver
 import (
 	"time"
 
+	"cprofiler/pkg/apiserver/ui"
+	"cprofiler/pkg/collector"
 	"cprofiler/pkg/storage"
 )
 
@@ -10,6 +12,41 @@ type Options struct {
 	Addr       string
 	GCInternal time.Duration
 	Store      storage.Store
+	// UIMaxEntries The max number of loaded profiles of each ui, 0 means no limit
+	UIMaxEntries int
+	// UIMaxBytes The max total data size of loaded profiles of each ui, 0 means no limit
+	UIMaxBytes int64
+	// UILoadWait The time a ui request waits for loading its profile, then it gets a progress page
+	UILoadWait time.Duration
+	// WarmInternal The internal of warming the latest profiles into the ui caches, 0 means no warming
+	WarmInternal time.Duration
+	// WarmCount The number of the latest profiles of each job and profile type to warm
+	WarmCount int
+	// WarmMaxBytes The max total data size of the profiles warmed each time, 0 means no limit
+	WarmMaxBytes int64
+	// WarmLookback The profiles scraped in the time range looking back from now are warmed,
+	// it should be longer than the longest scrape interval
+	WarmLookback time.Duration
+	// IngestExpiration The expiration of the profiles pushed to /ingest
+	IngestExpiration time.Duration
+	// OTLPResourceLabels The OTLP resource attributes recorded as the labels of the pushed profiles
+	OTLPResourceLabels []string
+	// RemoteWriteToken The bearer token /api/remote_write requires, empty means no authentication
+	RemoteWriteToken string
+	// Peers The peer replicas, the profiles of all replicas are queried if it is not empty
+	Peers []Peer
+	// FederationTimeout The timeout of querying a peer
+	FederationTimeout time.Duration
+	// ScrapeStatus Get the scrape status of the collectors, nil if the collectors are not run
+	ScrapeStatus func() []collector.ScrapeStatus
+}
+
+// DefaultOTLPResourceLabels The OTLP resource attributes with bounded values recorded as labels by default
+var DefaultOTLPResourceLabels = []string{
+	"deployment.environment", "deployment.environment.name",
+	"service.namespace", "service.version",
+	"k8s.cluster.name", "k8s.namespace.name",
+	"cloud.region", "cloud.availability_zone",
 }
 
 func DefaultOptions(store storage.Store) Options {
@@ -17,6 +54,20 @@ func DefaultOptions(store storage.Store) Options {
 		Store:      store,
 		Addr:       ":8080",
 		GCInternal: 2 * time.Minute,
+
+		UIMaxEntries: ui.DefaultOptions().MaxEntries,
+		UIMaxBytes:   ui.DefaultOptions().MaxBytes,
+		UILoadWait:   ui.DefaultOptions().LoadWait,
+
+		WarmCount:    1,
+		WarmMaxBytes: 256 << 20,
+		WarmLookback: 2 * time.Hour,
+
+		IngestExpiration: 7 * 24 * time.Hour,
+
+		OTLPResourceLabels: DefaultOTLPResourceLabels,
+
+		FederationTimeout: 30 * time.Second,
 	}
 }
 
@@ -29,3 +80,76 @@ func (opt Options) WithGCInternal(internal time.Duration) Options {
 	opt.GCInternal = internal
 	return opt
 }
+
+func (opt Options) WithUIMaxEntries(n int) Options {
+	opt.UIMaxEntries = n
+	return opt
+}
+
+func (opt Options) WithUIMaxBytes(n int64) Options {
+	opt.UIMaxBytes = n
+	return opt
+}
+
+func (opt Options) WithUILoadWait(wait time.Duration) Options {
+	opt.UILoadWait = wait
+	return opt
+}
+
+func (opt Options) WithWarmInternal(internal time.Duration) Options {
+	opt.WarmInternal = internal
+	return opt
+}
+
+func (opt Options) WithWarmCount(n int) Options {
+	opt.WarmCount = n
+	return opt
+}
+
+func (opt Options) WithWarmMaxBytes(n int64) Options {
+	opt.WarmMaxBytes = n
+	return opt
+}
+
+func (opt Options) WithWarmLookback(lookback time.Duration) Options {
+	opt.WarmLookback = lookback
+	return opt
+}
+
+func (opt Options) WithIngestExpiration(expiration time.Duration) Options {
+	opt.IngestExpiration = expiration
+	return opt
+}
+
+func (opt Options) WithOTLPResourceLabels(labels []string) Options {
+	opt.OTLPResourceLabels = labels
+	return opt
+}
+
+func (opt Options) WithRemoteWriteToken(token string) Options {
+	opt.RemoteWriteToken = token
+	return opt
+}
+
+func (opt Options) WithPeers(peers []Peer) Options {
+	opt.Peers = peers
+	return opt
+}
+
+func (opt Options) WithFederationTimeout(timeout time.Duration) Options {
+	opt.FederationTimeout = timeout
+	return opt
+}
+
+func (opt Options) WithScrapeStatus(fn func() []collector.ScrapeStatus) Options {
+	opt.ScrapeStatus = fn
+	return opt
+}
+
+func (opt Options) uiOptions() ui.Options {
+	return ui.DefaultOptions().
+		WithGCInternal(opt.GCInternal).
+		WithMaxEntries(opt.UIMaxEntries).
+		WithMaxBytes(opt.UIMaxBytes).
+		WithLoadWait(opt.UILoadWait)
+}
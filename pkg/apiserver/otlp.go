@@ -0,0 +1,168 @@
+package apiserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"cprofiler/pkg/collector"
+	"cprofiler/pkg/internal/otlpprofile"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/pprof/profile"
+)
+
+const (
+	// otlpProfilesPath The OTLP/HTTP profiles export path
+	otlpProfilesPath = "/v1development/profiles"
+
+	// otlpProfileTypeAttr The profile or resource attribute overriding the profile type of the converted profiles
+	otlpProfileTypeAttr = "cprofiler.profile_type"
+)
+
+// otlpProfileType The cprofiler profile type of a converted profile, by the profile type attribute of the profile
+// or its resource, or else by its sample types
+func otlpProfileType(cp *otlpprofile.Converted) string {
+	if v := cp.Attributes[otlpProfileTypeAttr]; v != "" {
+		return v
+	}
+	if v := cp.Resource[otlpProfileTypeAttr]; v != "" {
+		return v
+	}
+
+	p := cp.Profile
+	for _, st := range p.SampleType {
+		switch {
+		case strings.HasPrefix(st.Type, "alloc_"), strings.HasPrefix(st.Type, "inuse_"):
+			return "heap"
+		case st.Type == "goroutine", st.Type == "goroutines":
+			return "goroutine"
+		case st.Type == "contentions", st.Type == "delay":
+			if isMutexProfile(p) {
+				return "mutex"
+			}
+			return "block"
+		}
+	}
+	return "profile"
+}
+
+// isMutexProfile Whether the contention profile is a mutex profile, by the period type,
+// or else by the leaf frames, as the go mutex profile records the stacks of the unlocks
+func isMutexProfile(p *profile.Profile) bool {
+	if p.PeriodType != nil {
+		switch p.PeriodType.Type {
+		case "mutex":
+			return true
+		case "block":
+			return false
+		}
+	}
+	for _, s := range p.Sample {
+		if len(s.Location) == 0 || len(s.Location[0].Line) == 0 || s.Location[0].Line[0].Function == nil {
+			continue
+		}
+		name := s.Location[0].Line[0].Function.Name
+		if strings.HasSuffix(name, "Unlock") || name == "runtime.unlock" {
+			return true
+		}
+	}
+	return false
+}
+
+// otlpProfiles Receive the OTLP profiles export requests in protobuf, the profiles are converted to pprof.
+// The resource attribute service.name is the job, host.name is the host or the client ip,
+// and the resource attributes in OTLPResourceLabels are the labels.
+// All profiles of a request are checked first and then saved in one transaction,
+// so a request is saved entirely or not at all, and a retried failed request is not saved twice
+func (s *APIServer) otlpProfiles(c *gin.Context) {
+	if c.ContentType() != "application/x-protobuf" {
+		c.String(http.StatusUnsupportedMediaType, "unsupported content type %q, only application/x-protobuf is supported", c.ContentType())
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxIngestBytes)
+	var body io.Reader = c.Request.Body
+	switch c.GetHeader("Content-Encoding") {
+	case "", "identity":
+	case "gzip":
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		defer gz.Close()
+		body = io.LimitReader(gz, maxIngestBytes)
+	default:
+		c.String(http.StatusUnsupportedMediaType, "unsupported content encoding %q", c.GetHeader("Content-Encoding"))
+		return
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	req, err := otlpprofile.DecodeExportRequest(data)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	converted, err := otlpprofile.Convert(req)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	resourceLabels := make(map[string]bool, len(s.opt.OTLPResourceLabels))
+	for _, k := range s.opt.OTLPResourceLabels {
+		resourceLabels[k] = true
+	}
+
+	prepared := make([]*collector.PreparedProfile, 0, len(converted))
+	for _, cp := range converted {
+		src := collector.Source{
+			JobName:    cp.Resource["service.name"],
+			Host:       cp.Resource["host.name"],
+			Labels:     collector.LabelConfig{},
+			Expiration: s.opt.IngestExpiration,
+			Pushed:     true,
+		}
+		if src.JobName == "" {
+			src.JobName = "unknown_service"
+		}
+		src.App = src.JobName
+		if src.Host == "" {
+			src.Host = c.ClientIP()
+		}
+		// the other attributes, such as process.pid and container.id, have unbounded values
+		for k, v := range cp.Resource {
+			if resourceLabels[k] {
+				src.Labels[k] = v
+			}
+		}
+
+		b := &bytes.Buffer{}
+		if err = cp.Profile.Write(b); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		pp, err := collector.PrepareProfile(src, otlpProfileType(cp), b.Bytes())
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		prepared = append(prepared, pp)
+	}
+
+	if err = collector.SavePreparedProfiles(s.store, prepared); err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// an empty ExportProfilesServiceResponse, all profiles are accepted
+	c.Data(http.StatusOK, "application/x-protobuf", nil)
+}
@@ -0,0 +1,79 @@
+package apiserver
+
+import (
+	"crypto/subtle"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cprofiler/pkg/collector"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/pprof/profile"
+)
+
+// remoteWrite Receive the profiles forwarded by the remote write of the other cprofilers, the body is the scraped
+// profile, and the job, host, app, profile type, labels and scrape time are kept as they are scraped
+func (s *APIServer) remoteWrite(c *gin.Context) {
+	if s.opt.RemoteWriteToken != "" {
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.opt.RemoteWriteToken)) != 1 {
+			c.String(http.StatusUnauthorized, "invalid bearer token")
+			return
+		}
+	}
+
+	src := collector.Source{
+		JobName:    c.Query("job"),
+		Host:       c.Query("host"),
+		App:        c.Query("app"),
+		Labels:     collector.LabelConfig(c.QueryMap("lbs")),
+		Expiration: s.opt.IngestExpiration,
+	}
+	profileType := c.Query("profile_type")
+	if src.JobName == "" || src.Host == "" || profileType == "" {
+		c.String(http.StatusBadRequest, "job, host or profile_type is empty")
+		return
+	}
+	if expiration := c.Query("expiration"); expiration != "" {
+		d, err := time.ParseDuration(expiration)
+		if err != nil || d <= 0 {
+			c.String(http.StatusBadRequest, "invalid expiration %q", expiration)
+			return
+		}
+		src.Expiration = d
+	}
+	if timestamp := c.Query("timestamp"); timestamp != "" {
+		ms, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil || ms <= 0 {
+			c.String(http.StatusBadRequest, "invalid timestamp %q", timestamp)
+			return
+		}
+		src.Timestamp = time.Unix(0, ms*time.Millisecond.Nanoseconds())
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxIngestBytes)
+	data, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if profileType == "trace" {
+		err = collector.SaveTrace(s.store, src, profileType, data)
+	} else {
+		// an invalid profile is rejected with 400, the sender drops it instead of retrying
+		if _, err = profile.ParseData(data); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		err = collector.SaveProfile(s.store, src, profileType, data)
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Status(http.StatusOK)
+}
@@ -0,0 +1,139 @@
+package apiserver
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+
+	"cprofiler/pkg/profiles"
+	"cprofiler/pkg/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/pprof/profile"
+	log "github.com/sirupsen/logrus"
+)
+
+// LabelSeriesByTarget The sample type values of a target split by pprof label value
+type LabelSeriesByTarget struct {
+	TargetName string
+	Series     []*LabelSeries
+}
+
+// LabelSeries The sample type values of one pprof label value over time
+type LabelSeries struct {
+	LabelValue string
+	Points     []*LabelPoint
+}
+
+type LabelPoint struct {
+	ProfileID string
+	Timestamp int64
+	Value     int64
+}
+
+// listLabelSeries Split ProfileMeta.Value of sample_type by the pprof label key, one series per label value
+func (s *APIServer) listLabelSeries(c *gin.Context) {
+	sampleType := c.Param("sample_type")
+
+	key := c.Query("key")
+	if key == "" {
+		c.String(http.StatusBadRequest, "key is empty")
+		return
+	}
+
+	startTime, endTime, ok := timeRangeQuery(c)
+	if !ok {
+		return
+	}
+
+	filters, ok := labelFiltersQuery(c)
+	if !ok {
+		return
+	}
+
+	metasByTarget, err := s.store.ListProfileMeta(sampleType, startTime, endTime, filters...)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	res := make([]*LabelSeriesByTarget, 0, len(metasByTarget))
+	for _, target := range metasByTarget {
+		seriesMap := make(map[string]*LabelSeries)
+		for _, meta := range target.ProfileMetas {
+			values, err := s.splitProfileValue(meta, key)
+			if err != nil {
+				if !errors.Is(err, storage.ErrProfileNotFound) {
+					log.WithError(err).WithField("id", meta.ProfileID).Warn("split profile value error")
+				}
+				continue
+			}
+
+			for labelValue, value := range values {
+				series, ok := seriesMap[labelValue]
+				if !ok {
+					series = &LabelSeries{LabelValue: labelValue}
+					seriesMap[labelValue] = series
+				}
+				series.Points = append(series.Points, &LabelPoint{
+					ProfileID: meta.ProfileID,
+					Timestamp: meta.Timestamp,
+					Value:     value,
+				})
+			}
+		}
+
+		targetSeries := &LabelSeriesByTarget{TargetName: target.TargetName, Series: make([]*LabelSeries, 0, len(seriesMap))}
+		for _, series := range seriesMap {
+			sort.Slice(series.Points, func(i, j int) bool {
+				return series.Points[i].Timestamp < series.Points[j].Timestamp
+			})
+			targetSeries.Series = append(targetSeries.Series, series)
+		}
+		sort.Slice(targetSeries.Series, func(i, j int) bool {
+			return targetSeries.Series[i].LabelValue < targetSeries.Series[j].LabelValue
+		})
+		res = append(res, targetSeries)
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// splitProfileValue Sum the meta sample type values of the profile grouped by the pprof label key value.
+// The values recorded in the meta are used, the samples without the label are summed under the empty value.
+// The profile is parsed if some of its labels are not recorded, or the meta is saved before the values are recorded
+func (s *APIServer) splitProfileValue(meta *storage.ProfileMeta, key string) (map[string]int64, error) {
+	if len(meta.SampleLabelValues) == len(meta.SampleLabels) && !meta.SampleLabelsTruncated {
+		values := make(map[string]int64)
+		var labeled int64
+		for i, l := range meta.SampleLabels {
+			if l.Key == key {
+				values[l.Value] = meta.SampleLabelValues[i]
+				labeled += meta.SampleLabelValues[i]
+			}
+		}
+		if rest := meta.Value - labeled; rest > 0 {
+			values[""] = rest
+		}
+		return values, nil
+	}
+
+	_, data, err := s.store.GetProfile(meta.ProfileID)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := profile.ParseData(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// The only sample type of a scraped profile is named after the profile type
+	sampleIndex := 0
+	if len(p.SampleType) > 1 {
+		if sampleIndex, err = profiles.SampleIndex(p, meta.SampleType); err != nil {
+			return nil, err
+		}
+	}
+	return profiles.AggregateByLabel(p, sampleIndex, key)[key], nil
+}
@@ -0,0 +1,44 @@
+package ui
+
+import "time"
+
+type Options struct {
+	// GCInternal The profiles not visited for the internal are evicted, checked every internal
+	GCInternal time.Duration
+	// MaxEntries The max number of loaded profiles, 0 means no limit
+	MaxEntries int
+	// MaxBytes The max total size of loaded profiles, 0 means no limit.
+	// The size is the data size, or the estimated memory of the parsed UI if its driver is Weighted
+	MaxBytes int64
+	// LoadWait The time a request waits for loading its profile, then it gets a progress page
+	LoadWait time.Duration
+}
+
+func DefaultOptions() Options {
+	return Options{
+		GCInternal: 2 * time.Minute,
+		MaxEntries: 64,
+		MaxBytes:   512 << 20,
+		LoadWait:   3 * time.Second,
+	}
+}
+
+func (opt Options) WithGCInternal(internal time.Duration) Options {
+	opt.GCInternal = internal
+	return opt
+}
+
+func (opt Options) WithMaxEntries(n int) Options {
+	opt.MaxEntries = n
+	return opt
+}
+
+func (opt Options) WithMaxBytes(n int64) Options {
+	opt.MaxBytes = n
+	return opt
+}
+
+func (opt Options) WithLoadWait(wait time.Duration) Options {
+	opt.LoadWait = wait
+	return opt
+}
@@ -0,0 +1,72 @@
+package pprof
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"cprofiler/pkg/storage"
+
+	"github.com/google/pprof/profile"
+)
+
+// MergeSep Separator of the profile ids of a merged profile, such as 12+13+14
+const MergeSep = "+"
+
+// MaxMerge The max number of profiles merged into one
+const MaxMerge = 256
+
+// Fetcher Fetch profiles from store for pprof, the source is a profile id,
+// or profile ids joined by MergeSep, whose profiles are merged into a virtual profile
+type Fetcher struct {
+	store storage.Store
+	// Size The total data size of the fetched profiles
+	Size int64
+	// Err The error of the last fetching, driver.PProf does not return it
+	Err error
+}
+
+func NewFetcher(store storage.Store) *Fetcher {
+	return &Fetcher{store: store}
+}
+
+// Fetch Fetch the profile of src. The returned source is empty, or pprof would save
+// a copy of every fetched profile in $HOME/pprof as a remote one
+func (f *Fetcher) Fetch(src string, duration, timeout time.Duration) (*profile.Profile, string, error) {
+	p, err := f.fetch(src)
+	f.Err = err
+	if err != nil {
+		return nil, "", err
+	}
+	return p, "", nil
+}
+
+func (f *Fetcher) fetch(src string) (*profile.Profile, error) {
+	ids := strings.Split(src, MergeSep)
+	if len(ids) > MaxMerge {
+		return nil, fmt.Errorf("merge %d profiles, more than %d", len(ids), MaxMerge)
+	}
+
+	ps := make([]*profile.Profile, 0, len(ids))
+	for _, id := range ids {
+		if id == "" {
+			return nil, errors.New("empty profile id")
+		}
+		_, data, err := f.store.GetProfile(id)
+		if err != nil {
+			return nil, err
+		}
+		f.Size += int64(len(data))
+
+		p, err := profile.ParseData(data)
+		if err != nil {
+			return nil, fmt.Errorf("parse profile %s: %w", id, err)
+		}
+		ps = append(ps, p)
+	}
+	if len(ps) == 1 {
+		return ps[0], nil
+	}
+	return profile.Merge(ps)
+}
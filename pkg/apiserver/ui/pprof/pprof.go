@@ -1,42 +1,50 @@
 package pprof
 
 import (
-	"io/ioutil"
+	"errors"
 	"net/http"
-	"os"
 	"path"
 
+	"cprofiler/pkg/internal/pprofdriver"
+	"cprofiler/pkg/storage"
+
 	"github.com/google/pprof/driver"
+	"github.com/google/pprof/profile"
 )
 
-func Driver(basePath string, mux *http.ServeMux, id string, data []byte) error {
-	filepath := path.Join(os.TempDir(), id)
-	if err := ioutil.WriteFile(filepath, data, 0600); err != nil {
-		return err
-	}
+// MemoryWeight is the memory a parsed profile and its pprof UI take in times of the profile size, it is about 23 to 25,
+// the pprof UI counts the size of a loaded profile with it
+const MemoryWeight = 24
 
-	flags := &flags{
-		args: []string{"-http=localhost:0", "-no_browser", filepath},
+// Driver registers the pprof web UI of the profile id, which is fetched from store by Fetcher,
+// so the id can also be the merged profile ids
+func Driver(basePath string, mux *http.ServeMux, store storage.Store, id string) (int64, error) {
+	fetcher := NewFetcher(store)
+	err := register(path.Join(basePath, id)+"/", mux, id, fetcher)
+	if errors.Is(fetcher.Err, storage.ErrProfileNotFound) {
+		return fetcher.Size, fetcher.Err
 	}
+	return fetcher.Size, err
+}
 
-	curPath := path.Join(basePath, id) + "/"
-	options := &driver.Options{
-		Flagset: flags,
-		HTTPServer: func(args *driver.HTTPServerArgs) error {
-			for pattern, handler := range args.Handlers {
-				var joinedPattern string
-				if pattern == "/" {
-					joinedPattern = curPath
-				} else {
-					joinedPattern = path.Join(curPath, pattern)
-				}
-				mux.Handle(joinedPattern, handler)
+// Register registers the pprof web UI of the in-memory profile p under curPath
+func Register(curPath string, mux *http.ServeMux, name string, p *profile.Profile) error {
+	return register(curPath, mux, name, &pprofdriver.ProfileFetcher{Profile: p})
+}
+
+// register runs pprof on source and registers its web UI handlers under curPath,
+// the source is fetched by fetcher if it is not nil
+func register(curPath string, mux *http.ServeMux, source string, fetcher driver.Fetcher) error {
+	return pprofdriver.Web(source, fetcher, func(args *driver.HTTPServerArgs) error {
+		for pattern, handler := range args.Handlers {
+			var joinedPattern string
+			if pattern == "/" {
+				joinedPattern = curPath
+			} else {
+				joinedPattern = path.Join(curPath, pattern)
 			}
-			return nil
-		},
-	}
-	if err := driver.PProf(options); err != nil {
-		return err
-	}
-	return nil
+			mux.Handle(joinedPattern, handler)
+		}
+		return nil
+	})
 }
@@ -0,0 +1,37 @@
+package ui
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+)
+
+// writeProgress Respond the page of a profile being loaded, it refreshes every second until the profile is loaded
+func writeProgress(w http.ResponseWriter, id string, elapsed time.Duration) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Retry-After", "1")
+	w.WriteHeader(http.StatusAccepted)
+	err := templProgress.Execute(w, struct {
+		ID      string
+		Elapsed time.Duration
+	}{
+		ID:      id,
+		Elapsed: elapsed.Round(time.Second),
+	})
+	if err != nil {
+		log.Printf("failed to execute template: %v", err)
+	}
+}
+
+var templProgress = template.Must(template.New("").Parse(`
+<html>
+<head>
+<meta http-equiv="refresh" content="1">
+<title>Loading profile {{.ID}}</title>
+</head>
+<body>
+Loading profile {{.ID}}, {{.Elapsed}} elapsed, this page refreshes automatically when it is loaded.
+</body>
+</html>
+`))
@@ -1,20 +1,88 @@
 package trace
 
 import (
+	"io"
 	"net/http"
 	"path"
+	"strings"
+	"sync"
 
+	"cprofiler/pkg/apiserver/ui/pprof"
+	"cprofiler/pkg/internal/tracev2"
 	"cprofiler/pkg/internal/v1175/traceui"
+	"cprofiler/pkg/utils"
+
+	"github.com/google/pprof/profile"
 )
 
-func Driver(basePath string, mux *http.ServeMux, id string, data []byte) error {
+// MemoryWeight is the memory a parsed trace UI takes in times of the trace size, it is about 15 to 20,
+// the trace UI counts the size of a loaded trace with it
+const MemoryWeight = 16
+
+// traceUI is the trace UI of either trace format
+type traceUI interface {
+	PprofProfiles() (map[string]*profile.Profile, error)
+}
+
+// newUI parses the trace, traces written by Go 1.21 and newer are served by tracev2, older ones by v1175
+func newUI(data []byte) (traceUI, map[string]http.HandlerFunc, error) {
+	if tracev2.IsNewFormat(data) {
+		ui, err := tracev2.NewUI(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		return ui, ui.Handlers, nil
+	}
+
 	ui, err := traceui.NewUI(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ui, ui.Handlers, nil
+}
+
+// ExportJSON writes the whole trace in the Chrome Trace Event JSON format, for either trace format
+func ExportJSON(w io.Writer, data []byte) error {
+	if tracev2.IsNewFormat(data) {
+		return tracev2.ExportJSON(w, data)
+	}
+	return traceui.ExportJSON(w, data)
+}
+
+// PProfPath returns the base path of the trace-derived pprof UI redirected by PProfRedirect,
+// for the trace UI base path such as /api/trace/ui it is /api/trace/pprof/ui
+func PProfPath(basePath string) string {
+	return path.Join(path.Dir(basePath), "pprof", "ui")
+}
+
+// PProfRedirect redirects <PProfPath>/<id>/<kind>/ to the pprof/<kind>/ pages of the trace UI under basePath
+func PProfRedirect(basePath string) http.HandlerFunc {
+	pprofPath := PProfPath(basePath)
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := utils.ExtractProfileID(r.URL.Path)
+		if id == "" {
+			http.Error(w, "Invalid parameter", http.StatusBadRequest)
+			return
+		}
+		kindPath := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, path.Join(pprofPath, id)), "/")
+		target := path.Join(basePath, id) + "/pprof/" + kindPath
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusFound)
+	}
+}
+
+// Driver registers the trace UI handlers, and the pprof UI of the io, block, syscall and sched profiles
+// computed from the trace under pprof/<kind>/
+func Driver(basePath string, mux *http.ServeMux, id string, data []byte) error {
+	ui, handlers, err := newUI(data)
 	if err != nil {
 		return err
 	}
 
 	curPath := path.Join(basePath, id) + "/"
-	for pattern, handler := range ui.Handlers {
+	for pattern, handler := range handlers {
 		var joinedPattern string
 		if pattern == "/" {
 			joinedPattern = curPath
@@ -23,5 +91,34 @@ func Driver(basePath string, mux *http.ServeMux, id string, data []byte) error {
 		}
 		mux.Handle(joinedPattern, handler)
 	}
+
+	pprofPath := curPath + "pprof/"
+	mux.Handle(pprofPath, pprofHandler(pprofPath, ui))
 	return nil
 }
+
+// pprofHandler serves the pprof UI of the profiles computed from the parsed trace under <pprofPath><kind>/,
+// the profiles are computed on the first request and cached with the trace UI
+func pprofHandler(pprofPath string, ui traceUI) http.HandlerFunc {
+	var once sync.Once
+	var err error
+	pprofMux := http.NewServeMux()
+	return func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() {
+			var profiles map[string]*profile.Profile
+			if profiles, err = ui.PprofProfiles(); err != nil {
+				return
+			}
+			for kind, p := range profiles {
+				if err = pprof.Register(pprofPath+kind+"/", pprofMux, kind, p); err != nil {
+					return
+				}
+			}
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		pprofMux.ServeHTTP(w, r)
+	}
+}
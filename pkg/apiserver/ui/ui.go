@@ -1,8 +1,12 @@
 package ui
 
 import (
+	"container/list"
 	"errors"
+	"fmt"
 	"net/http"
+	"path"
+	"strings"
 	"sync"
 	"time"
 
@@ -10,31 +14,84 @@ import (
 	"cprofiler/pkg/utils"
 )
 
-type Driver func(basePath string, mux *http.ServeMux, id string, data []byte) error
+// Driver Register the handlers of the profile id in mux, return the size of the loaded data
+type Driver func(basePath string, mux *http.ServeMux, store storage.Store, id string) (int64, error)
+
+// DataDriver Register the handlers of the profile data in mux
+type DataDriver func(basePath string, mux *http.ServeMux, id string, data []byte) error
+
+// FromData Adapt drive to Driver, the profile data is got from store
+func FromData(drive DataDriver) Driver {
+	return func(basePath string, mux *http.ServeMux, store storage.Store, id string) (int64, error) {
+		_, data, err := store.GetProfile(id)
+		if err != nil {
+			return 0, err
+		}
+		return int64(len(data)), drive(basePath, mux, id, data)
+	}
+}
+
+// Weighted Adapt drive to count the size of the loaded profile as weight times of its data size,
+// for the profiles whose parsed UI takes much more memory than their data
+func Weighted(drive Driver, weight int64) Driver {
+	return func(basePath string, mux *http.ServeMux, store storage.Store, id string) (int64, error) {
+		size, err := drive(basePath, mux, store, id)
+		return size * weight, err
+	}
+}
+
+// entry A loaded profile UI, its handlers are registered in its own mux
+type entry struct {
+	id   string
+	mux  *http.ServeMux
+	size int64
+	// lastAccess The time of the last request, zero if it is warmed and not requested yet
+	lastAccess time.Time
+	loaded     time.Time
+}
+
+// load A profile being loaded, the concurrent requests of the profile share it
+type load struct {
+	start time.Time
+	done  chan struct{}
+	mux   *http.ServeMux
+	size  int64
+	err   error
+	// warm The load is started by Warm and no request waits for it
+	warm bool
+}
 
 type Server struct {
-	cache    map[string]struct{}
+	// lru The loaded profiles, the most recently used at the front, and the warmed ones not requested yet at the back
+	lru      *list.List
+	cache    map[string]*list.Element
+	loading  map[string]*load
+	size     int64
 	mux      *http.ServeMux
 	mu       sync.Mutex
 	basePath string
 	store    storage.Store
 	exitChan chan struct{}
 	drive    Driver
+	opt      Options
 }
 
-func NewServer(basePath string, store storage.Store, gcInternal time.Duration, drive Driver) *Server {
+func NewServer(basePath string, store storage.Store, opt Options, drive Driver) *Server {
 	s := &Server{
+		lru:      list.New(),
+		cache:    make(map[string]*list.Element),
+		loading:  make(map[string]*load),
 		mux:      http.NewServeMux(),
 		basePath: basePath,
 		store:    store,
 		exitChan: make(chan struct{}),
-		cache:    make(map[string]struct{}),
 		drive:    drive,
+		opt:      opt,
 	}
-	s.mux.HandleFunc("/", s.register)
+	s.mux.HandleFunc("/", s.serve)
 
 	go func() {
-		ticker := time.NewTicker(gcInternal)
+		ticker := time.NewTicker(opt.GCInternal)
 		defer ticker.Stop()
 		for {
 			select {
@@ -52,49 +109,201 @@ func (s *Server) Exit() {
 	close(s.exitChan)
 }
 
+// gc Evict the profiles not visited for GCInternal, and the warmed ones not visited since loaded for GCInternal
 func (s *Server) gc() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.mux = http.NewServeMux()
-	s.mux.HandleFunc("/", s.register)
-	s.cache = make(map[string]struct{})
+
+	deadline := time.Now().Add(-s.opt.GCInternal)
+	for elem := s.lru.Back(); elem != nil; {
+		prev := elem.Prev()
+		e := elem.Value.(*entry)
+		if e.lastAccess.IsZero() {
+			if !e.loaded.After(deadline) {
+				s.remove(elem)
+			}
+			elem = prev
+			continue
+		}
+		if e.lastAccess.After(deadline) {
+			break
+		}
+		s.remove(elem)
+		elem = prev
+	}
 }
 
 func (s *Server) Web(w http.ResponseWriter, r *http.Request) {
 	s.mux.ServeHTTP(w, r)
 }
 
-func (s *Server) register(w http.ResponseWriter, r *http.Request) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
+// serve Route the request to the handlers of its profile, load the profile if it is not loaded.
+// If the loading of a browser navigation takes longer than LoadWait, respond a progress page which refreshes
+// until it is loaded. The other requests, such as the json and ajax ones, wait for the loading
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
 	id := utils.ExtractProfileID(r.URL.Path)
 	if id == "" {
 		http.Error(w, "Invalid parameter", http.StatusBadRequest)
 		return
 	}
 
-	if _, ok := s.cache[id]; ok {
-		http.Redirect(w, r, r.URL.Path+"?"+r.URL.RawQuery, http.StatusSeeOther)
-		return
-	}
+	var mux *http.ServeMux
+	e, l := s.get(id)
+	if e != nil {
+		mux = e.mux
+	} else {
+		// nil blocks forever
+		var timeout <-chan time.Time
+		if s.isNavigation(r, id) {
+			timer := time.NewTimer(s.opt.LoadWait)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case <-l.done:
+		case <-timeout:
+			writeProgress(w, id, time.Since(l.start))
+			return
+		case <-r.Context().Done():
+			return
+		}
 
-	_, data, err := s.store.GetProfile(id)
-	if err != nil {
-		if errors.Is(err, storage.ErrProfileNotFound) {
-			http.Error(w, "Profile not found", http.StatusNotFound)
+		if l.err != nil {
+			if errors.Is(l.err, storage.ErrProfileNotFound) {
+				http.Error(w, "Profile not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, l.err.Error(), http.StatusInternalServerError)
 			return
 		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		mux = l.mux
+	}
+	mux.ServeHTTP(w, r)
+}
+
+// isNavigation Whether the request is a browser navigation to the index page of the profile,
+// which can be answered by the progress page
+func (s *Server) isNavigation(r *http.Request, id string) bool {
+	return strings.TrimSuffix(r.URL.Path, "/") == path.Join(s.basePath, id) &&
+		strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// Warm Load the profile into the cache in the background and wait for it, return the size of its data.
+// It does not count as a visit, the warmed profile is the first to evict until it is requested
+func (s *Server) Warm(id string) (int64, error) {
+	e, l := s.warmGet(id)
+	if e != nil {
+		return e.size, nil
+	}
+	select {
+	case <-l.done:
+		return l.size, l.err
+	case <-s.exitChan:
+		return 0, errors.New("server exited")
+	}
+}
+
+// get Return the entry of the profile if it is loaded, or else the loading of it,
+// which is started if no one is loading it
+func (s *Server) get(id string) (*entry, *load) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, ok := s.cache[id]; ok {
+		e := elem.Value.(*entry)
+		e.lastAccess = time.Now()
+		s.lru.MoveToFront(elem)
+		return e, nil
+	}
+
+	if l, ok := s.loading[id]; ok {
+		l.warm = false
+		return nil, l
+	}
+	l := &load{start: time.Now(), done: make(chan struct{})}
+	s.loading[id] = l
+	go s.load(id, l)
+	return nil, l
+}
+
+// warmGet The same as get, but the recency of the loaded profile is not changed
+func (s *Server) warmGet(id string) (*entry, *load) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, ok := s.cache[id]; ok {
+		return elem.Value.(*entry), nil
+	}
+
+	if l, ok := s.loading[id]; ok {
+		return nil, l
 	}
+	l := &load{start: time.Now(), done: make(chan struct{}), warm: true}
+	s.loading[id] = l
+	go s.load(id, l)
+	return nil, l
+}
 
-	err = s.drive(s.basePath, s.mux, id, data)
+// load Load the profile out of the lock, so that the distinct profiles load in parallel.
+// It runs in its own goroutine, a panic of the driver on a bad profile is turned into the load error
+func (s *Server) load(id string, l *load) {
+	var err error
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("load profile %s panic: %v", id, r)
+		}
+		l.err = err
+		s.mu.Lock()
+		delete(s.loading, id)
+		if l.err == nil {
+			e := &entry{id: id, mux: l.mux, size: l.size, loaded: time.Now()}
+			if l.warm {
+				s.cache[id] = s.pushWarmed(e)
+			} else {
+				e.lastAccess = e.loaded
+				s.cache[id] = s.lru.PushFront(e)
+			}
+			s.size += e.size
+			s.evict()
+		}
+		s.mu.Unlock()
+		close(l.done)
+	}()
+
+	mux := http.NewServeMux()
+	l.size, err = s.drive(s.basePath, mux, s.store, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	l.mux = mux
+}
+
+// pushWarmed Insert the warmed profile behind the requested ones and in front of the other warmed ones,
+// so the oldest warmed profile is evicted first
+func (s *Server) pushWarmed(e *entry) *list.Element {
+	for elem := s.lru.Back(); elem != nil; elem = elem.Prev() {
+		if !elem.Value.(*entry).lastAccess.IsZero() {
+			return s.lru.InsertAfter(e, elem)
+		}
+	}
+	return s.lru.PushFront(e)
+}
+
+// evict Evict the least recently used profiles until the limits are met, the most recently used one is kept.
+// The warmed profiles not requested yet are at the back, so a warmed profile never evicts a requested one
+func (s *Server) evict() {
+	for s.lru.Len() > 1 {
+		overEntries := s.opt.MaxEntries > 0 && s.lru.Len() > s.opt.MaxEntries
+		overBytes := s.opt.MaxBytes > 0 && s.size > s.opt.MaxBytes
+		if !overEntries && !overBytes {
+			return
+		}
+		s.remove(s.lru.Back())
+	}
+}
 
-	s.cache[id] = struct{}{}
-	http.Redirect(w, r, r.URL.Path+"?"+r.URL.RawQuery, http.StatusSeeOther)
+func (s *Server) remove(elem *list.Element) {
+	e := s.lru.Remove(elem).(*entry)
+	delete(s.cache, e.id)
+	s.size -= e.size
 }
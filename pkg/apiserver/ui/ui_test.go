@@ -6,6 +6,8 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -16,6 +18,7 @@ import (
 	"cprofiler/pkg/storage"
 
 	"github.com/gavv/httpexpect/v2"
+	"github.com/google/pprof/profile"
 	"github.com/stretchr/testify/require"
 	"cprofiler/pkg/storage/badger"
 )
@@ -61,7 +64,7 @@ func TestPProfServer(t *testing.T) {
 
 	store := badger.NewStore(badger.DefaultOptions(dir))
 
-	pprofServer := NewServer("/api/pprof/ui", store, 1*time.Minute, pprof.Driver)
+	pprofServer := NewServer("/api/pprof/ui", store, DefaultOptions().WithGCInternal(time.Minute), pprof.Driver)
 	defer pprofServer.Exit()
 
 	httpServer := httptest.NewServer(pprofServer.mux)
@@ -124,6 +127,81 @@ func testPProfUI(e *httpexpect.Expect, store storage.Store, t *testing.T, pprofS
 
 }
 
+func TestPProfMerge(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	// pprof saves a copy of the profiles with a source in PPROF_TMPDIR
+	pprofDir := t.TempDir()
+	t.Setenv("PPROF_TMPDIR", pprofDir)
+
+	store := badger.NewStore(badger.DefaultOptions(dir))
+	_, _, id := initProfileData(store, t)
+
+	fetcher := pprof.NewFetcher(store)
+	p, _, err := fetcher.Fetch(id, 0, 0)
+	require.Equal(t, nil, err)
+	merged, _, err := fetcher.Fetch(id+pprof.MergeSep+id, 0, 0)
+	require.Equal(t, nil, err)
+	sum := func(p *profile.Profile) int64 {
+		var v int64
+		for _, s := range p.Sample {
+			v += s.Value[0]
+		}
+		return v
+	}
+	require.Equal(t, 2*sum(p), sum(merged))
+	_, _, err = fetcher.Fetch(id+pprof.MergeSep+"1999", 0, 0)
+	require.Equal(t, storage.ErrProfileNotFound, err)
+
+	pprofServer := NewServer("/api/pprof/ui", store, DefaultOptions().WithGCInternal(time.Minute), pprof.Driver)
+	defer pprofServer.Exit()
+	httpServer := httptest.NewServer(pprofServer.mux)
+	defer httpServer.Close()
+	e := httpexpect.New(t, httpServer.URL)
+
+	e.GET(fmt.Sprintf("/api/pprof/ui/%s+%s/top", id, id)).WithQuery("si", "alloc_space").
+		Expect().
+		Status(http.StatusOK).Header("Content-Type").Equal("text/html")
+
+	e.GET(fmt.Sprintf("/api/pprof/ui/%s+1999/top", id)).
+		Expect().
+		Status(http.StatusNotFound).Text().Equal("Profile not found\n")
+
+	files, err := os.ReadDir(pprofDir)
+	require.Equal(t, nil, err)
+	require.Equal(t, 0, len(files))
+}
+
+func TestPProfServerSize(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	store := badger.NewStore(badger.DefaultOptions(dir))
+	profileBytes, err := ioutil.ReadFile("../testdata/profile.out.testdata")
+	require.Equal(t, nil, err)
+	ids := make([]string, 0)
+	for i := 0; i < 3; i++ {
+		id, err := store.SaveProfile("", profileBytes, time.Minute)
+		require.Equal(t, nil, err)
+		ids = append(ids, id)
+	}
+
+	// The profiles count their parsed size, a profile near the max bytes evicts the others
+	size := int64(len(profileBytes)) * pprof.MemoryWeight
+	pprofServer := NewServer("/api/pprof/ui", store, DefaultOptions().WithGCInternal(time.Minute).WithMaxBytes(size+size/2),
+		Weighted(pprof.Driver, pprof.MemoryWeight))
+	defer pprofServer.Exit()
+	for _, id := range ids {
+		n, err := pprofServer.Warm(id)
+		require.Equal(t, nil, err)
+		require.Equal(t, size, n)
+		require.Equal(t, 1, pprofServer.lru.Len())
+		require.Equal(t, size, pprofServer.size)
+	}
+}
+
 func TestTraceServer(t *testing.T) {
 	dir, err := ioutil.TempDir("./", "temp-*")
 	require.Equal(t, nil, err)
@@ -131,7 +209,7 @@ func TestTraceServer(t *testing.T) {
 
 	store := badger.NewStore(badger.DefaultOptions(dir))
 
-	traceServer := NewServer("/api/trace/ui", store, 1*time.Minute, trace.Driver)
+	traceServer := NewServer("/api/trace/ui", store, DefaultOptions().WithGCInternal(time.Minute), FromData(trace.Driver))
 	defer traceServer.Exit()
 
 	httpServer := httptest.NewServer(traceServer.mux)
@@ -176,4 +254,483 @@ func testTraceUI(e *httpexpect.Expect, store storage.Store, t *testing.T, server
 		Expect().
 		Status(http.StatusOK).Header("Content-Type").Equal("text/html; charset=utf-8")
 
+	testGoroutinesJSON(e, id)
+	testAnnotationsJSON(e, id)
+	testTraceProfiles(e, id)
+
+	testMMUJSON(e, id)
+}
+
+func testMMUJSON(e *httpexpect.Expect, id string) {
+	points := e.GET(fmt.Sprintf("/api/trace/ui/%s/json/mmu", id)).
+		Expect().
+		Status(http.StatusOK).JSON().Object().Value("Points").Array()
+	points.Length().Equal(100)
+	points.First().Object().Value("MMU").Number().InRange(0, 1)
+
+	mmu := e.GET(fmt.Sprintf("/api/trace/ui/%s/json/mmu", id)).
+		WithQuery("flags", "stw|assist|mut").WithQuery("windows", "1ms,10ms").
+		Expect().
+		Status(http.StatusOK).JSON().Object()
+	mmu.Value("Flags").Array().Equal([]string{"stw", "assist", "mut"})
+	mmu.Value("Quantiles").Array().Length().Equal(4)
+	points = mmu.Value("Points").Array()
+	points.Length().Equal(2)
+	points.First().Object().Value("Window").Number().Equal(time.Millisecond)
+	points.First().Object().Value("MUD").Array().Length().Equal(4)
+
+	e.GET(fmt.Sprintf("/api/trace/ui/%s/json/mmu", id)).WithQuery("flags", "haha").
+		Expect().
+		Status(http.StatusBadRequest)
+
+	e.GET(fmt.Sprintf("/api/trace/ui/%s/json/mmu", id)).WithQuery("windows", "haha").
+		Expect().
+		Status(http.StatusBadRequest)
+}
+
+func testTraceProfiles(e *httpexpect.Expect, id string) {
+	for _, kind := range []string{"io", "block", "syscall", "sched", "regionblock"} {
+		e.GET(fmt.Sprintf("/api/trace/ui/%s/%s", id, kind)).WithQuery("raw", 1).
+			Expect().
+			Status(http.StatusOK).Header("Content-Type").Equal("application/octet-stream")
+
+		e.GET(fmt.Sprintf("/api/trace/ui/%s/%s", id, kind)).WithQuery("output", "flame").
+			Expect().
+			Status(http.StatusOK).Header("Content-Type").Equal("text/html")
+
+		e.GET(fmt.Sprintf("/api/trace/ui/%s/%s", id, kind)).WithQuery("output", "top").
+			Expect().
+			Status(http.StatusOK).Header("Content-Type").Equal("text/plain; charset=utf-8")
+	}
+
+	// The flame graph of the whole trace is the one of the pprof UI, the filtered ones are rendered by pprof
+	e.GET(fmt.Sprintf("/api/trace/ui/%s/block", id)).WithQuery("output", "flame").WithQuery("si", "contentions").
+		WithRedirectPolicy(httpexpect.DontFollowRedirects).
+		Expect().
+		Status(http.StatusFound).Header("Location").Equal(fmt.Sprintf("/api/trace/ui/%s/pprof/block/flamegraph?si=contentions", id))
+	e.GET(fmt.Sprintf("/api/trace/ui/%s/block", id)).WithQuery("output", "flame").WithQuery("id", "haha").
+		Expect().
+		Status(http.StatusInternalServerError)
+
+	e.GET(fmt.Sprintf("/api/trace/ui/%s/sched", id)).WithQuery("output", "dot").
+		Expect().
+		Status(http.StatusOK).Body().Contains("digraph")
+
+	e.GET(fmt.Sprintf("/api/trace/ui/%s/sched", id)).
+		Expect().
+		Status(http.StatusOK)
+
+	e.GET(fmt.Sprintf("/api/trace/ui/%s/sched", id)).WithQuery("output", "haha").
+		Expect().
+		Status(http.StatusBadRequest)
+}
+
+func testAnnotationsJSON(e *httpexpect.Expect, id string) {
+	tasks := e.GET(fmt.Sprintf("/api/trace/ui/%s/json/usertasks", id)).
+		Expect().
+		Status(http.StatusOK).JSON().Array()
+	for _, task := range tasks.Iter() {
+		task.Object().Keys().Contains("Type", "Count", "Durations")
+	}
+
+	regions := e.GET(fmt.Sprintf("/api/trace/ui/%s/json/userregions", id)).
+		Expect().
+		Status(http.StatusOK).JSON().Array()
+	for _, region := range regions.Iter() {
+		durations := region.Object().Value("Durations").Object()
+		durations.Keys().Contains("Count", "Min", "Max", "Mean", "P50", "P90", "P95", "P99", "Histogram")
+		durations.Value("Histogram").Array().NotEmpty()
+	}
+}
+
+func testGoroutinesJSON(e *httpexpect.Expect, id string) {
+	groups := e.GET(fmt.Sprintf("/api/trace/ui/%s/json/goroutines", id)).
+		Expect().
+		Status(http.StatusOK).JSON().Array()
+	groups.NotEmpty()
+
+	group := groups.First().Object()
+	group.Keys().Contains("PC", "Name", "Count", "ExecTime", "SchedWaitTime", "BlockTime", "SyscallTime", "GCTime")
+	pc := uint64(group.Value("PC").Number().Raw())
+	count := group.Value("Count").Number().Raw()
+
+	detail := e.GET(fmt.Sprintf("/api/trace/ui/%s/json/goroutine", id)).WithQuery("id", pc).
+		Expect().
+		Status(http.StatusOK).JSON().Object()
+	detail.Value("PC").Number().Equal(pc)
+	detail.Value("Goroutines").Array().Length().Equal(count)
+
+	e.GET(fmt.Sprintf("/api/trace/ui/%s/json/goroutine", id)).WithQuery("id", "haha").
+		Expect().
+		Status(http.StatusBadRequest)
+
+	e.GET(fmt.Sprintf("/api/trace/ui/%s/json/goroutine", id)).WithQuery("id", 1).
+		Expect().
+		Status(http.StatusNotFound)
+}
+
+func TestTraceServerNewFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	store := badger.NewStore(badger.DefaultOptions(dir))
+
+	traceServer := NewServer("/api/trace/ui", store, DefaultOptions().WithGCInternal(time.Minute), FromData(trace.Driver))
+	defer traceServer.Exit()
+
+	httpServer := httptest.NewServer(traceServer.mux)
+	defer httpServer.Close()
+
+	e := httpexpect.New(t, httpServer.URL)
+
+	traceBytes, err := ioutil.ReadFile("../testdata/trace_go126.out.testdata")
+	require.Equal(t, nil, err)
+	id, err := store.SaveProfile("", traceBytes, time.Second*10)
+	require.Equal(t, nil, err)
+
+	e.GET(fmt.Sprintf("/api/trace/ui/%s", id)).
+		Expect().
+		Status(http.StatusOK).Header("Content-Type").Equal("text/html; charset=utf-8")
+
+	e.GET(fmt.Sprintf("/api/trace/ui/%s/goroutines", id)).
+		Expect().
+		Status(http.StatusOK).Body().Contains("goroutine?id=")
+
+	e.GET(fmt.Sprintf("/api/trace/ui/%s/jsontrace", id)).
+		Expect().
+		Status(http.StatusOK).JSON().Object().Value("traceEvents").Array().NotEmpty()
+
+	e.GET(fmt.Sprintf("/api/trace/ui/%s/usertasks", id)).
+		Expect().
+		Status(http.StatusOK).Body().Contains("usertask?type=work")
+	e.GET(fmt.Sprintf("/api/trace/ui/%s/userregions", id)).
+		Expect().
+		Status(http.StatusOK).Body().Contains("userregion?type=alloc")
+	e.GET(fmt.Sprintf("/api/trace/ui/%s/mmu", id)).
+		Expect().
+		Status(http.StatusOK).Body().Contains("mmuPlot")
+	e.GET(fmt.Sprintf("/api/trace/ui/%s/mmuPlot", id)).WithQuery("flags", "stw|background|assist").
+		Expect().
+		Status(http.StatusOK).Body().Contains(`"curve":[[`)
+
+	testGoroutinesJSON(e, id)
+	testAnnotationsJSON(e, id)
+	testMMUJSON(e, id)
+
+	tasks := e.GET(fmt.Sprintf("/api/trace/ui/%s/json/usertasks", id)).
+		Expect().
+		Status(http.StatusOK).JSON().Array()
+	tasks.Length().Equal(1)
+	tasks.First().Object().Value("Type").Equal("work")
+	tasks.First().Object().Value("Durations").Object().Value("Count").Equal(1)
+
+	regions := e.GET(fmt.Sprintf("/api/trace/ui/%s/json/userregions", id)).
+		Expect().
+		Status(http.StatusOK).JSON().Array()
+	regions.Length().Equal(1)
+	regions.First().Object().Value("Durations").Object().Value("Count").Equal(20)
+}
+
+func TestTracePProfServer(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	store := badger.NewStore(badger.DefaultOptions(dir))
+
+	var loads int32
+	drive := FromData(trace.Driver)
+	traceServer := NewServer("/api/trace/ui", store, DefaultOptions().WithGCInternal(time.Minute),
+		func(basePath string, mux *http.ServeMux, store storage.Store, id string) (int64, error) {
+			atomic.AddInt32(&loads, 1)
+			return drive(basePath, mux, store, id)
+		})
+	defer traceServer.Exit()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/trace/ui/", traceServer.Web)
+	mux.HandleFunc("/api/trace/pprof/ui/", trace.PProfRedirect("/api/trace/ui"))
+	httpServer := httptest.NewServer(mux)
+	defer httpServer.Close()
+
+	e := httpexpect.New(t, httpServer.URL)
+
+	for i, file := range []string{"trace.out.testdata", "trace_go126.out.testdata"} {
+		traceBytes, err := ioutil.ReadFile("../testdata/" + file)
+		require.Equal(t, nil, err)
+		id, err := store.SaveProfile("", traceBytes, time.Second*10)
+		require.Equal(t, nil, err)
+
+		e.GET(fmt.Sprintf("/api/trace/ui/%s/", id)).
+			Expect().
+			Status(http.StatusOK)
+
+		// The pprof UI is served from the loaded trace
+		for _, kind := range []string{"io", "block", "syscall", "sched"} {
+			e.GET(fmt.Sprintf("/api/trace/ui/%s/pprof/%s/top", id, kind)).WithQuery("si", "delay").
+				Expect().
+				Status(http.StatusOK).Header("Content-Type").Equal("text/html")
+		}
+		e.GET(fmt.Sprintf("/api/trace/ui/%s/pprof/unknown/", id)).
+			Expect().
+			Status(http.StatusNotFound)
+
+		// The former pprof UI path redirects to the trace UI
+		e.GET(fmt.Sprintf("/api/trace/pprof/ui/%s/sched/top", id)).WithQuery("si", "contentions").
+			Expect().
+			Status(http.StatusOK).Header("Content-Type").Equal("text/html")
+		require.Equal(t, int32(i+1), atomic.LoadInt32(&loads))
+	}
+
+	e.GET("/api/trace/pprof/ui/1999/io/").
+		Expect().
+		Status(http.StatusNotFound).Text().Equal("Profile not found\n")
+}
+
+// countDriver A driver serving the profile data, counting the loads
+func countDriver(loads *int32) Driver {
+	return FromData(func(basePath string, mux *http.ServeMux, id string, data []byte) error {
+		atomic.AddInt32(loads, 1)
+		mux.HandleFunc(path.Join(basePath, id)+"/", func(w http.ResponseWriter, r *http.Request) {
+			w.Write(data)
+		})
+		return nil
+	})
+}
+
+func TestServerCache(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	store := badger.NewStore(badger.DefaultOptions(dir))
+	ids := make([]string, 0)
+	for i := 0; i < 4; i++ {
+		id, err := store.SaveProfile("", []byte(fmt.Sprintf("profile-%d", i)), time.Minute)
+		require.Equal(t, nil, err)
+		ids = append(ids, id)
+	}
+
+	var loads int32
+	server := NewServer("/api/test/ui", store, DefaultOptions().WithGCInternal(time.Hour).WithMaxEntries(2).WithMaxBytes(0), countDriver(&loads))
+	defer server.Exit()
+	httpServer := httptest.NewServer(server.mux)
+	defer httpServer.Close()
+	e := httpexpect.New(t, httpServer.URL)
+
+	visit := func(i int) {
+		e.GET(fmt.Sprintf("/api/test/ui/%s/", ids[i])).
+			Expect().
+			Status(http.StatusOK).Text().Equal(fmt.Sprintf("profile-%d", i))
+	}
+
+	// Max entries
+	visit(0)
+	visit(1)
+	visit(0)
+	require.Equal(t, int32(2), atomic.LoadInt32(&loads))
+	visit(2)
+	require.Equal(t, 2, server.lru.Len())
+	_, ok := server.cache[ids[1]]
+	require.Equal(t, false, ok)
+	visit(0)
+	require.Equal(t, int32(3), atomic.LoadInt32(&loads))
+	visit(1)
+	require.Equal(t, int32(4), atomic.LoadInt32(&loads))
+
+	// Max bytes, each profile data is 9 bytes
+	server.opt = server.opt.WithMaxEntries(0).WithMaxBytes(20)
+	visit(2)
+	visit(3)
+	require.Equal(t, 2, server.lru.Len())
+	require.Equal(t, int64(18), server.size)
+
+	// The most recently used profile is kept even if it is over the limits
+	server.opt = server.opt.WithMaxBytes(1)
+	visit(0)
+	require.Equal(t, 1, server.lru.Len())
+	require.Equal(t, int64(9), server.size)
+
+	// Idle
+	server.opt = server.opt.WithMaxBytes(0)
+	visit(1)
+	server.cache[ids[0]].Value.(*entry).lastAccess = time.Now().Add(-2 * time.Hour)
+	server.gc()
+	require.Equal(t, 1, server.lru.Len())
+	_, ok = server.cache[ids[1]]
+	require.Equal(t, true, ok)
+
+	// Warm
+	loaded := atomic.LoadInt32(&loads)
+	size, err := server.Warm(ids[3])
+	require.Equal(t, nil, err)
+	require.Equal(t, int64(9), size)
+	require.Equal(t, loaded+1, atomic.LoadInt32(&loads))
+	_, err = server.Warm("100")
+	require.Equal(t, storage.ErrProfileNotFound, err)
+
+	// The warmed profiles are behind the requested ones, and warming again does not visit them
+	lruIDs := func() []string {
+		res := make([]string, 0, server.lru.Len())
+		for elem := server.lru.Front(); elem != nil; elem = elem.Next() {
+			res = append(res, elem.Value.(*entry).id)
+		}
+		return res
+	}
+	_, err = server.Warm(ids[3])
+	require.Equal(t, nil, err)
+	require.Equal(t, []string{ids[1], ids[3]}, lruIDs())
+	require.Equal(t, true, server.cache[ids[3]].Value.(*entry).lastAccess.IsZero())
+
+	// A warmed profile never evicts the requested ones, the oldest warmed one is evicted first
+	server.opt = server.opt.WithMaxEntries(2)
+	_, err = server.Warm(ids[2])
+	require.Equal(t, nil, err)
+	require.Equal(t, []string{ids[1], ids[2]}, lruIDs())
+
+	// The warmed profiles idle out since loaded
+	server.cache[ids[2]].Value.(*entry).loaded = time.Now().Add(-2 * time.Hour)
+	server.gc()
+	require.Equal(t, []string{ids[1]}, lruIDs())
+
+	// A requested warmed profile is visited as the others
+	_, err = server.Warm(ids[3])
+	require.Equal(t, nil, err)
+	loaded = atomic.LoadInt32(&loads)
+	visit(3)
+	require.Equal(t, loaded, atomic.LoadInt32(&loads))
+	require.Equal(t, []string{ids[3], ids[1]}, lruIDs())
+
+	// The weighted profiles count weight times of their data size
+	weighted := NewServer("/api/test/ui", store, DefaultOptions().WithGCInternal(time.Hour), Weighted(countDriver(&loads), 4))
+	defer weighted.Exit()
+	size, err = weighted.Warm(ids[0])
+	require.Equal(t, nil, err)
+	require.Equal(t, int64(36), size)
+	require.Equal(t, int64(36), weighted.size)
+}
+
+// gateDriver A driver blocking the loading of the profile until its gate is closed
+func gateDriver(loads *int32, gates map[string]chan struct{}) Driver {
+	drive := countDriver(loads)
+	return func(basePath string, mux *http.ServeMux, store storage.Store, id string) (int64, error) {
+		if gate, ok := gates[id]; ok {
+			<-gate
+		}
+		return drive(basePath, mux, store, id)
+	}
+}
+
+func TestServerLoading(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	store := badger.NewStore(badger.DefaultOptions(dir))
+	ids := make([]string, 0)
+	gates := make(map[string]chan struct{})
+	for i := 0; i < 2; i++ {
+		id, err := store.SaveProfile("", []byte(fmt.Sprintf("profile-%d", i)), time.Minute)
+		require.Equal(t, nil, err)
+		ids = append(ids, id)
+		gates[id] = make(chan struct{})
+	}
+
+	var loads int32
+	server := NewServer("/api/test/ui", store, DefaultOptions().WithLoadWait(100*time.Millisecond), gateDriver(&loads, gates))
+	defer server.Exit()
+	httpServer := httptest.NewServer(server.mux)
+	defer httpServer.Close()
+	e := httpexpect.New(t, httpServer.URL)
+
+	// The concurrent browser navigations of a loading profile get the progress page, and share one loading
+	for i := 0; i < 3; i++ {
+		resp := e.GET(fmt.Sprintf("/api/test/ui/%s/", ids[0])).WithHeader("Accept", "text/html,application/xhtml+xml").Expect()
+		resp.Status(http.StatusAccepted).Header("Retry-After").Equal("1")
+		resp.Body().Contains("Loading profile " + ids[0])
+	}
+	require.Equal(t, 1, len(server.loading))
+
+	// The other requests wait for the loading
+	jsonDone := make(chan int, 2)
+	for _, accept := range []string{"application/json", "text/html"} {
+		go func(accept string) {
+			req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/test/ui/%s/json/mmu", httpServer.URL, ids[0]), nil)
+			req.Header.Set("Accept", accept)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				jsonDone <- 0
+				return
+			}
+			resp.Body.Close()
+			jsonDone <- resp.StatusCode
+		}(accept)
+	}
+	select {
+	case <-jsonDone:
+		t.Fatal("the json request does not wait for the loading")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	// Another profile is loaded while the first one is blocked
+	close(gates[ids[1]])
+	e.GET(fmt.Sprintf("/api/test/ui/%s/", ids[1])).
+		Expect().
+		Status(http.StatusOK).Text().Equal("profile-1")
+
+	// The progress page turns into the profile once it is loaded
+	close(gates[ids[0]])
+	require.Equal(t, http.StatusOK, <-jsonDone)
+	require.Equal(t, http.StatusOK, <-jsonDone)
+	require.Eventually(t, func() bool {
+		resp, err := http.Get(fmt.Sprintf("%s/api/test/ui/%s/", httpServer.URL, ids[0]))
+		require.Equal(t, nil, err)
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}, 5*time.Second, 50*time.Millisecond)
+	e.GET(fmt.Sprintf("/api/test/ui/%s/", ids[0])).
+		Expect().
+		Status(http.StatusOK).Text().Equal("profile-0")
+	require.Equal(t, int32(2), atomic.LoadInt32(&loads))
+	require.Equal(t, 0, len(server.loading))
+
+	// The failed loading is not cached
+	e.GET("/api/test/ui/100/").
+		Expect().
+		Status(http.StatusNotFound)
+	require.Equal(t, 0, len(server.loading))
+}
+
+func TestServerLoadPanic(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	store := badger.NewStore(badger.DefaultOptions(dir))
+	id, err := store.SaveProfile("", []byte("bad profile"), time.Minute)
+	require.Equal(t, nil, err)
+
+	var loads int32
+	server := NewServer("/api/test/ui", store, DefaultOptions(), func(basePath string, mux *http.ServeMux, store storage.Store, id string) (int64, error) {
+		atomic.AddInt32(&loads, 1)
+		panic("bad profile")
+	})
+	defer server.Exit()
+	httpServer := httptest.NewServer(server.mux)
+	defer httpServer.Close()
+	e := httpexpect.New(t, httpServer.URL)
+
+	// The panic of the driver fails the loading, the server keeps serving
+	for i := 0; i < 2; i++ {
+		e.GET(fmt.Sprintf("/api/test/ui/%s/", id)).
+			Expect().
+			Status(http.StatusInternalServerError).Text().Contains("panic: bad profile")
+	}
+	require.Equal(t, int32(2), atomic.LoadInt32(&loads))
+	require.Equal(t, 0, len(server.loading))
+	require.Equal(t, 0, server.lru.Len())
+
+	_, err = server.Warm(id)
+	require.NotEqual(t, nil, err)
 }
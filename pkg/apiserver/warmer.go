@@ -0,0 +1,147 @@
+package apiserver
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"cprofiler/pkg/storage"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// warmFunc Load the profile into an ui cache, return the size of its data
+type warmFunc func(id string) (int64, error)
+
+// warmer Load the latest profiles of each job into the ui caches in the background,
+// so that opening them in the ui does not wait for parsing
+type warmer struct {
+	store    storage.Store
+	pprof    warmFunc
+	trace    warmFunc
+	count    int
+	maxBytes int64
+	// lookback The time range of the profiles to warm, looking back from now
+	lookback time.Duration
+	exitChan chan struct{}
+}
+
+// warmTarget The profiles of a job and a profile type, the latest at the end
+type warmTarget struct {
+	warm  warmFunc
+	metas []*storage.ProfileMeta
+	ids   []string
+}
+
+func (w *warmer) run(internal time.Duration) {
+	ticker := time.NewTicker(internal)
+	defer ticker.Stop()
+	for {
+		w.warm()
+		select {
+		case <-w.exitChan:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *warmer) exit() {
+	close(w.exitChan)
+}
+
+// warm Warm the latest count profiles of each job and profile type, until maxBytes of data is loaded
+func (w *warmer) warm() {
+	targets, err := w.targets(time.Now())
+	if err != nil {
+		log.WithError(err).Error("list profiles to warm error")
+		return
+	}
+
+	var size int64
+	// Round robin the targets, so that every job gets its latest profile warmed first
+	for i := 0; i < w.count; i++ {
+		for _, target := range targets {
+			if i >= len(target.ids) {
+				continue
+			}
+			if w.maxBytes > 0 && size >= w.maxBytes {
+				return
+			}
+			select {
+			case <-w.exitChan:
+				return
+			default:
+			}
+
+			id := target.ids[len(target.ids)-1-i]
+			n, err := target.warm(id)
+			if err != nil {
+				log.WithError(err).WithField("id", id).Warn("warm profile error")
+				continue
+			}
+			size += n
+		}
+	}
+}
+
+// targets Group the profiles scraped in lookback by job and profile type
+func (w *warmer) targets(now time.Time) ([]*warmTarget, error) {
+	sampleTypes, err := w.store.ListSampleType()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(sampleTypes)
+
+	targets := make(map[string]*warmTarget)
+	seen := make(map[string]bool)
+	for _, sampleType := range sampleTypes {
+		warm := w.pprof
+		if sampleType == "trace" {
+			warm = w.trace
+		} else if strings.HasPrefix(sampleType, "trace_") {
+			// The trace summary metrics share the profile id of sample type trace
+			continue
+		}
+
+		metaByTargets, err := w.store.ListProfileMeta(sampleType, now.Add(-w.lookback), now)
+		if err != nil {
+			return nil, err
+		}
+		for _, metaByTarget := range metaByTargets {
+			for _, meta := range metaByTarget.ProfileMetas {
+				// The sample types of a profile share its id
+				if seen[meta.ProfileID] {
+					continue
+				}
+				seen[meta.ProfileID] = true
+
+				key := meta.JobName + "/" + meta.ProfileType
+				target, ok := targets[key]
+				if !ok {
+					target = &warmTarget{warm: warm}
+					targets[key] = target
+				}
+				target.metas = append(target.metas, meta)
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(targets))
+	for key := range targets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	res := make([]*warmTarget, 0, len(keys))
+	for _, key := range keys {
+		target := targets[key]
+		sort.SliceStable(target.metas, func(i, j int) bool {
+			return target.metas[i].Timestamp < target.metas[j].Timestamp
+		})
+		for _, meta := range target.metas {
+			target.ids = append(target.ids, meta.ProfileID)
+		}
+		res = append(res, target)
+	}
+	return res, nil
+}
@@ -0,0 +1,104 @@
+package apiserver
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"cprofiler/pkg/storage"
+	"cprofiler/pkg/storage/badger"
+
+	"github.com/stretchr/testify/require"
+)
+
+// recordWarm A warmFunc recording the warmed ids, each profile data is size bytes
+func recordWarm(mu *sync.Mutex, warmed *[]string, prefix string, size int64) warmFunc {
+	return func(id string) (int64, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		*warmed = append(*warmed, prefix+id)
+		return size, nil
+	}
+}
+
+func TestWarmer(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	s := badger.NewStore(badger.DefaultOptions(dir))
+
+	now := time.Now().UnixNano() / time.Millisecond.Nanoseconds()
+	save := func(job, profileType string, sampleTypes []string, ago int64) string {
+		metas := make([]*storage.ProfileMeta, 0, len(sampleTypes))
+		for _, sampleType := range sampleTypes {
+			metas = append(metas, &storage.ProfileMeta{
+				ProfileType: profileType,
+				SampleType:  sampleType,
+				JobName:     job,
+				Host:        job + ":9000",
+				Timestamp:   now - ago,
+			})
+		}
+		id, err := s.SaveProfileWithMeta("", []byte("data"), metas, time.Hour)
+		require.Equal(t, nil, err)
+		return id
+	}
+
+	heapTypes := []string{"heap_alloc_space", "heap_inuse_space"}
+	heapA1 := save("a", "heap", heapTypes, 3000)
+	heapA2 := save("a", "heap", heapTypes, 1000)
+	heapA0 := save("a", "heap", heapTypes, 5000)
+	heapB1 := save("b", "heap", heapTypes, 2000)
+	traceA1 := save("a", "trace", []string{"trace", "trace_gc_count"}, 2000)
+	traceA2 := save("a", "trace", []string{"trace", "trace_gc_count"}, 1000)
+	// out of the lookback
+	save("c", "heap", heapTypes, 2*time.Hour.Milliseconds())
+
+	var (
+		mu     sync.Mutex
+		warmed []string
+	)
+	w := &warmer{
+		store:    s,
+		pprof:    recordWarm(&mu, &warmed, "pprof/", 10),
+		trace:    recordWarm(&mu, &warmed, "trace/", 100),
+		count:    2,
+		lookback: time.Hour,
+		exitChan: make(chan struct{}),
+	}
+
+	// The latest profiles first, round robin the jobs and profile types
+	w.warm()
+	require.Equal(t, []string{"pprof/" + heapA2, "trace/" + traceA2, "pprof/" + heapB1, "pprof/" + heapA1, "trace/" + traceA1}, warmed)
+
+	// Max bytes
+	warmed = nil
+	w.maxBytes = 110
+	w.warm()
+	require.Equal(t, []string{"pprof/" + heapA2, "trace/" + traceA2}, warmed)
+
+	warmed = nil
+	w.count = 5
+	w.maxBytes = 0
+	w.warm()
+	require.Equal(t, 6, len(warmed))
+	require.Equal(t, "pprof/"+heapA0, warmed[5])
+
+	// Run until exit
+	warmed = nil
+	w.count = 1
+	done := make(chan struct{})
+	go func() {
+		w.run(time.Hour)
+		close(done)
+	}()
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(warmed) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+	w.exit()
+	<-done
+}
@@ -1,9 +1,9 @@
 package collector
 
 import (
-	"bytes"
-	"errors"
+	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"reflect"
@@ -12,7 +12,6 @@ import (
 
 	"cprofiler/pkg/storage"
 
-	"github.com/google/pprof/profile"
 	"github.com/sirupsen/logrus"
 )
 
@@ -24,17 +23,23 @@ type Collector struct {
 	Target   TargetConfig
 	Host     string
 
-	exitChan        chan struct{}
-	resetTickerChan chan time.Duration
-	mangerWg        *sync.WaitGroup
-	wg              *sync.WaitGroup
-	httpClient      *http.Client
-	mu              sync.RWMutex
-	log             *logrus.Entry
-	store           storage.Store
+	exitChan   chan struct{}
+	ctx        context.Context
+	cancel     context.CancelFunc
+	loops      map[string]*profileLoop
+	mangerWg   *sync.WaitGroup
+	httpClient *http.Client
+	mu         sync.RWMutex
+	log        *logrus.Entry
+	store      storage.Store
+	forwarder  *Forwarder
+	limiter    scrapeLimiter
+	status     map[string]*ScrapeStatus
+	statusMu   sync.Mutex
 }
 
-func newCollector(job JobConfig, store storage.Store, mangerWg *sync.WaitGroup) *Collector {
+func newCollector(job JobConfig, store storage.Store, forwarder *Forwarder, limiter scrapeLimiter, mangerWg *sync.WaitGroup) *Collector {
+	ctx, cancel := context.WithCancel(context.Background())
 	collector := &Collector{
 		JobName:      job.Scrape.Job,
 		ScrapeConfig: *job.Scrape,
@@ -42,45 +47,112 @@ func newCollector(job JobConfig, store storage.Store, mangerWg *sync.WaitGroup)
 		Target:       *job.Target,
 		Profiles:     make(map[string]*ProfileConfig),
 
-		exitChan:        make(chan struct{}),
-		resetTickerChan: make(chan time.Duration, 1000),
-		mangerWg:        mangerWg,
-		wg:              &sync.WaitGroup{},
-		httpClient:      &http.Client{},
-		log:             logrus.WithField("collector", job.Scrape.Job),
-		store:           store,
+		exitChan:   make(chan struct{}),
+		loops:      make(map[string]*profileLoop),
+		mangerWg:   mangerWg,
+		httpClient: &http.Client{},
+		log:        logrus.WithField("collector", job.Scrape.Job),
+		store:      store,
+		forwarder:  forwarder,
+		limiter:    limiter,
+		status:     make(map[string]*ScrapeStatus),
+		ctx:        ctx,
+		cancel:     cancel,
 	}
 
 	collector.Profiles = buildProfileConfigs(&collector.ScrapeConfig)
 	return collector
 }
 
+// profileLoop The scheduler of a profile type, each enabled profile type is scraped at its own interval
+type profileLoop struct {
+	interval  time.Duration
+	resetChan chan time.Duration
+	exitChan  chan struct{}
+}
+
 func (collector *Collector) run() {
 	collector.mu.Lock()
 	defer collector.mu.Unlock()
 
 	collector.log.Info("collector run")
+	collector.syncLoops()
+}
+
+// syncLoops Start the loops of the enabled profile types, reset the intervals of the changed ones
+// and stop the disabled ones. It must be called with the lock held
+func (collector *Collector) syncLoops() {
+	for profileType, profileConfig := range collector.Profiles {
+		loop, ok := collector.loops[profileType]
+		if !profileConfig.Enable {
+			if ok {
+				collector.log.Info("stop scrape loop ", profileType)
+				close(loop.exitChan)
+				delete(collector.loops, profileType)
+			}
+			continue
+		}
 
-	collector.mangerWg.Add(1)
+		if !ok {
+			loop = &profileLoop{
+				interval:  profileConfig.Interval,
+				resetChan: make(chan time.Duration, 1),
+				exitChan:  make(chan struct{}),
+			}
+			collector.loops[profileType] = loop
+			collector.mangerWg.Add(1)
+			go collector.scrapeLoop(profileType, loop, loop.interval)
+			continue
+		}
 
-	go collector.scrapeLoop(collector.Interval)
+		if loop.interval != profileConfig.Interval {
+			loop.interval = profileConfig.Interval
+			// only the latest interval matters
+			select {
+			case <-loop.resetChan:
+			default:
+			}
+			loop.resetChan <- loop.interval
+		}
+	}
 }
 
-func (collector *Collector) scrapeLoop(interval time.Duration) {
+func (collector *Collector) scrapeOffset(interval time.Duration) time.Duration {
+	collector.mu.RLock()
+	defer collector.mu.RUnlock()
+	return scrapeOffset(collector.JobName, collector.Host, interval)
+}
+
+// scrapeLoop Scrape the profile type at the offset of the target within each interval, instead of all targets at once
+func (collector *Collector) scrapeLoop(profileType string, loop *profileLoop, interval time.Duration) {
 	defer collector.mangerWg.Done()
-	collector.scrape()
 
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+	logEntry := collector.log.WithField("profile_type", profileType)
+	offset := collector.scrapeOffset(interval)
+	next := nextScrape(time.Now(), offset, interval)
+	logEntry.Infof("first scrape at %s, interval %s", next.Format(time.RFC3339), interval)
+
+	timer := time.NewTimer(time.Until(next))
+	defer timer.Stop()
 	for {
 		select {
 		case <-collector.exitChan:
-			collector.log.Info("scrape loop exit")
+			logEntry.Info("scrape loop exit")
+			return
+		case <-loop.exitChan:
+			logEntry.Info("scrape loop exit")
 			return
-		case i := <-collector.resetTickerChan:
-			ticker.Reset(i)
-		case <-ticker.C:
-			collector.scrape()
+		case i := <-loop.resetChan:
+			interval = i
+			offset = collector.scrapeOffset(interval)
+			next = nextScrape(time.Now(), offset, interval)
+			logEntry.Infof("next scrape at %s, interval %s", next.Format(time.RFC3339), interval)
+			timer.Reset(time.Until(next))
+		case <-timer.C:
+			collector.scrape(profileType)
+			// the intervals passed during a slow scrape are skipped, as a ticker does
+			next = nextScrape(time.Now(), offset, interval)
+			timer.Reset(time.Until(next))
 		}
 	}
 }
@@ -89,166 +161,143 @@ func (collector *Collector) reload(host string, job JobConfig) {
 	collector.mu.Lock()
 	defer collector.mu.Unlock()
 
-	collector.Profiles = buildProfileConfigs(&collector.ScrapeConfig)
-
-	if reflect.DeepEqual(collector.ScrapeConfig, job) {
+	if reflect.DeepEqual(collector.ScrapeConfig, *job.Scrape) && reflect.DeepEqual(collector.Target, *job.Target) {
 		return
 	}
 	collector.log.Info("reload collector ")
 
-	scrape := job.Scrape
-	if collector.Interval != scrape.Interval {
-		collector.resetTickerChan <- scrape.Interval
-	}
-
 	collector.ScrapeConfig = *job.Scrape
 	collector.Target = *job.Target
 	collector.Host = job.Host
+	collector.Profiles = buildProfileConfigs(&collector.ScrapeConfig)
+	collector.syncLoops()
 }
 
 func (collector *Collector) exit() {
 	close(collector.exitChan)
+	collector.cancel()
 }
 
-func (collector *Collector) scrape() {
+// scrape Scrape the profile type with the current config
+func (collector *Collector) scrape(profileType string) {
 	collector.mu.RLock()
-	defer collector.mu.RUnlock()
-
-	collector.log.Info("collector start scrape")
-	for profileType, profileConfig := range collector.Profiles {
-		if profileConfig.Enable {
-			collector.wg.Add(1)
-			go collector.fetch(profileType, profileConfig)
-		}
+	profileConfig, ok := collector.Profiles[profileType]
+	if !ok || !profileConfig.Enable {
+		collector.mu.RUnlock()
+		return
 	}
-	collector.wg.Wait()
-}
+	cfg := *profileConfig
+	src := collector.source()
+	collector.mu.RUnlock()
 
-func (collector *Collector) fetch(profileType string, profileConfig *ProfileConfig) {
-	defer collector.wg.Done()
+	collector.fetch(profileType, &cfg, src)
+}
 
+// fetch Scrape the profile with retries, save it and record the scrape status
+func (collector *Collector) fetch(profileType string, profileConfig *ProfileConfig, src Source) {
 	logEntry := collector.log.WithFields(logrus.Fields{"profile_type": profileType, "profile_url": profileConfig.Path})
 	logEntry.Info("collector start fetch")
 
-	req, err := http.NewRequest("GET", "http://"+collector.Host+profileConfig.Path, nil)
-	if err != nil {
-		logEntry.WithError(err).Error("invoke task error")
-		return
-	}
-	req.Header.Set("User-Agent", "")
-
-	resp, err := collector.httpClient.Do(req)
-	if err != nil {
-		logEntry.WithError(err).Error("http request error")
-		return
-	}
-	defer resp.Body.Close()
+	start := time.Now()
+	attempts := 0
+	backoff := profileConfig.RetryBackoff
+	var profileBytes []byte
+	var err error
+	for {
+		// Hold the global scrape concurrency slot only while requesting, not while backing off
+		if !collector.limiter.acquire(collector.exitChan) {
+			return
+		}
+		attempts++
+		var retry bool
+		profileBytes, retry, err = collector.fetchOnce(profileConfig, src.Host)
+		collector.limiter.release()
+		if err == nil || !retry || attempts > profileConfig.Retries {
+			break
+		}
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		logEntry.WithError(err).Error("http resp status code is ", resp.StatusCode)
-		return
+		logEntry.WithError(err).Warnf("fetch error, retry %d in %s", attempts, backoff)
+		select {
+		case <-collector.exitChan:
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > profileConfig.MaxRetryBackoff {
+			backoff = profileConfig.MaxRetryBackoff
+		}
 	}
-
-	profileBytes, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		logEntry.WithError(err).Error("read resp error")
+		logEntry.WithError(err).Error("fetch error")
+		collector.setStatus(profileType, src, start, attempts, err)
 		return
 	}
+	// The forwarded profile keeps the scrape time, it may be sent long after
+	src.Timestamp = time.Now()
 
 	if profileType == "trace" {
-		err = collector.analysisTrace(profileType, profileBytes)
-		if err != nil {
-			logEntry.WithError(err).Error("analysis result error")
-			return
-		}
-		return
+		err = collector.analysisTrace(src, profileType, profileBytes)
+	} else {
+		err = collector.analysis(src, profileType, profileBytes)
 	}
-
-	err = collector.analysis(profileType, profileBytes)
 	if err != nil {
 		logEntry.WithError(err).Error("analysis result error")
+		collector.setStatus(profileType, src, start, attempts, fmt.Errorf("analysis: %w", err))
 		return
 	}
-}
+	collector.setStatus(profileType, src, start, attempts, nil)
 
-func (collector *Collector) analysis(profileType string, profileBytes []byte) error {
-	p, err := profile.ParseData(profileBytes)
-	if err != nil {
-		return err
-	}
-	if len(p.SampleType) == 0 {
-		return errors.New("sample type is nil")
-	}
+	// Ship the stored profile to the upstream cprofilers
+	collector.forwarder.forward(src, profileType, profileBytes)
+}
 
-	// Set profile name , Display it on the Profile UI
-	if len(p.Mapping) > 0 {
-		p.Mapping[0].File = collector.JobName
-	}
+// fetchOnce Request the profile within the timeout, retry reports whether the error may be fixed by retrying
+func (collector *Collector) fetchOnce(profileConfig *ProfileConfig, host string) (profileBytes []byte, retry bool, err error) {
+	ctx, cancel := context.WithTimeout(collector.ctx, profileConfig.Timeout)
+	defer cancel()
 
-	b := &bytes.Buffer{}
-	if err = p.Write(b); err != nil {
-		return err
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+host+profileConfig.Path, nil)
+	if err != nil {
+		return nil, false, err
 	}
+	req.Header.Set("User-Agent", "")
 
-	profileID, err := collector.store.SaveProfile(fmt.Sprintf("%s-%s", collector.JobName, profileType), b.Bytes(), collector.Expiration)
+	resp, err := collector.httpClient.Do(req)
 	if err != nil {
-		return err
+		return nil, true, err
 	}
+	defer resp.Body.Close()
 
-	metas := make([]*storage.ProfileMeta, 0, len(p.SampleType))
-	for i := range p.SampleType {
-		meta := &storage.ProfileMeta{}
-		meta.Timestamp = time.Now().UnixNano() / time.Millisecond.Nanoseconds()
-		meta.ProfileID = profileID
-		meta.ProfileType = profileType
-		meta.JobName = collector.JobName
-		meta.Host = collector.Host
-		meta.App = collector.Target.Application
-
-		meta.Duration = p.DurationNanos
-		meta.SampleTypeUnit = p.SampleType[i].Unit
-		for _, s := range p.Sample {
-			meta.Value += s.Value[i]
-		}
-		if len(p.SampleType) > 1 {
-			meta.SampleType = fmt.Sprintf("%s_%s", profileType, p.SampleType[i].Type)
-		} else {
-			meta.SampleType = profileType
-		}
-
-		meta.Labels = collector.Target.Labels.ToArray()
-		metas = append(metas, meta)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		retry = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout
+		return nil, retry, fmt.Errorf("http resp status code is %d", resp.StatusCode)
 	}
 
-	err = collector.store.SaveProfileMeta(metas, collector.Expiration)
+	// one more byte to find out an oversized body
+	profileBytes, err = ioutil.ReadAll(io.LimitReader(resp.Body, profileConfig.MaxBodySize+1))
 	if err != nil {
-		return err
+		return nil, true, err
+	}
+	if int64(len(profileBytes)) > profileConfig.MaxBodySize {
+		return nil, false, fmt.Errorf("body size exceeds max-body-size %d", profileConfig.MaxBodySize)
 	}
-	return nil
+	return profileBytes, false, nil
 }
 
-func (collector *Collector) analysisTrace(profileType string, profileBytes []byte) error {
-	profileID, err := collector.store.SaveProfile(fmt.Sprintf("%s-%s", collector.JobName, profileType), profileBytes, collector.Expiration)
-	if err != nil {
-		return err
+// source The job and target of the collector, it must be called with the lock held
+func (collector *Collector) source() Source {
+	return Source{
+		JobName:    collector.JobName,
+		Host:       collector.Host,
+		App:        collector.Target.Application,
+		Labels:     collector.Target.Labels,
+		Expiration: collector.Expiration,
 	}
+}
 
-	metas := make([]*storage.ProfileMeta, 0, 1)
-	meta := &storage.ProfileMeta{}
-	meta.Timestamp = time.Now().UnixNano() / time.Millisecond.Nanoseconds()
-	meta.ProfileID = profileID
-	meta.ProfileType = profileType
-	meta.SampleType = profileType
-	meta.JobName = collector.JobName
-	meta.Host = collector.Host
-	meta.App = collector.Target.Application
-
-	meta.Labels = collector.Target.Labels.ToArray()
-	metas = append(metas, meta)
+func (collector *Collector) analysis(src Source, profileType string, profileBytes []byte) error {
+	return SaveProfile(collector.store, src, profileType, profileBytes)
+}
 
-	err = collector.store.SaveProfileMeta(metas, collector.Expiration)
-	if err != nil {
-		return err
-	}
-	return nil
+func (collector *Collector) analysisTrace(src Source, profileType string, profileBytes []byte) error {
+	return SaveTrace(collector.store, src, profileType, profileBytes)
 }
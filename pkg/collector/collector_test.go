@@ -0,0 +1,255 @@
+package collector
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cprofiler/pkg/storage/badger"
+
+	"github.com/stretchr/testify/require"
+)
+
+// countServer Count the scrapes of each profile path
+type countServer struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (s *countServer) count(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counts[path]
+}
+
+func (s *countServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counts[r.URL.Path]++
+}
+
+func TestCollectorIntervals(t *testing.T) {
+	cs := &countServer{counts: make(map[string]int)}
+	server := httptest.NewServer(cs)
+	defer server.Close()
+
+	target := &TargetConfig{Application: "app"}
+	host := strings.TrimPrefix(server.URL, "http://")
+	scrape := &ScrapeConfig{
+		Job:             "intervals",
+		Interval:        time.Hour,
+		EnabledProfiles: []string{"heap", "goroutine"},
+		Intervals:       map[string]time.Duration{"heap": 20 * time.Millisecond},
+	}
+
+	wg := &sync.WaitGroup{}
+	collector := newCollector(JobConfig{Scrape: scrape, Target: target, Host: host}, nil, nil, nil, wg)
+	require.Equal(t, 20*time.Millisecond, collector.Profiles["heap"].Interval)
+	require.Equal(t, time.Hour, collector.Profiles["goroutine"].Interval)
+	require.Equal(t, time.Hour, collector.Profiles["profile"].Interval)
+
+	collector.run()
+	require.Eventually(t, func() bool { return cs.count("/debug/pprof/heap") >= 3 }, 5*time.Second, 10*time.Millisecond)
+	require.True(t, cs.count("/debug/pprof/goroutine") <= 1)
+
+	// heap is disabled and goroutine is scraped faster, without restarting the collector
+	scrape = &ScrapeConfig{
+		Job:             "intervals",
+		Interval:        time.Hour,
+		EnabledProfiles: []string{"goroutine"},
+		Intervals:       map[string]time.Duration{"goroutine": 20 * time.Millisecond},
+	}
+	collector.reload(host, JobConfig{Scrape: scrape, Target: target, Host: host})
+	collector.mu.RLock()
+	require.Equal(t, 1, len(collector.loops))
+	require.Equal(t, 20*time.Millisecond, collector.loops["goroutine"].interval)
+	collector.mu.RUnlock()
+
+	goroutines := cs.count("/debug/pprof/goroutine")
+	require.Eventually(t, func() bool { return cs.count("/debug/pprof/goroutine") >= goroutines+3 }, 5*time.Second, 10*time.Millisecond)
+	heaps := cs.count("/debug/pprof/heap")
+	time.Sleep(100 * time.Millisecond)
+	require.True(t, cs.count("/debug/pprof/heap") <= heaps+1)
+
+	collector.exit()
+	wg.Wait()
+}
+
+func TestFetch(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	store := badger.NewStore(badger.DefaultOptions(dir))
+	defer store.Release()
+
+	heap := &bytes.Buffer{}
+	require.Equal(t, nil, pprof.Lookup("heap").WriteTo(heap, 0))
+
+	var mu sync.Mutex
+	requests := make(map[string]int)
+	mux := http.NewServeMux()
+	handle := func(path string, fn func(w http.ResponseWriter, n int)) {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			requests[path]++
+			n := requests[path]
+			mu.Unlock()
+			fn(w, n)
+		})
+	}
+	handle("/flaky", func(w http.ResponseWriter, n int) {
+		if n == 1 {
+			w.WriteHeader(http.StatusRequestTimeout)
+			return
+		}
+		if n == 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(heap.Bytes())
+	})
+	handle("/down", func(w http.ResponseWriter, n int) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	handle("/missing", func(w http.ResponseWriter, n int) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	handle("/slow", func(w http.ResponseWriter, n int) {
+		time.Sleep(200 * time.Millisecond)
+		w.Write(heap.Bytes())
+	})
+	handle("/big", func(w http.ResponseWriter, n int) {
+		w.Write(make([]byte, 2048))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	scrape := &ScrapeConfig{Job: "fetch", Interval: time.Hour, Retries: 2, RetryBackoff: 10 * time.Millisecond}
+	collector := newCollector(JobConfig{Scrape: scrape, Target: &TargetConfig{Application: "app"}, Host: host}, store, newForwarder(dir), nil, &sync.WaitGroup{})
+	src := collector.source()
+	fetch := func(profileType, path string, modify func(cfg *ProfileConfig)) ScrapeStatus {
+		cfg := *collector.Profiles[profileType]
+		cfg.Path = path
+		if modify != nil {
+			modify(&cfg)
+		}
+		collector.fetch(profileType, &cfg, src)
+		return *collector.status[profileType]
+	}
+
+	// retried until it succeeds
+	status := fetch("heap", "/flaky", nil)
+	require.Equal(t, HealthUp, status.Health)
+	require.Equal(t, 3, status.Attempts)
+	require.Equal(t, "", status.LastError)
+	require.Equal(t, host, status.Host)
+
+	// retries exhausted
+	status = fetch("goroutine", "/down", nil)
+	require.Equal(t, HealthDown, status.Health)
+	require.Equal(t, 3, status.Attempts)
+	require.Equal(t, "http resp status code is 502", status.LastError)
+	require.Equal(t, 1, status.Failures)
+	status = fetch("goroutine", "/down", func(cfg *ProfileConfig) { cfg.Retries = 0 })
+	require.Equal(t, 1, status.Attempts)
+	require.Equal(t, 2, status.Failures)
+
+	// not retried
+	status = fetch("mutex", "/missing", nil)
+	require.Equal(t, HealthDown, status.Health)
+	require.Equal(t, 1, status.Attempts)
+
+	// timeout
+	status = fetch("block", "/slow", func(cfg *ProfileConfig) { cfg.Timeout = 50 * time.Millisecond; cfg.Retries = 0 })
+	require.Equal(t, HealthDown, status.Health)
+	require.Contains(t, status.LastError, "deadline exceeded")
+	status = fetch("block", "/slow", nil)
+	require.Equal(t, HealthUp, status.Health)
+	require.Equal(t, 0, status.Failures)
+
+	// body size limit
+	status = fetch("allocs", "/big", func(cfg *ProfileConfig) { cfg.MaxBodySize = 1024 })
+	require.Equal(t, HealthDown, status.Health)
+	require.Equal(t, 1, status.Attempts)
+	require.Equal(t, "body size exceeds max-body-size 1024", status.LastError)
+
+	// invalid profile
+	status = fetch("threadcreate", "/big", nil)
+	require.Equal(t, HealthDown, status.Health)
+	require.Contains(t, status.LastError, "analysis: ")
+
+	statuses := collector.scrapeStatus()
+	require.Equal(t, 8, len(statuses))
+	for _, status := range statuses {
+		if status.ProfileType == "profile" {
+			require.Equal(t, HealthUnknown, status.Health)
+		}
+	}
+}
+
+func TestProfileTimeouts(t *testing.T) {
+	cfgs := buildProfileConfigs(&ScrapeConfig{Timeouts: map[string]time.Duration{"heap": 5 * time.Second}})
+	require.Equal(t, 20*time.Second, cfgs["profile"].Timeout)
+	require.Equal(t, 20*time.Second, cfgs["trace"].Timeout)
+	require.Equal(t, 5*time.Second, cfgs["heap"].Timeout)
+	require.Equal(t, defaultScrapeTimeout, cfgs["goroutine"].Timeout)
+	require.Equal(t, defaultRetryBackoff, cfgs["goroutine"].RetryBackoff)
+	require.Equal(t, defaultScrapeInterval, cfgs["goroutine"].MaxRetryBackoff)
+	require.Equal(t, int64(defaultMaxBodySize), cfgs["goroutine"].MaxBodySize)
+
+	// the retry backoff is capped by the interval
+	cfgs = buildProfileConfigs(&ScrapeConfig{Interval: 10 * time.Second, RetryBackoff: time.Minute, MaxRetryBackoff: time.Hour,
+		Intervals: map[string]time.Duration{"heap": time.Hour}})
+	require.Equal(t, 10*time.Second, cfgs["goroutine"].MaxRetryBackoff)
+	require.Equal(t, 10*time.Second, cfgs["goroutine"].RetryBackoff)
+	require.Equal(t, time.Hour, cfgs["heap"].MaxRetryBackoff)
+	require.Equal(t, time.Minute, cfgs["heap"].RetryBackoff)
+
+	cfgs = buildProfileConfigs(&ScrapeConfig{Path: map[string]string{"profile": "/debug/pprof/profile?seconds=30"}})
+	require.Equal(t, 40*time.Second, cfgs["profile"].Timeout)
+}
+
+func TestFetchReleasesLimiterWhileBackingOff(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	store := badger.NewStore(badger.DefaultOptions(dir))
+	defer store.Release()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	limiter := newScrapeLimiter(1)
+	scrape := &ScrapeConfig{Job: "limit", Interval: time.Hour, Retries: 1, RetryBackoff: 500 * time.Millisecond}
+	host := strings.TrimPrefix(server.URL, "http://")
+	collector := newCollector(JobConfig{Scrape: scrape, Target: &TargetConfig{Application: "app"}, Host: host}, store, newForwarder(dir), limiter, &sync.WaitGroup{})
+	cfg := *collector.Profiles["heap"]
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		collector.fetch("heap", &cfg, collector.source())
+	}()
+
+	// the other scrapes get the slot while the failed one backs off
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case limiter <- struct{}{}:
+		limiter.release()
+	case <-time.After(200 * time.Millisecond):
+		t.Fatal("the scrape slot is held while backing off")
+	}
+	<-done
+	require.Equal(t, 2, collector.status["heap"].Attempts)
+}
@@ -3,6 +3,8 @@ package collector
 import (
 	"io/ioutil"
 	"log"
+	"net/url"
+	"strconv"
 	"time"
 
 	"cprofiler/pkg/storage"
@@ -76,7 +78,41 @@ func LoadConfig(configPath string, fn func(CollectorConfig)) error {
 }
 
 type CollectorConfig struct {
-	ScrapeConfigs []ScrapeConfig `yaml:"scrape-configs"`
+	ScrapeConfigs []ScrapeConfig      `yaml:"scrape-configs"`
+	RemoteWrite   []RemoteWriteConfig `yaml:"remote-write"`
+}
+
+// RemoteWriteConfig The upstream cprofiler endpoint the scraped profiles are forwarded to
+type RemoteWriteConfig struct {
+	URL           string        `yaml:"url"`
+	Timeout       time.Duration `yaml:"timeout"`
+	MaxQueueFiles int           `yaml:"max-queue-files"`
+	MinBackoff    time.Duration `yaml:"min-backoff"`
+	MaxBackoff    time.Duration `yaml:"max-backoff"`
+	// BearerToken The token sent in the Authorization header, the remote-write-token of the upstream
+	BearerToken string `yaml:"bearer-token"`
+	// Headers The extra http headers of the requests
+	Headers map[string]string `yaml:"headers"`
+}
+
+// withDefaults Fill the unset fields with the defaults
+func (cfg RemoteWriteConfig) withDefaults() RemoteWriteConfig {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	if cfg.MaxQueueFiles <= 0 {
+		cfg.MaxQueueFiles = 10000
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = time.Second
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = time.Minute
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = cfg.MinBackoff
+	}
+	return cfg
 }
 
 type ScrapeConfig struct {
@@ -85,7 +121,18 @@ type ScrapeConfig struct {
 	Expiration      time.Duration     `yaml:"expiration"`
 	EnabledProfiles []string          `yaml:"enabled-profiles"`
 	Path            map[string]string `yaml:"path-profiles"`
-	Targets         []TargetConfig    `yaml:"target-configs"`
+	// Intervals The scrape intervals of the profile types, the others are scraped at Interval
+	Intervals map[string]time.Duration `yaml:"intervals"`
+	// Timeouts The scrape timeouts of the profile types, the default is the seconds of the path plus a margin, or 30s
+	Timeouts map[string]time.Duration `yaml:"timeouts"`
+	// Retries The number of retries of a failed scrape, the backoff starts at RetryBackoff and doubles after each retry,
+	// up to MaxRetryBackoff, which is the scrape interval of the profile type by default
+	Retries         int           `yaml:"retries"`
+	RetryBackoff    time.Duration `yaml:"retry-backoff"`
+	MaxRetryBackoff time.Duration `yaml:"max-retry-backoff"`
+	// MaxBodySize The max size of a scraped profile, in bytes
+	MaxBodySize int64          `yaml:"max-body-size"`
+	Targets     []TargetConfig `yaml:"target-configs"`
 }
 
 type TargetConfig struct {
@@ -95,8 +142,40 @@ type TargetConfig struct {
 }
 
 type ProfileConfig struct {
-	Path   string
-	Enable bool
+	Path            string
+	Enable          bool
+	Interval        time.Duration
+	Timeout         time.Duration
+	Retries         int
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+	MaxBodySize     int64
+}
+
+const (
+	// defaultScrapeInterval The scrape interval if neither interval nor intervals is configured
+	defaultScrapeInterval = time.Minute
+	// defaultScrapeTimeout The scrape timeout of the paths without seconds
+	defaultScrapeTimeout = 30 * time.Second
+	// scrapeTimeoutMargin The margin added to the seconds of the path, such as profile and trace
+	scrapeTimeoutMargin = 10 * time.Second
+	// defaultRetryBackoff The backoff of the first retry
+	defaultRetryBackoff = time.Second
+	// defaultMaxBodySize The max size of a scraped profile
+	defaultMaxBodySize = 256 << 20
+)
+
+// defaultTimeout The seconds of the path plus the margin, the target blocks for seconds before responding
+func defaultTimeout(path string) time.Duration {
+	u, err := url.Parse(path)
+	if err != nil {
+		return defaultScrapeTimeout
+	}
+	seconds, err := strconv.Atoi(u.Query().Get("seconds"))
+	if err != nil || seconds <= 0 {
+		return defaultScrapeTimeout
+	}
+	return time.Duration(seconds)*time.Second + scrapeTimeoutMargin
 }
 
 type JobConfig struct {
@@ -180,6 +259,36 @@ func buildProfileConfigs(scrape *ScrapeConfig) map[string]*ProfileConfig {
 		if _, ok := paths[key]; ok {
 			item.Path = paths[key]
 		}
+
+		item.Interval = scrape.Interval
+		if interval, ok := scrape.Intervals[key]; ok && interval > 0 {
+			item.Interval = interval
+		}
+		if item.Interval <= 0 {
+			item.Interval = defaultScrapeInterval
+		}
+
+		item.Timeout = defaultTimeout(item.Path)
+		if timeout, ok := scrape.Timeouts[key]; ok && timeout > 0 {
+			item.Timeout = timeout
+		}
+
+		item.Retries = scrape.Retries
+		item.RetryBackoff = scrape.RetryBackoff
+		if item.RetryBackoff <= 0 {
+			item.RetryBackoff = defaultRetryBackoff
+		}
+		item.MaxRetryBackoff = scrape.MaxRetryBackoff
+		if item.MaxRetryBackoff <= 0 || item.MaxRetryBackoff > item.Interval {
+			item.MaxRetryBackoff = item.Interval
+		}
+		if item.RetryBackoff > item.MaxRetryBackoff {
+			item.RetryBackoff = item.MaxRetryBackoff
+		}
+		item.MaxBodySize = scrape.MaxBodySize
+		if item.MaxBodySize <= 0 {
+			item.MaxBodySize = defaultMaxBodySize
+		}
 	}
 
 	return cfgs
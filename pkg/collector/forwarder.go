@@ -0,0 +1,353 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	// forwardItemExt The extension of the queued profile files, the files are written to a temp file first
+	forwardItemExt = ".item"
+	forwardTempExt = ".tmp"
+)
+
+// forwardItem A profile queued to forward, the scrape time is the Timestamp of Source
+type forwardItem struct {
+	Source      Source
+	ProfileType string
+	Data        []byte
+}
+
+// Forwarder Forward the scraped profiles to the upstream cprofiler instances.
+// Each remote write endpoint has its own queue on disk, so the profiles are retried until they are accepted,
+// even after a restart
+type Forwarder struct {
+	queuePath string
+	writers   map[string]*remoteWriter
+	mu        sync.Mutex
+	log       *logrus.Entry
+}
+
+func newForwarder(queuePath string) *Forwarder {
+	return &Forwarder{
+		queuePath: queuePath,
+		writers:   make(map[string]*remoteWriter),
+		log:       logrus.WithField("component", "forwarder"),
+	}
+}
+
+// load Start the writers of the new endpoints, restart the changed ones and stop the removed ones.
+// The queue of a removed endpoint is kept on disk, it is sent if the endpoint is added again
+func (f *Forwarder) load(configs []RemoteWriteConfig) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	cfgs := make(map[string]RemoteWriteConfig, len(configs))
+	for _, cfg := range configs {
+		if cfg.URL == "" {
+			f.log.Warn("remote write url is empty")
+			continue
+		}
+		cfgs[cfg.URL] = cfg.withDefaults()
+	}
+
+	for key, w := range f.writers {
+		if cfg, ok := cfgs[key]; ok && reflect.DeepEqual(cfg, w.cfg) {
+			continue
+		}
+		f.log.Info("stop remote writer ", key)
+		w.stop()
+		delete(f.writers, key)
+	}
+
+	for key, cfg := range cfgs {
+		if _, ok := f.writers[key]; ok {
+			continue
+		}
+		w, err := newRemoteWriter(cfg, f.queueDir(key))
+		if err != nil {
+			f.log.WithError(err).Error("new remote writer error ", key)
+			continue
+		}
+		f.log.Info("start remote writer ", key)
+		f.writers[key] = w
+		w.run()
+	}
+}
+
+// queueDir The queue directory of the endpoint
+func (f *Forwarder) queueDir(endpoint string) string {
+	h := fnv.New64a()
+	h.Write([]byte(endpoint))
+	return filepath.Join(f.queuePath, fmt.Sprintf("%016x", h.Sum64()))
+}
+
+// forward Queue the profile to all the endpoints.
+// The queue files are written outside the lock, so a slow disk does not block the reload and the other scrapes
+func (f *Forwarder) forward(src Source, profileType string, profileBytes []byte) {
+	f.mu.Lock()
+	writers := make(map[string]*remoteWriter, len(f.writers))
+	for key, w := range f.writers {
+		writers[key] = w
+	}
+	f.mu.Unlock()
+
+	if len(writers) == 0 {
+		return
+	}
+	b, err := msgpack.Marshal(&forwardItem{Source: src, ProfileType: profileType, Data: profileBytes})
+	if err != nil {
+		f.log.WithError(err).Error("marshal forward item error")
+		return
+	}
+	for key, w := range writers {
+		if err = w.enqueue(b); err != nil {
+			f.log.WithError(err).Error("enqueue forward item error ", key)
+		}
+	}
+}
+
+// stop Stop all the writers, the queued profiles are sent after the next start
+func (f *Forwarder) stop() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for key, w := range f.writers {
+		w.stop()
+		delete(f.writers, key)
+	}
+}
+
+// remoteWriter Send the queued profiles to an endpoint in order
+type remoteWriter struct {
+	cfg        RemoteWriteConfig
+	dir        string
+	pending    []string
+	seq        int64
+	mu         sync.Mutex
+	notify     chan struct{}
+	ctx        context.Context
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+	httpClient *http.Client
+	log        *logrus.Entry
+}
+
+func newRemoteWriter(cfg RemoteWriteConfig, dir string) (*remoteWriter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &remoteWriter{
+		cfg:        cfg,
+		dir:        dir,
+		notify:     make(chan struct{}, 1),
+		ctx:        ctx,
+		cancel:     cancel,
+		httpClient: &http.Client{Timeout: cfg.Timeout},
+		log:        logrus.WithField("remote_write", cfg.URL),
+	}
+	// ReadDir sorts by name, which is the enqueue order
+	for _, info := range infos {
+		switch filepath.Ext(info.Name()) {
+		case forwardItemExt:
+			w.pending = append(w.pending, info.Name())
+		case forwardTempExt:
+			os.Remove(filepath.Join(dir, info.Name()))
+		}
+	}
+	if len(w.pending) > 0 {
+		w.log.Infof("%d profiles queued", len(w.pending))
+	}
+	return w, nil
+}
+
+func (w *remoteWriter) run() {
+	w.wg.Add(1)
+	go w.sendLoop()
+}
+
+func (w *remoteWriter) stop() {
+	w.cancel()
+	w.wg.Wait()
+}
+
+// enqueue Write the item to the queue, the oldest items are dropped if the queue is full
+func (w *remoteWriter) enqueue(b []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.seq++
+	name := fmt.Sprintf("%020d-%06d", time.Now().UnixNano(), w.seq%1000000)
+	tmp := filepath.Join(w.dir, name+forwardTempExt)
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(w.dir, name+forwardItemExt)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	w.pending = append(w.pending, name+forwardItemExt)
+
+	for w.cfg.MaxQueueFiles > 0 && len(w.pending) > w.cfg.MaxQueueFiles {
+		w.log.Warn("queue is full, drop ", w.pending[0])
+		os.Remove(filepath.Join(w.dir, w.pending[0]))
+		w.pending = w.pending[1:]
+	}
+
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// head The oldest queued item
+func (w *remoteWriter) head() (string, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.pending) == 0 {
+		return "", false
+	}
+	return w.pending[0], true
+}
+
+// remove Remove the sent or dropped item, it may have been dropped by enqueue already
+func (w *remoteWriter) remove(name string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.pending) > 0 && w.pending[0] == name {
+		w.pending = w.pending[1:]
+	}
+	os.Remove(filepath.Join(w.dir, name))
+}
+
+func (w *remoteWriter) sendLoop() {
+	defer w.wg.Done()
+
+	backoff := w.cfg.MinBackoff
+	for {
+		name, ok := w.head()
+		if !ok {
+			select {
+			case <-w.ctx.Done():
+				return
+			case <-w.notify:
+				continue
+			}
+		}
+
+		retry, err := w.send(name)
+		if err == nil || !retry {
+			if err != nil {
+				w.log.WithError(err).Error("drop profile ", name)
+			}
+			w.remove(name)
+			backoff = w.cfg.MinBackoff
+			continue
+		}
+
+		w.log.WithError(err).Warnf("send profile %s error, retry in %s", name, backoff)
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > w.cfg.MaxBackoff {
+			backoff = w.cfg.MaxBackoff
+		}
+	}
+}
+
+// send Post the item to the endpoint, retry reports whether a failed item should be sent again
+func (w *remoteWriter) send(name string) (retry bool, err error) {
+	b, err := ioutil.ReadFile(filepath.Join(w.dir, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	item := &forwardItem{}
+	if err = msgpack.Unmarshal(b, item); err != nil {
+		return false, err
+	}
+
+	query := url.Values{}
+	query.Set("job", item.Source.JobName)
+	query.Set("host", item.Source.Host)
+	query.Set("app", item.Source.App)
+	query.Set("profile_type", item.ProfileType)
+	// the upstream keeps the profile for the rest of its expiration since the scrape
+	if expiration := item.Source.Expiration; expiration > 0 {
+		if !item.Source.Timestamp.IsZero() {
+			expiration -= time.Since(item.Source.Timestamp)
+		}
+		if expiration <= 0 {
+			w.log.Info("drop expired profile ", name)
+			return false, nil
+		}
+		query.Set("expiration", expiration.String())
+	}
+	if !item.Source.Timestamp.IsZero() {
+		query.Set("timestamp", strconv.FormatInt(item.Source.Timestamp.UnixNano()/time.Millisecond.Nanoseconds(), 10))
+	}
+	for k, v := range item.Source.Labels {
+		query.Set(fmt.Sprintf("lbs[%s]", k), v)
+	}
+
+	u := w.cfg.URL
+	if strings.Contains(u, "?") {
+		u += "&" + query.Encode()
+	} else {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(w.ctx, http.MethodPost, u, bytes.NewReader(item.Data))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	for k, v := range w.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	if w.cfg.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+w.cfg.BearerToken)
+	}
+
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("http resp status code is %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	// the other client errors can not be fixed by retrying
+	retry = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout
+	return retry, err
+}
@@ -0,0 +1,139 @@
+package collector
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// remoteWriteServer Record the received profiles, it responds status until it is set to 200
+type remoteWriteServer struct {
+	mu       sync.Mutex
+	status   int
+	received []url.Values
+	headers  []http.Header
+	bodies   []string
+}
+
+func (s *remoteWriteServer) setStatus(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+}
+
+func (s *remoteWriteServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.received)
+}
+
+func (s *remoteWriteServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status != http.StatusOK {
+		w.WriteHeader(s.status)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	s.received = append(s.received, r.URL.Query())
+	s.headers = append(s.headers, r.Header)
+	s.bodies = append(s.bodies, string(body))
+}
+
+func queueFiles(t *testing.T, dir string) int {
+	files, err := filepath.Glob(filepath.Join(dir, "*", "*"+forwardItemExt))
+	require.Equal(t, nil, err)
+	return len(files)
+}
+
+func TestForwarder(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	rs := &remoteWriteServer{status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(rs)
+	defer server.Close()
+
+	src := Source{JobName: "job", Host: "127.0.0.1:9000", App: "app", Labels: LabelConfig{"env": "dev"}, Expiration: time.Hour, Timestamp: time.Now().Add(-10 * time.Minute).Truncate(time.Millisecond)}
+	cfgs := []RemoteWriteConfig{{URL: server.URL + "/api/remote_write", MinBackoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond,
+		BearerToken: "secret", Headers: map[string]string{"X-Scope-OrgID": "team-a"}}}
+
+	// Retried until accepted
+	f := newForwarder(dir)
+	f.load(cfgs)
+	f.forward(src, "heap", []byte("heap data"))
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, 0, rs.count())
+	require.Equal(t, 1, queueFiles(t, dir))
+
+	rs.setStatus(http.StatusOK)
+	require.Eventually(t, func() bool { return rs.count() == 1 }, 5*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool { return queueFiles(t, dir) == 0 }, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, "job", rs.received[0].Get("job"))
+	require.Equal(t, "127.0.0.1:9000", rs.received[0].Get("host"))
+	require.Equal(t, "app", rs.received[0].Get("app"))
+	require.Equal(t, "heap", rs.received[0].Get("profile_type"))
+	// the rest of the expiration since the scrape
+	expiration, err := time.ParseDuration(rs.received[0].Get("expiration"))
+	require.Equal(t, nil, err)
+	require.True(t, expiration > 45*time.Minute && expiration <= 50*time.Minute, expiration)
+	require.Equal(t, "dev", rs.received[0].Get("lbs[env]"))
+	require.Equal(t, strconv.FormatInt(src.Timestamp.UnixNano()/time.Millisecond.Nanoseconds(), 10), rs.received[0].Get("timestamp"))
+	require.Equal(t, "Bearer secret", rs.headers[0].Get("Authorization"))
+	require.Equal(t, "team-a", rs.headers[0].Get("X-Scope-OrgID"))
+	require.Equal(t, "heap data", rs.bodies[0])
+
+	// Kept on disk after a restart
+	rs.setStatus(http.StatusBadGateway)
+	f.forward(src, "profile", []byte("profile data"))
+	f.forward(src, "trace", []byte("trace data"))
+	f.stop()
+	require.Equal(t, 2, queueFiles(t, dir))
+
+	rs.setStatus(http.StatusOK)
+	f = newForwarder(dir)
+	f.load(cfgs)
+	require.Eventually(t, func() bool { return rs.count() == 3 }, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, "profile", rs.received[1].Get("profile_type"))
+	require.Equal(t, "trace", rs.received[2].Get("profile_type"))
+
+	// Dropped if rejected
+	rs.setStatus(http.StatusBadRequest)
+	f.forward(src, "heap", []byte("invalid"))
+	require.Eventually(t, func() bool { return queueFiles(t, dir) == 0 }, 5*time.Second, 10*time.Millisecond)
+
+	// Dropped without sending if expired
+	rs.setStatus(http.StatusOK)
+	expired := src
+	expired.Timestamp = time.Now().Add(-2 * time.Hour)
+	f.forward(expired, "heap", []byte("expired"))
+	require.Eventually(t, func() bool { return queueFiles(t, dir) == 0 }, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, 3, rs.count())
+	f.stop()
+
+	// The oldest ones are dropped if the queue is full
+	rs.setStatus(http.StatusServiceUnavailable)
+	cfgs[0].MaxQueueFiles = 2
+	f = newForwarder(dir)
+	f.load(cfgs)
+	for i := 0; i < 5; i++ {
+		f.forward(src, "heap", []byte("heap data"))
+	}
+	require.Equal(t, 2, queueFiles(t, dir))
+
+	// Stopped if removed
+	f.load(nil)
+	require.Equal(t, 0, len(f.writers))
+	f.forward(src, "heap", []byte("heap data"))
+	require.Equal(t, 2, queueFiles(t, dir))
+}
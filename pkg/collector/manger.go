@@ -10,17 +10,23 @@ import (
 
 // Manger Manage multiple collectors to scraping
 type Manger struct {
+	opt        Options
 	collectors map[string]*Collector
 	store      storage.Store
+	forwarder  *Forwarder
+	limiter    scrapeLimiter
 	wg         *sync.WaitGroup
 	mu         sync.Mutex
 }
 
 // NewManger new Manger instance
-func NewManger(store storage.Store) *Manger {
+func NewManger(opt Options) *Manger {
 	c := &Manger{
+		opt:        opt,
 		collectors: make(map[string]*Collector),
-		store:      store,
+		store:      opt.Store,
+		forwarder:  newForwarder(opt.RemoteWriteQueuePath),
+		limiter:    newScrapeLimiter(opt.ScrapeConcurrency),
 		wg:         &sync.WaitGroup{},
 	}
 	return c
@@ -34,19 +40,26 @@ func (manger *Manger) Stop() {
 		c.exit()
 	}
 	manger.wg.Wait()
+	manger.forwarder.stop()
 	log.Info("collector manger exit ")
 }
 
 // NewManger Loading collector configuration
 // It can be called multiple times, and the collector updates the configuration
+// With sharding, only the collectors of the hosts in the shard of the replica are run
 func (manger *Manger) Load(config CollectorConfig) {
 	manger.mu.Lock()
 	defer manger.mu.Unlock()
 
+	manger.forwarder.load(config.RemoteWrite)
+
 	hosts := make(map[string]JobConfig)
 	for _, scrape := range config.ScrapeConfigs {
 		for _, target := range scrape.Targets {
 			for _, host := range target.Hosts {
+				if !inShard(host, manger.opt.ShardIndex, manger.opt.ShardCount) {
+					continue
+				}
 				_scrape := scrape
 				_target := target
 				hosts[host] = JobConfig{
@@ -73,7 +86,7 @@ func (manger *Manger) Load(config CollectorConfig) {
 		if !ok {
 			// add collector
 			log.Info("add collector ", job.Scrape.Job, "_", job.Target.Application, "_", host)
-			collector := newCollector(job, manger.store, manger.wg)
+			collector := newCollector(job, manger.store, manger.forwarder, manger.limiter, manger.wg)
 			manger.collectors[host] = collector
 			collector.run()
 			continue
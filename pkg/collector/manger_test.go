@@ -24,7 +24,7 @@ package collector
 // 	store := badger.NewStore(badger.DefaultOptions(dir))
 // 	defer store.Release()
 
-// 	manger := NewManger(store)
+// 	manger := NewManger(DefaultOptions(store))
 // 	c := &Config{}
 // 	yaml.Unmarshal([]byte(generalConfigYAML), c)
 // 	config := c.Collector
@@ -54,7 +54,7 @@ package collector
 // 	defer os.RemoveAll(dir)
 // 	store := badger.NewStore(badger.DefaultOptions(dir))
 // 	defer store.Release()
-// 	manger := NewManger(store)
+// 	manger := NewManger(DefaultOptions(store))
 // 	config := &CollectorConfig{}
 // 	yaml.Unmarshal([]byte(errHostConfigYAML), config)
 // 	manger.Load(*config)
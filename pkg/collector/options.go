@@ -0,0 +1,40 @@
+package collector
+
+import (
+	"cprofiler/pkg/storage"
+)
+
+type Options struct {
+	Store storage.Store
+	// RemoteWriteQueuePath The directory of the remote write queues
+	RemoteWriteQueuePath string
+	// ShardIndex, ShardCount The replica only scrapes the hosts in shard ShardIndex of ShardCount shards,
+	// ShardCount <= 1 means no sharding
+	ShardIndex int
+	ShardCount int
+	// ScrapeConcurrency The max number of concurrent scrapes of all collectors, 0 means no limit
+	ScrapeConcurrency int
+}
+
+func DefaultOptions(store storage.Store) Options {
+	return Options{
+		Store:                store,
+		RemoteWriteQueuePath: "./data/cprofiler/remote-write",
+	}
+}
+
+func (opt Options) WithRemoteWriteQueuePath(path string) Options {
+	opt.RemoteWriteQueuePath = path
+	return opt
+}
+
+func (opt Options) WithShard(index, count int) Options {
+	opt.ShardIndex = index
+	opt.ShardCount = count
+	return opt
+}
+
+func (opt Options) WithScrapeConcurrency(n int) Options {
+	opt.ScrapeConcurrency = n
+	return opt
+}
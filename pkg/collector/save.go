@@ -0,0 +1,240 @@
+package collector
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"cprofiler/pkg/profiles"
+	"cprofiler/pkg/storage"
+
+	"github.com/google/pprof/profile"
+	"github.com/sirupsen/logrus"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// maxSampleLabels The max number of pprof sample labels recorded in a profile meta,
+// the labels are also limited by the encoded meta size, see fitSampleLabels
+const maxSampleLabels = 16
+
+// Source The job and target a profile comes from, scraped by a collector or pushed to cprofiler
+type Source struct {
+	JobName    string
+	Host       string
+	App        string
+	Labels     LabelConfig
+	Expiration time.Duration
+	// Timestamp The time the profile is scraped, now if it is zero
+	Timestamp time.Time
+	// Pushed The profile is pushed to cprofiler by the ingest or OTLP api instead of scraped, see PrepareProfile
+	Pushed bool
+}
+
+// timestamp The scrape time of the profile in milliseconds
+func (src Source) timestamp() int64 {
+	t := src.Timestamp
+	if t.IsZero() {
+		t = time.Now()
+	}
+	return t.UnixNano() / time.Millisecond.Nanoseconds()
+}
+
+// SaveProfile Save the pprof profile of profileType from src, with one meta per sample type
+func SaveProfile(store storage.Store, src Source, profileType string, profileBytes []byte) error {
+	pp, err := PrepareProfile(src, profileType, profileBytes)
+	if err != nil {
+		return err
+	}
+	return pp.Save(store)
+}
+
+// PreparedProfile A parsed profile with its metas, it is checked and ready to save
+type PreparedProfile struct {
+	name       string
+	data       []byte
+	metas      []*storage.ProfileMeta
+	expiration time.Duration
+}
+
+// PrepareProfile Parse the pprof profile of profileType from src and build one meta per sample type,
+// so several profiles can be checked before any of them is saved.
+// The sample type of a meta is profileType_type, or profileType if it is the only sample type of a scraped profile.
+// The only sample type of a pushed profile is named profileType only if it is the profile type,
+// so a pushed profile with one of the sample types lines up with the scraped one
+func PrepareProfile(src Source, profileType string, profileBytes []byte) (*PreparedProfile, error) {
+	p, err := profile.ParseData(profileBytes)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.SampleType) == 0 {
+		return nil, errors.New("sample type is nil")
+	}
+
+	// Set profile name , Display it on the Profile UI
+	if len(p.Mapping) > 0 {
+		p.Mapping[0].File = src.JobName
+	}
+
+	b := &bytes.Buffer{}
+	if err = p.Write(b); err != nil {
+		return nil, err
+	}
+
+	sampleLabels := profiles.SampleLabels(p)
+	allSampleLabels := len(sampleLabels)
+	if len(sampleLabels) > maxSampleLabels {
+		logrus.WithFields(logrus.Fields{"collector": src.JobName, "profile_type": profileType}).
+			Warnf("too many pprof sample labels %d, only record %d", len(sampleLabels), maxSampleLabels)
+		sampleLabels = sampleLabels[:maxSampleLabels]
+	}
+
+	now := src.timestamp()
+	metas := make([]*storage.ProfileMeta, 0, len(p.SampleType))
+	for i := range p.SampleType {
+		meta := &storage.ProfileMeta{}
+		meta.Timestamp = now
+		meta.ProfileType = profileType
+		meta.JobName = src.JobName
+		meta.Host = src.Host
+		meta.App = src.App
+
+		meta.Duration = p.DurationNanos
+		meta.SampleTypeUnit = p.SampleType[i].Unit
+		for _, s := range p.Sample {
+			meta.Value += s.Value[i]
+		}
+		if len(p.SampleType) > 1 || (src.Pushed && p.SampleType[i].Type != profileType) {
+			meta.SampleType = fmt.Sprintf("%s_%s", profileType, p.SampleType[i].Type)
+		} else {
+			meta.SampleType = profileType
+		}
+
+		meta.Labels = src.Labels.ToArray()
+		if meta.SampleLabels, err = fitSampleLabels(meta, sampleLabels); err != nil {
+			return nil, err
+		}
+		meta.SampleLabelValues = sampleLabelValues(p, i, meta.SampleLabels)
+		meta.SampleLabelsTruncated = len(meta.SampleLabels) < allSampleLabels
+		metas = append(metas, meta)
+	}
+	if len(metas[0].SampleLabels) < len(sampleLabels) {
+		logrus.WithFields(logrus.Fields{"collector": src.JobName, "profile_type": profileType}).
+			Warnf("pprof sample labels are too long, only record %d of %d", len(metas[0].SampleLabels), len(sampleLabels))
+	}
+
+	return &PreparedProfile{
+		name:       fmt.Sprintf("%s-%s", src.JobName, profileType),
+		data:       b.Bytes(),
+		metas:      metas,
+		expiration: src.Expiration,
+	}, nil
+}
+
+// Save Save the profile and its metas in one transaction
+func (pp *PreparedProfile) Save(store storage.Store) error {
+	_, err := store.SaveProfileWithMeta(pp.name, pp.data, pp.metas, pp.expiration)
+	return err
+}
+
+// SavePreparedProfiles Save the profiles and their metas in one transaction, none of them is saved if it fails
+func SavePreparedProfiles(store storage.Store, pps []*PreparedProfile) error {
+	profiles := make([]*storage.ProfileWithMeta, 0, len(pps))
+	for _, pp := range pps {
+		profiles = append(profiles, &storage.ProfileWithMeta{Name: pp.name, Data: pp.data, Metas: pp.metas, TTL: pp.expiration})
+	}
+	_, err := store.SaveProfilesWithMeta(profiles)
+	return err
+}
+
+// fitSampleLabels Return the sample labels that keep the encoded meta under storage.MaxMetaSize,
+// a label that does not fit is skipped, so long label values do not get the whole profile rejected
+func fitSampleLabels(meta *storage.ProfileMeta, labels []storage.Label) ([]storage.Label, error) {
+	// The profile id is set by the store, count it at its longest
+	m := *meta
+	m.ProfileID = strconv.FormatUint(math.MaxUint64, 10)
+	m.SampleLabels = nil
+	m.SampleLabelValues = nil
+	b, err := m.Encode()
+	if err != nil {
+		return nil, err
+	}
+	// the nil labels and values are 1 byte each, the array header of up to 65535 elements is 3 bytes
+	size := len(b) - 2 + 6
+
+	res := make([]storage.Label, 0, len(labels))
+	for i := range labels {
+		lb, err := msgpack.Marshal(&labels[i])
+		if err != nil {
+			return nil, err
+		}
+		// the label value is an int64 of up to 9 bytes
+		if size+len(lb)+9 > storage.MaxMetaSize {
+			continue
+		}
+		size += len(lb) + 9
+		res = append(res, labels[i])
+	}
+	return res, nil
+}
+
+// sampleLabelValues Return the values of sample type sampleIndex summed by each of labels
+func sampleLabelValues(p *profile.Profile, sampleIndex int, labels []storage.Label) []int64 {
+	if len(labels) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(labels))
+	for i, l := range labels {
+		// labels are sorted by key
+		if i == 0 || labels[i-1].Key != l.Key {
+			keys = append(keys, l.Key)
+		}
+	}
+
+	aggregated := profiles.AggregateByLabel(p, sampleIndex, keys...)
+	values := make([]int64, len(labels))
+	for i, l := range labels {
+		values[i] = aggregated[l.Key][l.Value]
+	}
+	return values
+}
+
+// SaveTrace Save the go trace from src, the raw trace is still saved if it can not be summarized
+func SaveTrace(store storage.Store, src Source, profileType string, profileBytes []byte) error {
+	now := src.timestamp()
+	newMeta := func(sampleType, unit string, value int64) *storage.ProfileMeta {
+		meta := &storage.ProfileMeta{}
+		meta.Timestamp = now
+		meta.ProfileType = profileType
+		meta.SampleType = sampleType
+		meta.SampleTypeUnit = unit
+		meta.Value = value
+		meta.JobName = src.JobName
+		meta.Host = src.Host
+		meta.App = src.App
+
+		meta.Labels = src.Labels.ToArray()
+		return meta
+	}
+
+	metas := make([]*storage.ProfileMeta, 0, 1)
+	metas = append(metas, newMeta(profileType, "", 0))
+
+	summary, err := profiles.SummarizeTrace(profileBytes)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"collector": src.JobName, "profile_type": profileType}).
+			WithError(err).Warn("summarize trace error")
+	} else {
+		metas[0].Duration = summary.Duration
+		for _, metric := range summary.Metrics {
+			meta := newMeta(fmt.Sprintf("%s_%s", profileType, metric.Name), metric.Unit, metric.Value)
+			meta.Duration = summary.Duration
+			metas = append(metas, meta)
+		}
+	}
+
+	_, err = store.SaveProfileWithMeta(fmt.Sprintf("%s-%s", src.JobName, profileType), profileBytes, metas, src.Expiration)
+	return err
+}
@@ -0,0 +1,102 @@
+package collector
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"cprofiler/pkg/storage"
+	"cprofiler/pkg/storage/badger"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveProfileLongSampleLabels(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	store := badger.NewStore(badger.DefaultOptions(dir))
+	defer store.Release()
+
+	fn := &profile.Function{ID: 1, Name: "main.main"}
+	loc := &profile.Location{ID: 1, Line: []profile.Line{{Function: fn}}}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}, {Type: "cpu", Unit: "nanoseconds"}},
+		Function:   []*profile.Function{fn},
+		Location:   []*profile.Location{loc},
+	}
+	// Each long label fits alone, but not all of them
+	for _, v := range []string{"a", "b", "c", "d"} {
+		p.Sample = append(p.Sample, &profile.Sample{
+			Location: []*profile.Location{loc},
+			Value:    []int64{1, 10},
+			Label:    map[string][]string{"query": {strings.Repeat(v, 300)}},
+		})
+	}
+	p.Sample = append(p.Sample, &profile.Sample{
+		Location: []*profile.Location{loc},
+		Value:    []int64{1, 10},
+		Label:    map[string][]string{"tenant": {"acme"}},
+	})
+	b := &bytes.Buffer{}
+	require.Equal(t, nil, p.Write(b))
+
+	src := Source{JobName: "job", Host: "127.0.0.1:9000", App: "app", Labels: LabelConfig{"env": "dev"}, Expiration: time.Hour}
+	require.Equal(t, nil, SaveProfile(store, src, "profile", b.Bytes()))
+
+	metas, err := store.ListProfileMeta("profile_cpu", time.Now().Add(-time.Minute), time.Now().Add(time.Minute))
+	require.Equal(t, nil, err)
+	require.Equal(t, 1, len(metas))
+	require.Equal(t, 1, len(metas[0].ProfileMetas))
+	sampleLabels := metas[0].ProfileMetas[0].SampleLabels
+	require.Less(t, len(sampleLabels), 5)
+	require.Contains(t, sampleLabels, storage.Label{Key: "query", Value: strings.Repeat("a", 300)})
+	require.Contains(t, sampleLabels, storage.Label{Key: "tenant", Value: "acme"})
+	require.Equal(t, true, metas[0].ProfileMetas[0].SampleLabelsTruncated)
+
+	// The values of the recorded labels are recorded along
+	values := metas[0].ProfileMetas[0].SampleLabelValues
+	require.Equal(t, len(sampleLabels), len(values))
+	for i, l := range sampleLabels {
+		require.Equal(t, int64(10), values[i], l.Key)
+	}
+
+	// A label longer than the meta is skipped
+	p.Sample[0].Label["query"] = []string{strings.Repeat("x", 2000)}
+	b.Reset()
+	require.Equal(t, nil, p.Write(b))
+	require.Equal(t, nil, SaveProfile(store, src, "profile", b.Bytes()))
+}
+
+func TestPrepareProfileSampleType(t *testing.T) {
+	fn := &profile.Function{ID: 1, Name: "main.main"}
+	loc := &profile.Location{ID: 1, Line: []profile.Line{{Function: fn}}}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}},
+		Sample:     []*profile.Sample{{Location: []*profile.Location{loc}, Value: []int64{1}}},
+		Function:   []*profile.Function{fn},
+		Location:   []*profile.Location{loc},
+	}
+	b := &bytes.Buffer{}
+	require.Equal(t, nil, p.Write(b))
+
+	// The only sample type of a scraped profile is named after the profile type
+	src := Source{JobName: "job", Host: "127.0.0.1:9000", Expiration: time.Hour}
+	pp, err := PrepareProfile(src, "custom", b.Bytes())
+	require.Equal(t, nil, err)
+	require.Equal(t, "custom", pp.metas[0].SampleType)
+
+	// The one of a pushed profile is named after the profile type only if it is the profile type
+	src.Pushed = true
+	pp, err = PrepareProfile(src, "custom", b.Bytes())
+	require.Equal(t, nil, err)
+	require.Equal(t, "custom_samples", pp.metas[0].SampleType)
+	pp, err = PrepareProfile(src, "samples", b.Bytes())
+	require.Equal(t, nil, err)
+	require.Equal(t, "samples", pp.metas[0].SampleType)
+}
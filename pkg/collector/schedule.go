@@ -0,0 +1,53 @@
+package collector
+
+import (
+	"hash/fnv"
+	"time"
+)
+
+// scrapeOffset The deterministic offset of the target within the interval, hash of the job and host,
+// so the targets are spread over the interval and keep the same offset after a restart
+func scrapeOffset(job, host string, interval time.Duration) time.Duration {
+	h := fnv.New64a()
+	h.Write([]byte(job + "/" + host))
+	return time.Duration(h.Sum64() % uint64(interval))
+}
+
+// nextScrape The first time after now at the offset within an interval aligned to the unix epoch
+func nextScrape(now time.Time, offset, interval time.Duration) time.Time {
+	base := now.Truncate(interval)
+	next := base.Add(offset)
+	if !next.After(now) {
+		next = next.Add(interval)
+	}
+	return next
+}
+
+// scrapeLimiter Limit the number of concurrent scrapes of all collectors, nil means no limit
+type scrapeLimiter chan struct{}
+
+func newScrapeLimiter(n int) scrapeLimiter {
+	if n <= 0 {
+		return nil
+	}
+	return make(scrapeLimiter, n)
+}
+
+// acquire Wait for a free slot, false if exitChan is closed first
+func (l scrapeLimiter) acquire(exitChan <-chan struct{}) bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l <- struct{}{}:
+		return true
+	case <-exitChan:
+		return false
+	}
+}
+
+func (l scrapeLimiter) release() {
+	if l != nil {
+		<-l
+	}
+}
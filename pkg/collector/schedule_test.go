@@ -0,0 +1,71 @@
+package collector
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScrapeOffset(t *testing.T) {
+	interval := time.Minute
+	offsets := make(map[time.Duration]bool)
+	for i := 0; i < 100; i++ {
+		host := fmt.Sprintf("10.0.0.%d:9000", i)
+		offset := scrapeOffset("job", host, interval)
+		require.Equal(t, offset, scrapeOffset("job", host, interval))
+		require.True(t, offset >= 0 && offset < interval)
+		offsets[offset/time.Second] = true
+	}
+	// spread over the interval
+	require.True(t, len(offsets) > 30)
+}
+
+func TestNextScrape(t *testing.T) {
+	interval := time.Minute
+	offset := 20 * time.Second
+	base := time.Date(2022, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	require.Equal(t, base.Add(20*time.Second), nextScrape(base, offset, interval))
+	require.Equal(t, base.Add(20*time.Second), nextScrape(base.Add(19*time.Second), offset, interval))
+	require.Equal(t, base.Add(80*time.Second), nextScrape(base.Add(20*time.Second), offset, interval))
+	require.Equal(t, base.Add(80*time.Second), nextScrape(base.Add(59*time.Second), offset, interval))
+
+	// the same phase after a restart
+	for _, d := range []time.Duration{time.Second, 37 * time.Second, 5 * time.Minute} {
+		next := nextScrape(base.Add(d), offset, interval)
+		require.Equal(t, offset, next.Sub(next.Truncate(interval)))
+		require.True(t, next.After(base.Add(d)) && next.Sub(base.Add(d)) <= interval)
+	}
+}
+
+func TestScrapeLimiter(t *testing.T) {
+	exitChan := make(chan struct{})
+
+	var l scrapeLimiter
+	require.True(t, l.acquire(exitChan))
+	l.release()
+
+	l = newScrapeLimiter(2)
+	require.True(t, l.acquire(exitChan))
+	require.True(t, l.acquire(exitChan))
+
+	acquired := make(chan bool)
+	go func() {
+		acquired <- l.acquire(exitChan)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquired over the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	l.release()
+	require.True(t, <-acquired)
+
+	go func() {
+		acquired <- l.acquire(exitChan)
+	}()
+	close(exitChan)
+	require.False(t, <-acquired)
+}
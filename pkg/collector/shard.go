@@ -0,0 +1,20 @@
+package collector
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+)
+
+// shardOf The shard of the host, hashmod of the host like prometheus
+func shardOf(host string, count int) int {
+	sum := md5.Sum([]byte(host))
+	return int(binary.BigEndian.Uint64(sum[8:]) % uint64(count))
+}
+
+// inShard Whether the host is scraped by the shard index of count shards, all hosts are if count <= 1
+func inShard(host string, index, count int) bool {
+	if count <= 1 {
+		return true
+	}
+	return shardOf(host, count) == index
+}
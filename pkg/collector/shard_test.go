@@ -0,0 +1,56 @@
+package collector
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"cprofiler/pkg/storage/badger"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestShard(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	require.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	store := badger.NewStore(badger.DefaultOptions(dir))
+	defer store.Release()
+
+	// unreachable hosts, scraping fails at once
+	hosts := make([]string, 0, 50)
+	for i := 0; i < 50; i++ {
+		hosts = append(hosts, fmt.Sprintf("127.0.0.1:%d", i+1))
+	}
+	config := CollectorConfig{ScrapeConfigs: []ScrapeConfig{{
+		Job:             "shard",
+		Interval:        time.Minute,
+		EnabledProfiles: []string{"heap"},
+		Targets:         []TargetConfig{{Application: "shard", Hosts: hosts}},
+	}}}
+
+	const count = 3
+	seen := make(map[string]int)
+	for index := 0; index < count; index++ {
+		m := NewManger(DefaultOptions(store).WithShard(index, count).WithRemoteWriteQueuePath(dir))
+		m.Load(config)
+		require.NotEqual(t, 0, len(m.collectors))
+		for host := range m.collectors {
+			require.Equal(t, index, shardOf(host, count))
+			seen[host]++
+		}
+		m.Stop()
+	}
+	require.Equal(t, len(hosts), len(seen))
+	for _, n := range seen {
+		require.Equal(t, 1, n)
+	}
+
+	m := NewManger(DefaultOptions(store).WithRemoteWriteQueuePath(dir))
+	m.Load(config)
+	require.Equal(t, len(hosts), len(m.collectors))
+	m.Stop()
+}
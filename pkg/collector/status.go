@@ -0,0 +1,104 @@
+package collector
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	HealthUnknown = "unknown"
+	HealthUp      = "up"
+	HealthDown    = "down"
+)
+
+// ScrapeStatus The status of the last scrape of a profile type of a target
+type ScrapeStatus struct {
+	Job         string
+	Host        string
+	App         string
+	ProfileType string
+	Health      string
+	// LastScrape The start time of the last scrape, LastDuration includes the retries and saving
+	LastScrape   time.Time
+	LastDuration time.Duration
+	LastError    string
+	// Attempts The number of requests of the last scrape, more than 1 if it was retried
+	Attempts int
+	// Failures The number of consecutive failed scrapes
+	Failures int
+}
+
+// setStatus Record the result of a scrape of the profile type
+func (collector *Collector) setStatus(profileType string, src Source, start time.Time, attempts int, err error) {
+	collector.statusMu.Lock()
+	defer collector.statusMu.Unlock()
+
+	status, ok := collector.status[profileType]
+	if !ok {
+		status = &ScrapeStatus{ProfileType: profileType}
+		collector.status[profileType] = status
+	}
+	status.Job = collector.JobName
+	status.Host = src.Host
+	status.App = src.App
+	status.LastScrape = start
+	status.LastDuration = time.Since(start)
+	status.Attempts = attempts
+	if err != nil {
+		status.Health = HealthDown
+		status.LastError = err.Error()
+		status.Failures++
+		return
+	}
+	status.Health = HealthUp
+	status.LastError = ""
+	status.Failures = 0
+}
+
+// scrapeStatus The status of the enabled profile types, the ones not scraped yet are unknown
+func (collector *Collector) scrapeStatus() []ScrapeStatus {
+	collector.mu.RLock()
+	defer collector.mu.RUnlock()
+	collector.statusMu.Lock()
+	defer collector.statusMu.Unlock()
+
+	res := make([]ScrapeStatus, 0, len(collector.Profiles))
+	for profileType, profileConfig := range collector.Profiles {
+		if !profileConfig.Enable {
+			continue
+		}
+		if status, ok := collector.status[profileType]; ok {
+			res = append(res, *status)
+			continue
+		}
+		res = append(res, ScrapeStatus{
+			Job:         collector.JobName,
+			Host:        collector.Host,
+			App:         collector.Target.Application,
+			ProfileType: profileType,
+			Health:      HealthUnknown,
+		})
+	}
+	return res
+}
+
+// ScrapeStatus The scrape status of all targets, sorted by job, host and profile type
+func (manger *Manger) ScrapeStatus() []ScrapeStatus {
+	manger.mu.Lock()
+	res := make([]ScrapeStatus, 0, len(manger.collectors))
+	for _, collector := range manger.collectors {
+		res = append(res, collector.scrapeStatus()...)
+	}
+	manger.mu.Unlock()
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Job != res[j].Job {
+			return res[i].Job < res[j].Job
+		}
+		if res[i].Host != res[j].Host {
+			return res[i].Host < res[j].Host
+		}
+		return res[i].ProfileType < res[j].ProfileType
+	})
+	return res
+}
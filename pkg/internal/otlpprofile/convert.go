@@ -0,0 +1,254 @@
+package otlpprofile
+
+import (
+	"fmt"
+
+	"github.com/google/pprof/profile"
+)
+
+// Converted is a pprof profile converted from OTLP, with the attributes of its resource and of the profile.
+type Converted struct {
+	Resource   map[string]string
+	Attributes map[string]string
+	Profile    *profile.Profile
+}
+
+// Convert converts all profiles of the request to pprof.
+func Convert(req *ExportRequest) ([]*Converted, error) {
+	res := make([]*Converted, 0)
+	for _, rp := range req.ResourceProfiles {
+		resource := make(map[string]string, len(rp.Attributes))
+		for _, kv := range rp.Attributes {
+			resource[kv.Key] = kv.Value.String()
+		}
+		for _, sp := range rp.ScopeProfiles {
+			for _, p := range sp.Profiles {
+				c := &converter{dict: req.Dictionary}
+				pp, err := c.convert(p)
+				if err != nil {
+					return nil, err
+				}
+				attrs, err := c.attributes(p.AttributeIndices)
+				if err != nil {
+					return nil, err
+				}
+				res = append(res, &Converted{Resource: resource, Attributes: attrs, Profile: pp})
+			}
+		}
+	}
+	return res, nil
+}
+
+// converter converts a profile, the dictionary entries are added to it on demand.
+type converter struct {
+	dict      *Dictionary
+	p         *profile.Profile
+	mappings  map[int32]*profile.Mapping
+	locations map[int32]*profile.Location
+	functions map[int32]*profile.Function
+}
+
+func (c *converter) str(i int32) (string, error) {
+	if i < 0 || int(i) >= len(c.dict.Strings) {
+		return "", fmt.Errorf("string index %d out of range", i)
+	}
+	return c.dict.Strings[i], nil
+}
+
+func (c *converter) valueType(vt *ValueType) (*profile.ValueType, error) {
+	typ, err := c.str(vt.TypeIndex)
+	if err != nil {
+		return nil, err
+	}
+	unit, err := c.str(vt.UnitIndex)
+	if err != nil {
+		return nil, err
+	}
+	return &profile.ValueType{Type: typ, Unit: unit}, nil
+}
+
+func (c *converter) convert(op *Profile) (*profile.Profile, error) {
+	c.p = &profile.Profile{
+		TimeNanos:     op.TimeNanos,
+		DurationNanos: op.DurationNanos,
+		Period:        op.Period,
+	}
+	c.mappings = make(map[int32]*profile.Mapping)
+	c.locations = make(map[int32]*profile.Location)
+	c.functions = make(map[int32]*profile.Function)
+
+	for _, st := range op.SampleTypes {
+		vt, err := c.valueType(st)
+		if err != nil {
+			return nil, err
+		}
+		c.p.SampleType = append(c.p.SampleType, vt)
+	}
+	if len(c.p.SampleType) == 0 {
+		return nil, fmt.Errorf("sample type is nil")
+	}
+	if op.PeriodType != nil {
+		vt, err := c.valueType(op.PeriodType)
+		if err != nil {
+			return nil, err
+		}
+		c.p.PeriodType = vt
+	}
+	if i := int(op.DefaultTypeIndex); i > 0 && i < len(c.p.SampleType) {
+		c.p.DefaultSampleType = c.p.SampleType[i].Type
+	}
+	for _, i := range op.CommentIndices {
+		comment, err := c.str(i)
+		if err != nil {
+			return nil, err
+		}
+		c.p.Comments = append(c.p.Comments, comment)
+	}
+
+	for _, os := range op.Samples {
+		s, err := c.sample(op, os)
+		if err != nil {
+			return nil, err
+		}
+		c.p.Sample = append(c.p.Sample, s)
+	}
+	return c.p, c.p.CheckValid()
+}
+
+func (c *converter) sample(op *Profile, os *Sample) (*profile.Sample, error) {
+	if len(os.Values) != len(c.p.SampleType) {
+		return nil, fmt.Errorf("sample has %d values, want %d", len(os.Values), len(c.p.SampleType))
+	}
+	start, end := int(os.LocationsStart), int(os.LocationsStart)+int(os.LocationsLength)
+	if start < 0 || end > len(op.LocationIndices) || start > end {
+		return nil, fmt.Errorf("sample locations [%d, %d) out of range", start, end)
+	}
+
+	s := &profile.Sample{Value: os.Values}
+	for _, i := range op.LocationIndices[start:end] {
+		loc, err := c.location(i)
+		if err != nil {
+			return nil, err
+		}
+		s.Location = append(s.Location, loc)
+	}
+
+	for _, i := range os.AttributeIndices {
+		if i < 0 || int(i) >= len(c.dict.Attributes) {
+			return nil, fmt.Errorf("attribute index %d out of range", i)
+		}
+		kv := c.dict.Attributes[i]
+		if v, ok := kv.Value.Value.(int64); ok {
+			if s.NumLabel == nil {
+				s.NumLabel = make(map[string][]int64)
+			}
+			s.NumLabel[kv.Key] = append(s.NumLabel[kv.Key], v)
+			continue
+		}
+		if s.Label == nil {
+			s.Label = make(map[string][]string)
+		}
+		s.Label[kv.Key] = append(s.Label[kv.Key], kv.Value.String())
+	}
+	return s, nil
+}
+
+// attributes converts the attributes of the dictionary indices to strings.
+func (c *converter) attributes(indices []int32) (map[string]string, error) {
+	res := make(map[string]string, len(indices))
+	for _, i := range indices {
+		if i < 0 || int(i) >= len(c.dict.Attributes) {
+			return nil, fmt.Errorf("attribute index %d out of range", i)
+		}
+		kv := c.dict.Attributes[i]
+		res[kv.Key] = kv.Value.String()
+	}
+	return res, nil
+}
+
+func (c *converter) location(i int32) (*profile.Location, error) {
+	if loc, ok := c.locations[i]; ok {
+		return loc, nil
+	}
+	if i < 0 || int(i) >= len(c.dict.Locations) {
+		return nil, fmt.Errorf("location index %d out of range", i)
+	}
+	ol := c.dict.Locations[i]
+
+	loc := &profile.Location{
+		ID:       uint64(len(c.p.Location) + 1),
+		Address:  ol.Address,
+		IsFolded: ol.IsFolded,
+	}
+	if ol.MappingIndex >= 0 {
+		m, err := c.mapping(ol.MappingIndex)
+		if err != nil {
+			return nil, err
+		}
+		loc.Mapping = m
+	}
+	for _, line := range ol.Lines {
+		fn, err := c.function(line.FunctionIndex)
+		if err != nil {
+			return nil, err
+		}
+		loc.Line = append(loc.Line, profile.Line{Function: fn, Line: line.Line})
+	}
+	c.locations[i] = loc
+	c.p.Location = append(c.p.Location, loc)
+	return loc, nil
+}
+
+func (c *converter) mapping(i int32) (*profile.Mapping, error) {
+	if m, ok := c.mappings[i]; ok {
+		return m, nil
+	}
+	if int(i) >= len(c.dict.Mappings) {
+		return nil, fmt.Errorf("mapping index %d out of range", i)
+	}
+	om := c.dict.Mappings[i]
+	file, err := c.str(om.FilenameIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &profile.Mapping{
+		ID:              uint64(len(c.p.Mapping) + 1),
+		Start:           om.MemoryStart,
+		Limit:           om.MemoryLimit,
+		Offset:          om.FileOffset,
+		File:            file,
+		HasFunctions:    om.HasFunctions,
+		HasFilenames:    om.HasFilenames,
+		HasLineNumbers:  om.HasLines,
+		HasInlineFrames: om.HasInline,
+	}
+	c.mappings[i] = m
+	c.p.Mapping = append(c.p.Mapping, m)
+	return m, nil
+}
+
+func (c *converter) function(i int32) (*profile.Function, error) {
+	if fn, ok := c.functions[i]; ok {
+		return fn, nil
+	}
+	if i < 0 || int(i) >= len(c.dict.Functions) {
+		return nil, fmt.Errorf("function index %d out of range", i)
+	}
+	of := c.dict.Functions[i]
+
+	fn := &profile.Function{ID: uint64(len(c.p.Function) + 1), StartLine: of.StartLine}
+	var err error
+	if fn.Name, err = c.str(of.NameIndex); err != nil {
+		return nil, err
+	}
+	if fn.SystemName, err = c.str(of.SystemNameIndex); err != nil {
+		return nil, err
+	}
+	if fn.Filename, err = c.str(of.FilenameIndex); err != nil {
+		return nil, err
+	}
+	c.functions[i] = fn
+	c.p.Function = append(c.p.Function, fn)
+	return fn, nil
+}
@@ -0,0 +1,180 @@
+package otlpprofile
+
+import (
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Marshal encodes the request as a protobuf ExportProfilesServiceRequest, the reverse of DecodeExportRequest.
+// It only encodes the decoded fields, the wire format is checked against the fixtures built with the official types.
+func (req *ExportRequest) Marshal() []byte {
+	var b []byte
+	for _, rp := range req.ResourceProfiles {
+		b = appendMessage(b, 1, rp.marshal())
+	}
+	if req.Dictionary != nil {
+		b = appendMessage(b, 2, req.Dictionary.marshal())
+	}
+	return b
+}
+
+func appendMessage(b []byte, num protowire.Number, m []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m)
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendPacked(b []byte, num protowire.Number, vs []uint64) []byte {
+	if len(vs) == 0 {
+		return b
+	}
+	var packed []byte
+	for _, v := range vs {
+		packed = protowire.AppendVarint(packed, v)
+	}
+	return appendMessage(b, num, packed)
+}
+
+func int32s(vs []int32) []uint64 {
+	res := make([]uint64, 0, len(vs))
+	for _, v := range vs {
+		res = append(res, uint64(v))
+	}
+	return res
+}
+
+func boolValue(v bool) uint64 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+func (d *Dictionary) marshal() []byte {
+	var b []byte
+	for _, m := range d.Mappings {
+		var mb []byte
+		mb = appendVarint(mb, 1, m.MemoryStart)
+		mb = appendVarint(mb, 2, m.MemoryLimit)
+		mb = appendVarint(mb, 3, m.FileOffset)
+		mb = appendVarint(mb, 4, uint64(m.FilenameIndex))
+		mb = appendVarint(mb, 6, boolValue(m.HasFunctions))
+		mb = appendVarint(mb, 7, boolValue(m.HasFilenames))
+		mb = appendVarint(mb, 8, boolValue(m.HasLines))
+		mb = appendVarint(mb, 9, boolValue(m.HasInline))
+		b = appendMessage(b, 1, mb)
+	}
+	for _, l := range d.Locations {
+		var lb []byte
+		if l.MappingIndex >= 0 {
+			// the mapping index 0 is written explicitly, its absence means no mapping
+			lb = protowire.AppendTag(lb, 1, protowire.VarintType)
+			lb = protowire.AppendVarint(lb, uint64(l.MappingIndex))
+		}
+		lb = appendVarint(lb, 2, l.Address)
+		for _, line := range l.Lines {
+			var lnb []byte
+			lnb = appendVarint(lnb, 1, uint64(line.FunctionIndex))
+			lnb = appendVarint(lnb, 2, uint64(line.Line))
+			lnb = appendVarint(lnb, 3, uint64(line.Column))
+			lb = appendMessage(lb, 3, lnb)
+		}
+		lb = appendVarint(lb, 4, boolValue(l.IsFolded))
+		b = appendMessage(b, 2, lb)
+	}
+	for _, fn := range d.Functions {
+		var fb []byte
+		fb = appendVarint(fb, 1, uint64(fn.NameIndex))
+		fb = appendVarint(fb, 2, uint64(fn.SystemNameIndex))
+		fb = appendVarint(fb, 3, uint64(fn.FilenameIndex))
+		fb = appendVarint(fb, 4, uint64(fn.StartLine))
+		b = appendMessage(b, 3, fb)
+	}
+	for _, s := range d.Strings {
+		b = appendMessage(b, 5, []byte(s))
+	}
+	for _, kv := range d.Attributes {
+		b = appendMessage(b, 6, kv.marshal())
+	}
+	return b
+}
+
+func (rp *ResourceProfiles) marshal() []byte {
+	var resource []byte
+	for _, kv := range rp.Attributes {
+		resource = appendMessage(resource, 1, kv.marshal())
+	}
+	b := appendMessage(nil, 1, resource)
+	for _, sp := range rp.ScopeProfiles {
+		var sb []byte
+		for _, p := range sp.Profiles {
+			sb = appendMessage(sb, 2, p.marshal())
+		}
+		b = appendMessage(b, 2, sb)
+	}
+	return b
+}
+
+func (p *Profile) marshal() []byte {
+	var b []byte
+	for _, st := range p.SampleTypes {
+		b = appendMessage(b, 1, st.marshal())
+	}
+	for _, s := range p.Samples {
+		var sb []byte
+		sb = appendVarint(sb, 1, uint64(s.LocationsStart))
+		sb = appendVarint(sb, 2, uint64(s.LocationsLength))
+		values := make([]uint64, 0, len(s.Values))
+		for _, v := range s.Values {
+			values = append(values, uint64(v))
+		}
+		sb = appendPacked(sb, 3, values)
+		sb = appendPacked(sb, 4, int32s(s.AttributeIndices))
+		b = appendMessage(b, 2, sb)
+	}
+	b = appendPacked(b, 3, int32s(p.LocationIndices))
+	b = appendVarint(b, 4, uint64(p.TimeNanos))
+	b = appendVarint(b, 5, uint64(p.DurationNanos))
+	if p.PeriodType != nil {
+		b = appendMessage(b, 6, p.PeriodType.marshal())
+	}
+	b = appendVarint(b, 7, uint64(p.Period))
+	b = appendPacked(b, 8, int32s(p.CommentIndices))
+	b = appendVarint(b, 9, uint64(p.DefaultTypeIndex))
+	b = appendPacked(b, 14, int32s(p.AttributeIndices))
+	return b
+}
+
+func (vt *ValueType) marshal() []byte {
+	b := appendVarint(nil, 1, uint64(vt.TypeIndex))
+	return appendVarint(b, 2, uint64(vt.UnitIndex))
+}
+
+func (kv *KeyValue) marshal() []byte {
+	var v []byte
+	switch x := kv.Value.Value.(type) {
+	case string:
+		v = appendMessage(v, 1, []byte(x))
+	case bool:
+		v = protowire.AppendTag(v, 2, protowire.VarintType)
+		v = protowire.AppendVarint(v, boolValue(x))
+	case int64:
+		v = protowire.AppendTag(v, 3, protowire.VarintType)
+		v = protowire.AppendVarint(v, uint64(x))
+	case float64:
+		v = protowire.AppendTag(v, 4, protowire.Fixed64Type)
+		v = protowire.AppendFixed64(v, math.Float64bits(x))
+	case []byte:
+		v = appendMessage(v, 7, x)
+	}
+	b := appendMessage(nil, 1, []byte(kv.Key))
+	return appendMessage(b, 2, v)
+}
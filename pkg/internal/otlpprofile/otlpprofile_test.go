@@ -0,0 +1,105 @@
+package otlpprofile
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRequest() *ExportRequest {
+	return &ExportRequest{
+		ResourceProfiles: []*ResourceProfiles{{
+			Attributes: []*KeyValue{
+				{Key: "service.name", Value: AnyValue{Value: "checkout"}},
+				{Key: "host.name", Value: AnyValue{Value: "host-1"}},
+				{Key: "replica", Value: AnyValue{Value: int64(2)}},
+			},
+			ScopeProfiles: []*ScopeProfiles{{Profiles: []*Profile{{
+				SampleTypes: []*ValueType{{TypeIndex: 1, UnitIndex: 2}, {TypeIndex: 3, UnitIndex: 4}},
+				Samples: []*Sample{
+					{LocationsStart: 0, LocationsLength: 2, Values: []int64{1, 10000000}, AttributeIndices: []int32{0}},
+					{LocationsStart: 2, LocationsLength: 1, Values: []int64{3, 30000000}, AttributeIndices: []int32{1}},
+				},
+				LocationIndices: []int32{1, 0, 0},
+				TimeNanos:       1700000000000000000,
+				DurationNanos:   10000000000,
+				PeriodType:      &ValueType{TypeIndex: 3, UnitIndex: 4},
+				Period:          10000000,
+			}}}},
+		}},
+		Dictionary: &Dictionary{
+			Mappings: []*Mapping{{MemoryStart: 0x1000, MemoryLimit: 0x2000, FilenameIndex: 5, HasFunctions: true}},
+			Locations: []*Location{
+				{MappingIndex: 0, Address: 0x1100, Lines: []*Line{{FunctionIndex: 0, Line: 10}}},
+				{MappingIndex: -1, Address: 0x1200, Lines: []*Line{{FunctionIndex: 1, Line: 20}}},
+			},
+			Functions: []*Function{
+				{NameIndex: 6, SystemNameIndex: 6, FilenameIndex: 8},
+				{NameIndex: 7, SystemNameIndex: 7, FilenameIndex: 8},
+			},
+			Strings: []string{"", "samples", "count", "cpu", "nanoseconds", "/bin/app", "main.main", "main.work", "main.go"},
+			Attributes: []*KeyValue{
+				{Key: "thread.name", Value: AnyValue{Value: "worker"}},
+				{Key: "thread.id", Value: AnyValue{Value: int64(7)}},
+			},
+		},
+	}
+}
+
+// TestDecodeFixture decodes the request of newTestRequest encoded by the official generated types
+// go.opentelemetry.io/proto/otlp v1.7.0, which also sets the fields not decoded such as the scope and the profile id
+func TestDecodeFixture(t *testing.T) {
+	data, err := ioutil.ReadFile("../../apiserver/testdata/otlp_profiles.pb.testdata")
+	require.Equal(t, nil, err)
+	got, err := DecodeExportRequest(data)
+	require.Equal(t, nil, err)
+	require.Equal(t, newTestRequest(), got)
+}
+
+func TestDecodeExportRequest(t *testing.T) {
+	want := newTestRequest()
+	got, err := DecodeExportRequest(want.Marshal())
+	require.Equal(t, nil, err)
+	require.Equal(t, want, got)
+
+	_, err = DecodeExportRequest([]byte{0x0a, 0x05, 0x01})
+	require.NotEqual(t, nil, err)
+}
+
+func TestConvert(t *testing.T) {
+	res, err := Convert(newTestRequest())
+	require.Equal(t, nil, err)
+	require.Equal(t, 1, len(res))
+	require.Equal(t, map[string]string{"service.name": "checkout", "host.name": "host-1", "replica": "2"}, res[0].Resource)
+
+	p := res[0].Profile
+	require.Equal(t, 2, len(p.SampleType))
+	require.Equal(t, "samples", p.SampleType[0].Type)
+	require.Equal(t, "nanoseconds", p.SampleType[1].Unit)
+	require.Equal(t, "cpu", p.PeriodType.Type)
+	require.Equal(t, int64(10000000), p.Period)
+	require.Equal(t, int64(1700000000000000000), p.TimeNanos)
+	require.Equal(t, 1, len(p.Mapping))
+	require.Equal(t, "/bin/app", p.Mapping[0].File)
+	require.Equal(t, 2, len(p.Location))
+	require.Equal(t, 2, len(p.Function))
+
+	require.Equal(t, 2, len(p.Sample))
+	require.Equal(t, "main.work", p.Sample[0].Location[0].Line[0].Function.Name)
+	require.Equal(t, "main.main", p.Sample[0].Location[1].Line[0].Function.Name)
+	require.Equal(t, true, p.Sample[0].Location[0].Mapping == nil)
+	require.Equal(t, []string{"worker"}, p.Sample[0].Label["thread.name"])
+	require.Equal(t, []int64{7}, p.Sample[1].NumLabel["thread.id"])
+	require.Equal(t, []int64{3, 30000000}, p.Sample[1].Value)
+
+	req := newTestRequest()
+	req.ResourceProfiles[0].ScopeProfiles[0].Profiles[0].Samples[0].LocationsLength = 5
+	_, err = Convert(req)
+	require.NotEqual(t, nil, err)
+
+	req = newTestRequest()
+	req.Dictionary.Functions[0].NameIndex = 100
+	_, err = Convert(req)
+	require.NotEqual(t, nil, err)
+}
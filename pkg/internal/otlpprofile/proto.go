@@ -0,0 +1,478 @@
+// Package otlpprofile decodes the OTLP profiles export requests and converts them to pprof.
+//
+// The messages follow opentelemetry-proto v1.7.0 profiles/v1development, only the fields
+// needed to rebuild the pprof profiles are decoded, and the others are skipped.
+package otlpprofile
+
+import (
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// ExportRequest is ExportProfilesServiceRequest.
+type ExportRequest struct {
+	ResourceProfiles []*ResourceProfiles
+	Dictionary       *Dictionary
+}
+
+// Dictionary is ProfilesDictionary, the tables shared by all profiles of the request.
+type Dictionary struct {
+	Mappings   []*Mapping
+	Locations  []*Location
+	Functions  []*Function
+	Strings    []string
+	Attributes []*KeyValue
+}
+
+type ResourceProfiles struct {
+	// Attributes are the resource attributes, such as service.name and host.name.
+	Attributes    []*KeyValue
+	ScopeProfiles []*ScopeProfiles
+}
+
+type ScopeProfiles struct {
+	Profiles []*Profile
+}
+
+type Profile struct {
+	SampleTypes      []*ValueType
+	Samples          []*Sample
+	LocationIndices  []int32
+	TimeNanos        int64
+	DurationNanos    int64
+	PeriodType       *ValueType
+	Period           int64
+	CommentIndices   []int32
+	DefaultTypeIndex int32
+	AttributeIndices []int32
+}
+
+type ValueType struct {
+	TypeIndex int32
+	UnitIndex int32
+}
+
+type Sample struct {
+	LocationsStart   int32
+	LocationsLength  int32
+	Values           []int64
+	AttributeIndices []int32
+}
+
+type Mapping struct {
+	MemoryStart   uint64
+	MemoryLimit   uint64
+	FileOffset    uint64
+	FilenameIndex int32
+	HasFunctions  bool
+	HasFilenames  bool
+	HasLines      bool
+	HasInline     bool
+}
+
+type Location struct {
+	// MappingIndex is -1 if the location has no mapping.
+	MappingIndex int32
+	Address      uint64
+	Lines        []*Line
+	IsFolded     bool
+}
+
+type Line struct {
+	FunctionIndex int32
+	Line          int64
+	Column        int64
+}
+
+type Function struct {
+	NameIndex       int32
+	SystemNameIndex int32
+	FilenameIndex   int32
+	StartLine       int64
+}
+
+type KeyValue struct {
+	Key   string
+	Value AnyValue
+}
+
+// AnyValue keeps the scalar values, an array or a key value list value is kept as nil.
+type AnyValue struct {
+	Value interface{}
+}
+
+// String returns the value formatted as a label value.
+func (v AnyValue) String() string {
+	switch x := v.Value.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case []byte:
+		return fmt.Sprintf("%x", x)
+	default:
+		return fmt.Sprint(x)
+	}
+}
+
+// field is a decoded protobuf field, v is the varint or fixed value, b is the bytes value.
+type field struct {
+	num protowire.Number
+	typ protowire.Type
+	v   uint64
+	b   []byte
+}
+
+// decodeFields calls fn for each field of the message b.
+func decodeFields(b []byte, fn func(f field) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		f := field{num: num, typ: typ}
+		switch typ {
+		case protowire.VarintType:
+			f.v, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			f.v, n = protowire.ConsumeFixed64(b)
+		case protowire.Fixed32Type:
+			var v uint32
+			v, n = protowire.ConsumeFixed32(b)
+			f.v = uint64(v)
+		case protowire.BytesType:
+			f.b, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if err := fn(f); err != nil {
+			return fmt.Errorf("field %d: %w", num, err)
+		}
+	}
+	return nil
+}
+
+// varints returns the values of a repeated integer field, which is either packed or not.
+func varints(f field) ([]uint64, error) {
+	if f.typ == protowire.VarintType {
+		return []uint64{f.v}, nil
+	}
+	if f.typ != protowire.BytesType {
+		return nil, fmt.Errorf("unexpected wire type %d", f.typ)
+	}
+	res := make([]uint64, 0, len(f.b))
+	for b := f.b; len(b) > 0; {
+		v, n := protowire.ConsumeVarint(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		res = append(res, v)
+		b = b[n:]
+	}
+	return res, nil
+}
+
+func appendInt32s(dst []int32, f field) ([]int32, error) {
+	vs, err := varints(f)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range vs {
+		dst = append(dst, int32(v))
+	}
+	return dst, nil
+}
+
+// DecodeExportRequest decodes the protobuf ExportProfilesServiceRequest.
+func DecodeExportRequest(b []byte) (*ExportRequest, error) {
+	req := &ExportRequest{Dictionary: &Dictionary{}}
+	err := decodeFields(b, func(f field) error {
+		switch f.num {
+		case 1:
+			rp, err := decodeResourceProfiles(f.b)
+			if err != nil {
+				return err
+			}
+			req.ResourceProfiles = append(req.ResourceProfiles, rp)
+		case 2:
+			d, err := decodeDictionary(f.b)
+			if err != nil {
+				return err
+			}
+			req.Dictionary = d
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeDictionary(b []byte) (*Dictionary, error) {
+	d := &Dictionary{}
+	err := decodeFields(b, func(f field) error {
+		switch f.num {
+		case 1:
+			m, err := decodeMapping(f.b)
+			if err != nil {
+				return err
+			}
+			d.Mappings = append(d.Mappings, m)
+		case 2:
+			l, err := decodeLocation(f.b)
+			if err != nil {
+				return err
+			}
+			d.Locations = append(d.Locations, l)
+		case 3:
+			fn, err := decodeFunction(f.b)
+			if err != nil {
+				return err
+			}
+			d.Functions = append(d.Functions, fn)
+		case 5:
+			d.Strings = append(d.Strings, string(f.b))
+		case 6:
+			kv, err := decodeKeyValue(f.b)
+			if err != nil {
+				return err
+			}
+			d.Attributes = append(d.Attributes, kv)
+		}
+		return nil
+	})
+	return d, err
+}
+
+func decodeResourceProfiles(b []byte) (*ResourceProfiles, error) {
+	rp := &ResourceProfiles{}
+	err := decodeFields(b, func(f field) error {
+		switch f.num {
+		case 1:
+			// Resource
+			return decodeFields(f.b, func(f field) error {
+				if f.num != 1 {
+					return nil
+				}
+				kv, err := decodeKeyValue(f.b)
+				if err != nil {
+					return err
+				}
+				rp.Attributes = append(rp.Attributes, kv)
+				return nil
+			})
+		case 2:
+			sp := &ScopeProfiles{}
+			err := decodeFields(f.b, func(f field) error {
+				if f.num != 2 {
+					return nil
+				}
+				p, err := decodeProfile(f.b)
+				if err != nil {
+					return err
+				}
+				sp.Profiles = append(sp.Profiles, p)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			rp.ScopeProfiles = append(rp.ScopeProfiles, sp)
+		}
+		return nil
+	})
+	return rp, err
+}
+
+func decodeProfile(b []byte) (*Profile, error) {
+	p := &Profile{}
+	err := decodeFields(b, func(f field) error {
+		var err error
+		switch f.num {
+		case 1:
+			vt, err := decodeValueType(f.b)
+			if err != nil {
+				return err
+			}
+			p.SampleTypes = append(p.SampleTypes, vt)
+		case 2:
+			s, err := decodeSample(f.b)
+			if err != nil {
+				return err
+			}
+			p.Samples = append(p.Samples, s)
+		case 3:
+			p.LocationIndices, err = appendInt32s(p.LocationIndices, f)
+		case 4:
+			p.TimeNanos = int64(f.v)
+		case 5:
+			p.DurationNanos = int64(f.v)
+		case 6:
+			p.PeriodType, err = decodeValueType(f.b)
+		case 7:
+			p.Period = int64(f.v)
+		case 8:
+			p.CommentIndices, err = appendInt32s(p.CommentIndices, f)
+		case 9:
+			p.DefaultTypeIndex = int32(f.v)
+		case 14:
+			p.AttributeIndices, err = appendInt32s(p.AttributeIndices, f)
+		}
+		return err
+	})
+	return p, err
+}
+
+func decodeValueType(b []byte) (*ValueType, error) {
+	vt := &ValueType{}
+	err := decodeFields(b, func(f field) error {
+		switch f.num {
+		case 1:
+			vt.TypeIndex = int32(f.v)
+		case 2:
+			vt.UnitIndex = int32(f.v)
+		}
+		return nil
+	})
+	return vt, err
+}
+
+func decodeSample(b []byte) (*Sample, error) {
+	s := &Sample{}
+	err := decodeFields(b, func(f field) error {
+		var err error
+		switch f.num {
+		case 1:
+			s.LocationsStart = int32(f.v)
+		case 2:
+			s.LocationsLength = int32(f.v)
+		case 3:
+			var vs []uint64
+			if vs, err = varints(f); err != nil {
+				return err
+			}
+			for _, v := range vs {
+				s.Values = append(s.Values, int64(v))
+			}
+		case 4:
+			s.AttributeIndices, err = appendInt32s(s.AttributeIndices, f)
+		}
+		return err
+	})
+	return s, err
+}
+
+func decodeMapping(b []byte) (*Mapping, error) {
+	m := &Mapping{}
+	err := decodeFields(b, func(f field) error {
+		switch f.num {
+		case 1:
+			m.MemoryStart = f.v
+		case 2:
+			m.MemoryLimit = f.v
+		case 3:
+			m.FileOffset = f.v
+		case 4:
+			m.FilenameIndex = int32(f.v)
+		case 6:
+			m.HasFunctions = f.v != 0
+		case 7:
+			m.HasFilenames = f.v != 0
+		case 8:
+			m.HasLines = f.v != 0
+		case 9:
+			m.HasInline = f.v != 0
+		}
+		return nil
+	})
+	return m, err
+}
+
+func decodeLocation(b []byte) (*Location, error) {
+	l := &Location{MappingIndex: -1}
+	err := decodeFields(b, func(f field) error {
+		switch f.num {
+		case 1:
+			l.MappingIndex = int32(f.v)
+		case 2:
+			l.Address = f.v
+		case 3:
+			line := &Line{}
+			err := decodeFields(f.b, func(f field) error {
+				switch f.num {
+				case 1:
+					line.FunctionIndex = int32(f.v)
+				case 2:
+					line.Line = int64(f.v)
+				case 3:
+					line.Column = int64(f.v)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			l.Lines = append(l.Lines, line)
+		case 4:
+			l.IsFolded = f.v != 0
+		}
+		return nil
+	})
+	return l, err
+}
+
+func decodeFunction(b []byte) (*Function, error) {
+	fn := &Function{}
+	err := decodeFields(b, func(f field) error {
+		switch f.num {
+		case 1:
+			fn.NameIndex = int32(f.v)
+		case 2:
+			fn.SystemNameIndex = int32(f.v)
+		case 3:
+			fn.FilenameIndex = int32(f.v)
+		case 4:
+			fn.StartLine = int64(f.v)
+		}
+		return nil
+	})
+	return fn, err
+}
+
+func decodeKeyValue(b []byte) (*KeyValue, error) {
+	kv := &KeyValue{}
+	err := decodeFields(b, func(f field) error {
+		switch f.num {
+		case 1:
+			kv.Key = string(f.b)
+		case 2:
+			return decodeFields(f.b, func(f field) error {
+				switch f.num {
+				case 1:
+					kv.Value.Value = string(f.b)
+				case 2:
+					kv.Value.Value = f.v != 0
+				case 3:
+					kv.Value.Value = int64(f.v)
+				case 4:
+					kv.Value.Value = math.Float64frombits(f.v)
+				case 7:
+					kv.Value.Value = f.b
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return kv, err
+}
@@ -0,0 +1,103 @@
+// Package pprofdriver runs the pprof driver in-process, serving the pprof web UI
+// or rendering reports without a Go toolchain on the server.
+package pprofdriver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/google/pprof/driver"
+	"github.com/google/pprof/profile"
+)
+
+// Web runs pprof on source and passes its web UI handlers to serve,
+// the source is fetched by fetcher if it is not nil
+func Web(source string, fetcher driver.Fetcher, serve func(args *driver.HTTPServerArgs) error) error {
+	options := &driver.Options{
+		Flagset:    &flags{args: []string{"-http=localhost:0", "-no_browser", source}},
+		Fetch:      fetcher,
+		HTTPServer: serve,
+	}
+	return driver.PProf(options)
+}
+
+// FlameGraph serves the pprof web UI flame graph page of p for r, the si parameter selects the sample type
+func FlameGraph(w http.ResponseWriter, r *http.Request, p *profile.Profile) error {
+	return Web("profile", &ProfileFetcher{Profile: p}, func(args *driver.HTTPServerArgs) error {
+		handler, ok := args.Handlers["/flamegraph"]
+		if !ok {
+			return errors.New("pprof web UI has no flame graph")
+		}
+		handler.ServeHTTP(w, r)
+		return nil
+	})
+}
+
+// Render writes the report of p in format, which is one of the pprof output flags
+// such as svg, dot, top or traces. svg requires graphviz.
+func Render(w io.Writer, p *profile.Profile, format string) error {
+	const output = "report"
+	writer := &memWriter{}
+	options := &driver.Options{
+		Flagset: &flags{args: []string{"-" + format, "-output", output, "profile"}},
+		Fetch:   &ProfileFetcher{Profile: p},
+		Writer:  writer,
+		UI:      quietUI{},
+	}
+	if err := driver.PProf(options); err != nil {
+		return err
+	}
+	buf, ok := writer.files[output]
+	if !ok {
+		return fmt.Errorf("failed to render %s report", format)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// ProfileFetcher fetches an in-memory profile whatever the source is.
+// The returned source is empty, or pprof would save a copy of the profile in $HOME/pprof as a remote one
+type ProfileFetcher struct {
+	Profile *profile.Profile
+}
+
+func (f *ProfileFetcher) Fetch(src string, duration, timeout time.Duration) (*profile.Profile, string, error) {
+	if f.Profile == nil {
+		return nil, "", errors.New("profile is nil")
+	}
+	return f.Profile.Copy(), "", nil
+}
+
+// memWriter keeps the driver output files in memory
+type memWriter struct {
+	files map[string]*bytes.Buffer
+}
+
+func (m *memWriter) Open(name string) (io.WriteCloser, error) {
+	if m.files == nil {
+		m.files = make(map[string]*bytes.Buffer)
+	}
+	buf := &bytes.Buffer{}
+	m.files[name] = buf
+	return nopCloser{buf}, nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// quietUI is a non-interactive driver UI, the errors are returned by driver.PProf
+type quietUI struct{}
+
+func (quietUI) ReadLine(prompt string) (string, error) { return "", io.EOF }
+func (quietUI) Print(args ...interface{})              {}
+func (quietUI) PrintErr(args ...interface{})           {}
+func (quietUI) IsTerminal() bool                       { return false }
+func (quietUI) WantBrowser() bool                      { return false }
+func (quietUI) SetAutoComplete(func(string) string)    {}
@@ -0,0 +1,56 @@
+package pprofdriver
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+)
+
+func readTestProfile(t *testing.T) *profile.Profile {
+	f, err := os.Open("../../apiserver/testdata/profile.out.testdata")
+	require.Equal(t, nil, err)
+	defer f.Close()
+	p, err := profile.Parse(f)
+	require.Equal(t, nil, err)
+	return p
+}
+
+func TestRender(t *testing.T) {
+	p := readTestProfile(t)
+
+	var top bytes.Buffer
+	require.Equal(t, nil, Render(&top, p, "top"))
+	require.Contains(t, top.String(), "flat%")
+
+	var dot bytes.Buffer
+	require.Equal(t, nil, Render(&dot, p, "dot"))
+	require.Contains(t, dot.String(), "digraph")
+
+	// The profile is copied, so rendering doesn't change it
+	require.Equal(t, nil, p.CheckValid())
+}
+
+func TestFlameGraph(t *testing.T) {
+	p := readTestProfile(t)
+
+	w := httptest.NewRecorder()
+	require.Equal(t, nil, FlameGraph(w, httptest.NewRequest(http.MethodGet, "/flamegraph?si=alloc_space", nil), p))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), "alloc_space")
+}
+
+func TestRenderNoSavedProfile(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PPROF_TMPDIR", dir)
+
+	var top bytes.Buffer
+	require.Equal(t, nil, Render(&top, readTestProfile(t), "top"))
+	files, err := os.ReadDir(dir)
+	require.Equal(t, nil, err)
+	require.Equal(t, 0, len(files))
+}
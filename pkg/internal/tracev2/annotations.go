@@ -0,0 +1,504 @@
+package tracev2
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"cprofiler/pkg/internal/v1175/traceui"
+
+	"golang.org/x/exp/trace"
+)
+
+// taskDesc represents a user task.
+type taskDesc struct {
+	typ        string
+	start, end int64
+}
+
+// complete reports whether both the begin and end events of the task are in the trace.
+func (task *taskDesc) complete() bool {
+	return task.start != 0 && task.end != 0
+}
+
+// regionKey groups user regions by type and the frame starting the region.
+type regionKey struct {
+	typ   string
+	frame trace.StackFrame
+}
+
+// regionDesc represents a user region on a goroutine.
+type regionDesc struct {
+	goid     trace.GoID
+	start    int64
+	duration time.Duration
+}
+
+// openRegion is a user region which is not ended yet.
+type openRegion struct {
+	key   regionKey
+	start int64
+}
+
+// annotationAnalysisResult is the user tasks and regions of the trace.
+type annotationAnalysisResult struct {
+	tasks   map[trace.TaskID]*taskDesc
+	regions map[regionKey][]regionDesc
+}
+
+// analyzeAnnotations analyzes user task and region events, regions not ended
+// in the trace last until the end of the trace.
+func analyzeAnnotations(events []trace.Event) annotationAnalysisResult {
+	res := annotationAnalysisResult{
+		tasks:   make(map[trace.TaskID]*taskDesc),
+		regions: make(map[regionKey][]regionDesc),
+	}
+	if len(events) == 0 {
+		return res
+	}
+	firstTs := int64(events[0].Time())
+	lastTs := int64(events[len(events)-1].Time())
+
+	open := make(map[trace.GoID][]openRegion)
+	for _, ev := range events {
+		ts := int64(ev.Time())
+		switch ev.Kind() {
+		case trace.EventTaskBegin, trace.EventTaskEnd:
+			t := ev.Task()
+			task, ok := res.tasks[t.ID]
+			if !ok {
+				task = &taskDesc{}
+				res.tasks[t.ID] = task
+			}
+			if task.typ == "" {
+				task.typ = t.Type
+			}
+			if ev.Kind() == trace.EventTaskBegin {
+				task.start = ts
+			} else {
+				task.end = ts
+			}
+		case trace.EventRegionBegin:
+			key := regionKey{typ: ev.Region().Type}
+			for frame := range ev.Stack().Frames() {
+				key.frame = frame
+				break
+			}
+			open[ev.Goroutine()] = append(open[ev.Goroutine()], openRegion{key: key, start: ts})
+		case trace.EventRegionEnd:
+			typ := ev.Region().Type
+			stack := open[ev.Goroutine()]
+			found := false
+			for i := len(stack) - 1; i >= 0; i-- {
+				if stack[i].key.typ != typ {
+					continue
+				}
+				res.regions[stack[i].key] = append(res.regions[stack[i].key], regionDesc{
+					goid:     ev.Goroutine(),
+					start:    stack[i].start,
+					duration: time.Duration(ts - stack[i].start),
+				})
+				open[ev.Goroutine()] = append(stack[:i], stack[i+1:]...)
+				found = true
+				break
+			}
+			if !found {
+				// The region began before the trace start.
+				key := regionKey{typ: typ}
+				res.regions[key] = append(res.regions[key], regionDesc{goid: ev.Goroutine(), start: firstTs, duration: time.Duration(ts - firstTs)})
+			}
+		}
+	}
+	for goid, stack := range open {
+		for _, region := range stack {
+			res.regions[region.key] = append(res.regions[region.key], regionDesc{goid: goid, start: region.start, duration: time.Duration(lastTs - region.start)})
+		}
+	}
+	return res
+}
+
+func (traceUI *TraceUI) analyzeAnnotations() annotationAnalysisResult {
+	traceUI.annotationsInit.Do(func() {
+		traceUI.annotations = analyzeAnnotations(traceUI.res.Events)
+	})
+	return traceUI.annotations
+}
+
+// httpUserTasksJSON serves the duration stats of user tasks by type as JSON.
+func (traceUI *TraceUI) httpUserTasksJSON(w http.ResponseWriter, r *http.Request) {
+	counts := make(map[string]int)
+	durations := make(map[string][]time.Duration)
+	for _, task := range traceUI.analyzeAnnotations().tasks {
+		counts[task.typ]++
+		if task.complete() {
+			durations[task.typ] = append(durations[task.typ], time.Duration(task.end-task.start))
+		}
+	}
+
+	tasks := make([]traceui.TaskTypeStats, 0, len(counts))
+	for typ, count := range counts {
+		tasks = append(tasks, traceui.TaskTypeStats{
+			Type:      typ,
+			Count:     count,
+			Durations: traceui.NewDurationStats(durations[typ]),
+		})
+	}
+	traceui.SortTaskTypeStats(tasks)
+	traceui.WriteJSON(w, tasks)
+}
+
+// httpUserRegionsJSON serves the duration stats of user regions by type as JSON.
+func (traceUI *TraceUI) httpUserRegionsJSON(w http.ResponseWriter, r *http.Request) {
+	res := traceUI.analyzeAnnotations()
+	regions := make([]traceui.RegionTypeStats, 0, len(res.regions))
+	for key, descs := range res.regions {
+		regions = append(regions, traceui.RegionTypeStats{
+			Type:      key.typ,
+			PC:        key.frame.PC,
+			Function:  key.frame.Func,
+			File:      key.frame.File,
+			Line:      int(key.frame.Line),
+			Durations: traceui.NewDurationStats(regionDurations(descs)),
+		})
+	}
+	traceui.SortRegionTypeStats(regions)
+	traceui.WriteJSON(w, regions)
+}
+
+// regionDurations returns the durations of the regions.
+func regionDurations(regions []regionDesc) []time.Duration {
+	ds := make([]time.Duration, 0, len(regions))
+	for _, region := range regions {
+		ds = append(ds, region.duration)
+	}
+	return ds
+}
+
+// durationFilter matches the durations in [latmin, latmax) of the user task and region pages,
+// a missing bound is not checked.
+type durationFilter struct {
+	min, max time.Duration
+}
+
+func newDurationFilter(r *http.Request) (durationFilter, error) {
+	f := durationFilter{max: math.MaxInt64}
+	for _, p := range []struct {
+		name string
+		d    *time.Duration
+	}{{"latmin", &f.min}, {"latmax", &f.max}} {
+		if s := r.FormValue(p.name); s != "" {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return f, fmt.Errorf("failed to parse %s parameter %q: %v", p.name, s, err)
+			}
+			*p.d = d
+		}
+	}
+	return f, nil
+}
+
+func (f durationFilter) match(d time.Duration) bool {
+	return d >= f.min && d < f.max
+}
+
+// userTaskType is a row of the user tasks page.
+type userTaskType struct {
+	Type      string
+	Count     int
+	Durations []time.Duration // Complete tasks only
+}
+
+// BucketURL returns the url maker of the complete tasks of the type with durations in [min, max).
+func (t userTaskType) BucketURL() func(min, max time.Duration) string {
+	return func(min, max time.Duration) string {
+		return fmt.Sprintf("usertask?type=%s&complete=true&latmin=%s&latmax=%s", url.QueryEscape(t.Type), url.QueryEscape(min.String()), url.QueryEscape(max.String()))
+	}
+}
+
+// httpUserTasks serves the user task types with the histograms of the complete task durations.
+func (traceUI *TraceUI) httpUserTasks(w http.ResponseWriter, r *http.Request) {
+	summary := make(map[string]*userTaskType)
+	for _, task := range traceUI.analyzeAnnotations().tasks {
+		t, ok := summary[task.typ]
+		if !ok {
+			t = &userTaskType{Type: task.typ}
+			summary[task.typ] = t
+		}
+		t.Count++
+		if task.complete() {
+			t.Durations = append(t.Durations, time.Duration(task.end-task.start))
+		}
+	}
+	types := make([]*userTaskType, 0, len(summary))
+	for _, t := range summary {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i].Type < types[j].Type })
+
+	w.Header().Set("Content-Type", "text/html;charset=utf-8")
+	if err := templUserTaskTypes.Execute(w, types); err != nil {
+		http.Error(w, fmt.Sprintf("failed to execute template: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+// userTask is a row of the user task page, times are relative to the trace start.
+type userTask struct {
+	ID       trace.TaskID
+	Start    time.Duration
+	Duration time.Duration // Zero if the task is incomplete
+	URL      template.URL  // html/template rejects the ":" of the time selection in a string
+}
+
+// httpUserTask serves the tasks of the type parameter, complete=true and latmin, latmax select
+// the complete tasks by duration.
+func (traceUI *TraceUI) httpUserTask(w http.ResponseWriter, r *http.Request) {
+	filter, err := newDurationFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	typ := r.FormValue("type")
+	complete := r.FormValue("complete") == "true"
+	firstTs, lastTs := traceUI.timeRange()
+
+	var tasks []userTask
+	for id, task := range traceUI.analyzeAnnotations().tasks {
+		if task.typ != typ || (complete && !task.complete()) {
+			continue
+		}
+		start, end := task.start, task.end
+		if start == 0 {
+			start = firstTs
+		}
+		if end == 0 {
+			end = lastTs
+		}
+		t := userTask{ID: id, Start: time.Duration(start - firstTs), URL: template.URL(traceui.RangeURL(traceUI.ranges, start-firstTs, end-firstTs))}
+		if task.complete() {
+			t.Duration = time.Duration(task.end - task.start)
+		}
+		if complete && !filter.match(t.Duration) {
+			continue
+		}
+		tasks = append(tasks, t)
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].Start < tasks[j].Start })
+
+	w.Header().Set("Content-Type", "text/html;charset=utf-8")
+	err = templUserTaskType.Execute(w, struct {
+		Type  string
+		Tasks []userTask
+	}{
+		Type:  typ,
+		Tasks: tasks,
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to execute template: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+// userRegionType is a row of the user regions page.
+type userRegionType struct {
+	Type      string
+	Frame     trace.StackFrame
+	Durations []time.Duration
+}
+
+// BucketURL returns the url maker of the regions of the type with durations in [min, max).
+func (t userRegionType) BucketURL() func(min, max time.Duration) string {
+	return func(min, max time.Duration) string {
+		return fmt.Sprintf("userregion?type=%s&pc=%x&latmin=%s&latmax=%s", url.QueryEscape(t.Type), t.Frame.PC, url.QueryEscape(min.String()), url.QueryEscape(max.String()))
+	}
+}
+
+// httpUserRegions serves the user region types with the histograms of the region durations.
+func (traceUI *TraceUI) httpUserRegions(w http.ResponseWriter, r *http.Request) {
+	res := traceUI.analyzeAnnotations()
+	types := make([]userRegionType, 0, len(res.regions))
+	for key, regions := range res.regions {
+		types = append(types, userRegionType{Type: key.typ, Frame: key.frame, Durations: regionDurations(regions)})
+	}
+	sort.Slice(types, func(i, j int) bool {
+		if types[i].Type != types[j].Type {
+			return types[i].Type < types[j].Type
+		}
+		return types[i].Frame.PC < types[j].Frame.PC
+	})
+
+	w.Header().Set("Content-Type", "text/html;charset=utf-8")
+	if err := templUserRegionTypes.Execute(w, types); err != nil {
+		http.Error(w, fmt.Sprintf("failed to execute template: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+// userRegion is a row of the user region page, times are relative to the trace start.
+type userRegion struct {
+	Goroutine trace.GoID
+	Start     time.Duration
+	Duration  time.Duration
+	URL       template.URL
+}
+
+// httpUserRegion serves the regions of the type and pc parameters, latmin and latmax select
+// the regions by duration.
+func (traceUI *TraceUI) httpUserRegion(w http.ResponseWriter, r *http.Request) {
+	filter, err := newDurationFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	typ := r.FormValue("type")
+	pc, err := strconv.ParseUint(r.FormValue("pc"), 16, 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to parse pc parameter %q: %v", r.FormValue("pc"), err), http.StatusBadRequest)
+		return
+	}
+	firstTs, _ := traceUI.timeRange()
+
+	var regions []userRegion
+	for key, descs := range traceUI.analyzeAnnotations().regions {
+		if key.typ != typ || key.frame.PC != pc {
+			continue
+		}
+		for _, desc := range descs {
+			if !filter.match(desc.duration) {
+				continue
+			}
+			start := desc.start - firstTs
+			regions = append(regions, userRegion{
+				Goroutine: desc.goid,
+				Start:     time.Duration(start),
+				Duration:  desc.duration,
+				URL:       template.URL(traceui.RangeURL(traceUI.ranges, start, start+int64(desc.duration))),
+			})
+		}
+	}
+	sort.Slice(regions, func(i, j int) bool { return regions[i].Duration > regions[j].Duration })
+
+	w.Header().Set("Content-Type", "text/html;charset=utf-8")
+	err = templUserRegionType.Execute(w, struct {
+		Type    string
+		Regions []userRegion
+	}{
+		Type:    typ,
+		Regions: regions,
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to execute template: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+var templAnnotationFuncs = template.FuncMap{
+	"histogram": traceui.HistogramHTML,
+}
+
+var templUserTaskTypes = template.Must(template.New("").Funcs(templAnnotationFuncs).Parse(`
+<html>
+<style type="text/css">
+.histoTime {
+   width: 20%;
+   white-space:nowrap;
+}
+</style>
+<body>
+<table border="1">
+<tr>
+<th>Task type</th>
+<th>Count</th>
+<th>Duration distribution (complete tasks)</th>
+</tr>
+{{range $}}
+  <tr>
+    <td>{{.Type}}</td>
+    <td><a href="usertask?type={{.Type}}">{{.Count}}</a></td>
+    <td>{{histogram .Durations .BucketURL}}</td>
+  </tr>
+{{end}}
+</table>
+</body>
+</html>
+`))
+
+var templUserTaskType = template.Must(template.New("").Parse(`
+<html>
+<body>
+<h2>User Task: {{.Type}}</h2>
+<table border="1">
+<tr>
+<th>Task</th>
+<th>Start</th>
+<th>Duration</th>
+<th></th>
+</tr>
+{{range .Tasks}}
+  <tr>
+    <td>{{.ID}}</td>
+    <td>{{.Start}}</td>
+    <td>{{if .Duration}}{{.Duration}}{{else}}incomplete{{end}}</td>
+    <td><a href="{{.URL}}">trace</a></td>
+  </tr>
+{{end}}
+</table>
+</body>
+</html>
+`))
+
+var templUserRegionTypes = template.Must(template.New("").Funcs(templAnnotationFuncs).Parse(`
+<html>
+<style type="text/css">
+.histoTime {
+   width: 20%;
+   white-space:nowrap;
+}
+</style>
+<body>
+<table border="1">
+<tr>
+<th>Region type</th>
+<th>Count</th>
+<th>Duration distribution</th>
+</tr>
+{{range $}}
+  <tr>
+    <td>{{.Type}}<br>{{.Frame.Func}}<br>{{.Frame.File}}:{{.Frame.Line}}</td>
+    <td><a href="userregion?type={{.Type}}&pc={{.Frame.PC | printf "%x"}}">{{len .Durations}}</a></td>
+    <td>{{histogram .Durations .BucketURL}}</td>
+  </tr>
+{{end}}
+</table>
+</body>
+</html>
+`))
+
+var templUserRegionType = template.Must(template.New("").Parse(`
+<html>
+<body>
+<h2>User Region: {{.Type}}</h2>
+<table border="1">
+<tr>
+<th>Goroutine</th>
+<th>Start</th>
+<th>Duration</th>
+<th></th>
+</tr>
+{{range .Regions}}
+  <tr>
+    <td>{{.Goroutine}}</td>
+    <td>{{.Start}}</td>
+    <td>{{.Duration}}</td>
+    <td><a href="{{.URL}}">trace</a></td>
+  </tr>
+{{end}}
+</table>
+</body>
+</html>
+`))
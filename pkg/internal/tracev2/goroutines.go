@@ -0,0 +1,148 @@
+package tracev2
+
+import (
+	v1trace "cprofiler/pkg/internal/v1175/trace"
+
+	"golang.org/x/exp/trace"
+)
+
+// GDesc contains statistics and execution details of a single goroutine.
+type GDesc struct {
+	ID           uint64
+	Name         string
+	PC           uint64
+	CreationTime int64
+	StartTime    int64
+	EndTime      int64
+
+	// Statistics of execution time during the goroutine execution.
+	v1trace.GExecutionStat
+
+	*gdesc // private part.
+}
+
+// gdesc is a private part of GDesc that is required only during analysis.
+type gdesc struct {
+	state        trace.GoState
+	lastStateTs  int64
+	blockReason  string
+	firstTs      int64
+	assistStart  int64
+	sweepStart   int64
+	hasStartTime bool
+}
+
+// GoroutineStats generates statistics for all goroutines in the trace,
+// the result has the same shape as v1175 trace.GoroutineStats.
+func GoroutineStats(events []trace.Event) map[uint64]*GDesc {
+	gs := make(map[uint64]*GDesc)
+	if len(events) == 0 {
+		return gs
+	}
+	lastTs := int64(events[len(events)-1].Time())
+
+	getG := func(id trace.GoID, ts int64) *GDesc {
+		g := gs[uint64(id)]
+		if g == nil {
+			g = &GDesc{ID: uint64(id), gdesc: &gdesc{firstTs: ts, lastStateTs: ts}}
+			gs[uint64(id)] = g
+		}
+		return g
+	}
+
+	for _, ev := range events {
+		ts := int64(ev.Time())
+		switch ev.Kind() {
+		case trace.EventStateTransition:
+			st := ev.StateTransition()
+			if st.Resource.Kind != trace.ResourceGoroutine {
+				continue
+			}
+			from, to := st.Goroutine()
+			g := getG(st.Resource.Goroutine(), ts)
+			if from == trace.GoNotExist {
+				g.CreationTime = ts
+			}
+			if g.Name == "" {
+				// The root frame of the goroutine stack is its start function.
+				stk := st.Stack
+				if stk == trace.NoStack && from.Executing() {
+					stk = ev.Stack()
+				}
+				for frame := range stk.Frames() {
+					g.PC = frame.PC
+					g.Name = frame.Func
+				}
+			}
+
+			g.account(ts)
+			g.state = to
+			if to == trace.GoWaiting {
+				g.blockReason = st.Reason
+			}
+			if to == trace.GoRunning && !g.hasStartTime {
+				g.StartTime = ts
+				g.hasStartTime = true
+			}
+			if to == trace.GoNotExist {
+				g.EndTime = ts
+			}
+		case trace.EventRangeBegin, trace.EventRangeActive, trace.EventRangeEnd:
+			r := ev.Range()
+			if r.Scope.Kind != trace.ResourceGoroutine {
+				continue
+			}
+			g := getG(r.Scope.Goroutine(), ts)
+			begin := ev.Kind() != trace.EventRangeEnd
+			switch r.Name {
+			case "GC mark assist":
+				if begin {
+					g.assistStart = ts
+				} else {
+					g.GCTime += ts - g.assistStart
+				}
+			case "GC incremental sweep":
+				if begin {
+					g.sweepStart = ts
+				} else {
+					g.SweepTime += ts - g.sweepStart
+				}
+			}
+		}
+	}
+
+	for _, g := range gs {
+		end := g.EndTime
+		if g.state != trace.GoNotExist {
+			g.account(lastTs)
+			end = lastTs
+		}
+		start := g.CreationTime
+		if start == 0 {
+			start = g.firstTs
+		}
+		g.TotalTime = end - start
+		g.gdesc = nil
+	}
+	return gs
+}
+
+// account adds the time spent in the current state until ts.
+func (g *GDesc) account(ts int64) {
+	d := ts - g.lastStateTs
+	switch g.state {
+	case trace.GoRunning:
+		g.ExecTime += d
+	case trace.GoRunnable:
+		g.SchedWaitTime += d
+	case trace.GoWaiting:
+		if g.blockReason == "network" {
+			g.IOTime += d
+		} else {
+			g.BlockTime += d
+		}
+	case trace.GoSyscall:
+		g.SyscallTime += d
+	}
+	g.lastStateTs = ts
+}
@@ -0,0 +1,242 @@
+package tracev2
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"cprofiler/pkg/internal/v1175/traceui"
+	"cprofiler/pkg/internal/v1175/traceviewer"
+
+	"golang.org/x/exp/trace"
+)
+
+const (
+	procsPID = iota
+	statsPID
+)
+
+const (
+	gcTID = iota
+	procTIDBase
+)
+
+// goSlice is a running goroutine slice on a P.
+type goSlice struct {
+	start int64
+	proc  trace.ProcID
+}
+
+// ExportJSON writes the whole trace in the Chrome Trace Event JSON format,
+// which can be opened in ui.perfetto.dev or chrome://tracing.
+func ExportJSON(w io.Writer, data []byte) error {
+	res, err := Parse(data)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(generateTrace(res.Events))
+}
+
+// generateTrace converts the events to the Chrome trace viewer format, with one row per P
+// showing the running goroutines, a GC row, and goroutine and heap counters.
+// The metadata events come first and the others are sorted by time, so the trace can be split by index.
+func generateTrace(events []trace.Event) *traceviewer.Data {
+	data := &traceviewer.Data{
+		Events:   make([]*traceviewer.Event, 0),
+		Frames:   make(map[string]traceviewer.Frame),
+		TimeUnit: "ns",
+	}
+	if len(events) == 0 {
+		return data
+	}
+	startTs := int64(events[0].Time())
+	lastTs := int64(events[len(events)-1].Time())
+	us := func(ts int64) float64 {
+		return float64(ts-startTs) / 1e3
+	}
+
+	emit := func(e *traceviewer.Event) {
+		data.Events = append(data.Events, e)
+	}
+	emit(&traceviewer.Event{Name: "process_name", Phase: "M", PID: procsPID, Arg: map[string]string{"name": "PROCS"}})
+	emit(&traceviewer.Event{Name: "process_sort_index", Phase: "M", PID: procsPID, Arg: map[string]int{"sort_index": 1}})
+	emit(&traceviewer.Event{Name: "process_name", Phase: "M", PID: statsPID, Arg: map[string]string{"name": "STATS"}})
+	emit(&traceviewer.Event{Name: "process_sort_index", Phase: "M", PID: statsPID, Arg: map[string]int{"sort_index": 0}})
+	emit(&traceviewer.Event{Name: "thread_name", Phase: "M", PID: procsPID, TID: gcTID, Arg: map[string]string{"name": "GC"}})
+
+	names := make(map[trace.GoID]string)
+	running := make(map[trace.GoID]goSlice)
+	procs := make(map[trace.ProcID]bool)
+	ranges := make(map[rangeKey]int64)
+	var goroutines int64
+	heap := make(map[string]uint64)
+
+	endSlice := func(id trace.GoID, ts int64) {
+		s, ok := running[id]
+		if !ok {
+			return
+		}
+		delete(running, id)
+		name := fmt.Sprintf("G%d", id)
+		if n := names[id]; n != "" {
+			name += " " + n
+		}
+		emit(&traceviewer.Event{
+			Name:  name,
+			Phase: "X",
+			Time:  us(s.start),
+			Dur:   float64(ts-s.start) / 1e3,
+			PID:   procsPID,
+			TID:   procTIDBase + uint64(s.proc),
+			Arg:   map[string]uint64{"goid": uint64(id)},
+		})
+	}
+
+	for _, ev := range events {
+		ts := int64(ev.Time())
+		switch ev.Kind() {
+		case trace.EventStateTransition:
+			st := ev.StateTransition()
+			if st.Resource.Kind != trace.ResourceGoroutine {
+				continue
+			}
+			id := st.Resource.Goroutine()
+			from, to := st.Goroutine()
+			if _, ok := names[id]; !ok {
+				for frame := range st.Stack.Frames() {
+					names[id] = frame.Func
+				}
+			}
+			if from == trace.GoRunning {
+				endSlice(id, ts)
+			}
+			if to == trace.GoRunning && ev.Proc() != trace.NoProc {
+				running[id] = goSlice{start: ts, proc: ev.Proc()}
+				procs[ev.Proc()] = true
+			}
+
+			alive := func(s trace.GoState) bool {
+				return s != trace.GoNotExist && s != trace.GoUndetermined
+			}
+			if alive(from) != alive(to) {
+				if alive(to) {
+					goroutines++
+				} else {
+					goroutines--
+				}
+				emit(&traceviewer.Event{Name: "Goroutines", Phase: "C", Time: us(ts), PID: statsPID, Arg: map[string]int64{"Goroutines": goroutines}})
+			}
+		case trace.EventRangeBegin, trace.EventRangeActive:
+			r := ev.Range()
+			ranges[rangeKey{name: r.Name, scope: r.Scope}] = ts
+		case trace.EventRangeEnd:
+			r := ev.Range()
+			key := rangeKey{name: r.Name, scope: r.Scope}
+			start, ok := ranges[key]
+			if !ok {
+				start = startTs
+			}
+			delete(ranges, key)
+			if r.Name != "GC concurrent mark phase" && !strings.HasPrefix(r.Name, "stop-the-world") {
+				continue
+			}
+			emit(&traceviewer.Event{Name: r.Name, Phase: "X", Time: us(start), Dur: float64(ts-start) / 1e3, PID: procsPID, TID: gcTID})
+		case trace.EventMetric:
+			m := ev.Metric()
+			var name string
+			switch m.Name {
+			case "/memory/classes/heap/objects:bytes":
+				name = "Allocated"
+			case "/gc/heap/goal:bytes":
+				name = "NextGC"
+			default:
+				continue
+			}
+			heap[name] = m.Value.Uint64()
+			arg := make(map[string]uint64, len(heap))
+			for k, v := range heap {
+				arg[k] = v
+			}
+			emit(&traceviewer.Event{Name: "Heap", Phase: "C", Time: us(ts), PID: statsPID, Arg: arg})
+		}
+	}
+
+	for id := range running {
+		endSlice(id, lastTs)
+	}
+	for p := range procs {
+		emit(&traceviewer.Event{Name: "thread_name", Phase: "M", PID: procsPID, TID: procTIDBase + uint64(p), Arg: map[string]string{"name": fmt.Sprintf("Proc %d", p)}})
+		emit(&traceviewer.Event{Name: "thread_sort_index", Phase: "M", PID: procsPID, TID: procTIDBase + uint64(p), Arg: map[string]int{"sort_index": int(procTIDBase + p)}})
+	}
+	sort.SliceStable(data.Events, func(i, j int) bool {
+		if mi, mj := data.Events[i].Phase == "M", data.Events[j].Phase == "M"; mi != mj {
+			return mi
+		}
+		return data.Events[i].Time < data.Events[j].Time
+	})
+	return data
+}
+
+// metadataEvents returns the number of the metadata events at the beginning of the sorted events.
+func metadataEvents(data *traceviewer.Data) int {
+	return sort.Search(len(data.Events), func(i int) bool { return data.Events[i].Phase != "M" })
+}
+
+// splitTrace splits the trace into ranges of events, each resulting in approx max bytes
+// of json output like v1175, the trace viewer can hardly handle more. The trace is not split if it is small.
+func splitTrace(data *traceviewer.Data, max int) []traceui.Range {
+	n := metadataEvents(data)
+	b, _ := json.Marshal(&traceviewer.Data{Events: data.Events[:n], Frames: data.Frames, TimeUnit: data.TimeUnit})
+	minSize := len(b)
+
+	events := data.Events[n:]
+	newRange := func(start, end int) traceui.Range {
+		startTime := time.Duration(events[start].Time * 1000)
+		endTime := time.Duration(events[end-1].Time * 1000)
+		return traceui.Range{
+			Name:      fmt.Sprintf("%v-%v", startTime, endTime),
+			Start:     start,
+			End:       end,
+			StartTime: int64(startTime),
+			EndTime:   int64(endTime),
+		}
+	}
+	var ranges []traceui.Range
+	sum := minSize
+	start := 0
+	for i, ev := range events {
+		b, _ := json.Marshal(ev)
+		size := len(b) + 1 // +1 for ",".
+		if sum+size > max && i > start {
+			ranges = append(ranges, newRange(start, i))
+			start = i
+			sum = minSize
+		}
+		sum += size
+	}
+	if len(ranges) == 0 {
+		return nil
+	}
+	return append(ranges, newRange(start, len(events)))
+}
+
+// rangeTrace returns the trace with the metadata events and the other events in [start, end) of a range.
+func rangeTrace(data *traceviewer.Data, start, end int) *traceviewer.Data {
+	n := metadataEvents(data)
+	events := data.Events[n:]
+	if start < 0 {
+		start = 0
+	}
+	if end > len(events) {
+		end = len(events)
+	}
+	if start > end {
+		start = end
+	}
+	res := *data
+	res.Events = append(data.Events[:n:n], events[start:end]...)
+	return &res
+}
@@ -0,0 +1,62 @@
+// Package tracev2 parses and serves the generation-based execution trace
+// format written by Go 1.21 and newer. Older traces are handled by v1175.
+package tracev2
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	v1trace "cprofiler/pkg/internal/v1175/trace"
+
+	"golang.org/x/exp/trace"
+)
+
+// headerLen is the length of the "go 1.xx trace" header shared by all trace versions.
+const headerLen = 16
+
+// IsNewFormat reports whether the trace header is valid but its version is too new
+// for v1175, so the trace must be handled by this package.
+func IsNewFormat(data []byte) bool {
+	if len(data) < headerLen {
+		return false
+	}
+	ver, err := v1trace.ParseHeader(data[:headerLen])
+	return err == nil && !v1trace.SupportedVersion(ver)
+}
+
+// ParseResult is the result of Parse.
+type ParseResult struct {
+	// Events is the sorted list of Events in the trace.
+	Events []trace.Event
+}
+
+// Parse parses, post-processes and verifies the trace.
+func Parse(data []byte) (ParseResult, error) {
+	r, err := trace.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return ParseResult{}, err
+	}
+
+	events := make([]trace.Event, 0)
+	for {
+		ev, err := r.ReadEvent()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return ParseResult{}, err
+		}
+		events = append(events, ev)
+	}
+	if len(events) == 0 {
+		return ParseResult{}, errors.New("trace is empty")
+	}
+	return ParseResult{Events: events}, nil
+}
+
+// rangeKey identifies an active range, only one range of a name is active on a resource.
+type rangeKey struct {
+	name  string
+	scope trace.ResourceID
+}
@@ -0,0 +1,147 @@
+package tracev2
+
+import (
+	"strings"
+
+	"github.com/google/pprof/profile"
+	"golang.org/x/exp/trace"
+)
+
+// record represents one entry in pprof-like profiles.
+type record struct {
+	stk  trace.Stack
+	n    uint64
+	time int64
+}
+
+// pending is a goroutine in the tracked state since start, with the stack at the transition.
+type pending struct {
+	stk   trace.Stack
+	start int64
+}
+
+// computePprof generates a pprof-like profile of the time goroutines spend in state,
+// only the transitions into state with a reason accepted by trackReason are tracked.
+func computePprof(events []trace.Event, state trace.GoState, trackReason func(string) bool) *profile.Profile {
+	prof := make(map[trace.Stack]*record)
+	tracking := make(map[trace.GoID]pending)
+	for _, ev := range events {
+		if ev.Kind() != trace.EventStateTransition {
+			continue
+		}
+		st := ev.StateTransition()
+		if st.Resource.Kind != trace.ResourceGoroutine {
+			continue
+		}
+		id := st.Resource.Goroutine()
+		from, to := st.Goroutine()
+		if from == state {
+			if p, ok := tracking[id]; ok {
+				rec := prof[p.stk]
+				if rec == nil {
+					rec = &record{stk: p.stk}
+					prof[p.stk] = rec
+				}
+				rec.n++
+				rec.time += int64(ev.Time()) - p.start
+				delete(tracking, id)
+			}
+		}
+		if to == state && trackReason(st.Reason) && ev.Stack() != trace.NoStack {
+			tracking[id] = pending{stk: ev.Stack(), start: int64(ev.Time())}
+		}
+	}
+	return buildProfile(prof)
+}
+
+// computePprofIO generates IO pprof-like profile (time spent in IO wait, currently only network blocking event).
+func computePprofIO(events []trace.Event) *profile.Profile {
+	return computePprof(events, trace.GoWaiting, func(reason string) bool {
+		return reason == "network"
+	})
+}
+
+// computePprofBlock generates blocking pprof-like profile (time spent blocked on synchronization primitives).
+func computePprofBlock(events []trace.Event) *profile.Profile {
+	return computePprof(events, trace.GoWaiting, func(reason string) bool {
+		return strings.Contains(reason, "chan") || strings.Contains(reason, "sync") || strings.Contains(reason, "select")
+	})
+}
+
+// computePprofSyscall generates syscall pprof-like profile (time spent blocked in syscalls).
+func computePprofSyscall(events []trace.Event) *profile.Profile {
+	return computePprof(events, trace.GoSyscall, func(string) bool {
+		return true
+	})
+}
+
+// computePprofSched generates scheduler latency pprof-like profile
+// (time between a goroutine become runnable and actually scheduled for execution).
+func computePprofSched(events []trace.Event) *profile.Profile {
+	return computePprof(events, trace.GoRunnable, func(string) bool {
+		return true
+	})
+}
+
+// PprofProfiles returns the network blocking, synchronization blocking, syscall blocking
+// and scheduler latency profiles of all goroutines, keyed by io, block, syscall and sched.
+func (traceUI *TraceUI) PprofProfiles() (map[string]*profile.Profile, error) {
+	events := traceUI.res.Events
+	return map[string]*profile.Profile{
+		"io":      computePprofIO(events),
+		"block":   computePprofBlock(events),
+		"syscall": computePprofSyscall(events),
+		"sched":   computePprofSched(events),
+	}, nil
+}
+
+// buildProfile builds the profile with the same sample types as v1175 traceui.
+func buildProfile(prof map[trace.Stack]*record) *profile.Profile {
+	p := &profile.Profile{
+		PeriodType: &profile.ValueType{Type: "trace", Unit: "count"},
+		Period:     1,
+		SampleType: []*profile.ValueType{
+			{Type: "contentions", Unit: "count"},
+			{Type: "delay", Unit: "nanoseconds"},
+		},
+	}
+	locs := make(map[uint64]*profile.Location)
+	funcs := make(map[string]*profile.Function)
+	for _, rec := range prof {
+		var sloc []*profile.Location
+		for frame := range rec.stk.Frames() {
+			loc := locs[frame.PC]
+			if loc == nil {
+				fn := funcs[frame.File+frame.Func]
+				if fn == nil {
+					fn = &profile.Function{
+						ID:         uint64(len(p.Function) + 1),
+						Name:       frame.Func,
+						SystemName: frame.Func,
+						Filename:   frame.File,
+					}
+					p.Function = append(p.Function, fn)
+					funcs[frame.File+frame.Func] = fn
+				}
+				loc = &profile.Location{
+					ID:      uint64(len(p.Location) + 1),
+					Address: frame.PC,
+					Line: []profile.Line{
+						{
+							Function: fn,
+							Line:     int64(frame.Line),
+						},
+					},
+				}
+				p.Location = append(p.Location, loc)
+				locs[frame.PC] = loc
+			}
+			sloc = append(sloc, loc)
+		}
+		p.Sample = append(p.Sample, &profile.Sample{
+			Value:    []int64{int64(rec.n), rec.time},
+			Location: sloc,
+		})
+	}
+	return p
+}
@@ -0,0 +1,223 @@
+package tracev2
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	v1trace "cprofiler/pkg/internal/v1175/trace"
+
+	"github.com/stretchr/testify/require"
+)
+
+func readTestTrace(t *testing.T) []byte {
+	data, err := ioutil.ReadFile("../../apiserver/testdata/trace_go126.out.testdata")
+	require.Equal(t, nil, err)
+	return data
+}
+
+func TestIsNewFormat(t *testing.T) {
+	require.Equal(t, true, IsNewFormat(readTestTrace(t)))
+
+	old, err := ioutil.ReadFile("../../apiserver/testdata/trace.out.testdata")
+	require.Equal(t, nil, err)
+	require.Equal(t, false, IsNewFormat(old))
+
+	require.Equal(t, false, IsNewFormat(nil))
+	require.Equal(t, false, IsNewFormat([]byte("haha")))
+}
+
+func TestParse(t *testing.T) {
+	res, err := Parse(readTestTrace(t))
+	require.Equal(t, nil, err)
+	require.NotEqual(t, 0, len(res.Events))
+
+	_, err = Parse([]byte("haha"))
+	require.NotEqual(t, nil, err)
+}
+
+func TestGoroutineStats(t *testing.T) {
+	res, err := Parse(readTestTrace(t))
+	require.Equal(t, nil, err)
+
+	gs := GoroutineStats(res.Events)
+	// The test program starts 20 workers with pprof.Do
+	workers := 0
+	for _, g := range gs {
+		require.Equal(t, true, g.TotalTime >= 0)
+		require.Equal(t, true, g.ExecTime+g.SchedWaitTime+g.BlockTime+g.IOTime+g.SyscallTime <= g.TotalTime)
+		if g.Name == "runtime/pprof.Do" {
+			workers++
+			require.Equal(t, true, g.ExecTime > 0)
+			require.Equal(t, true, g.EndTime > g.StartTime)
+		}
+	}
+	require.Equal(t, 20, workers)
+}
+
+func TestMutatorUtilization(t *testing.T) {
+	res, err := Parse(readTestTrace(t))
+	require.Equal(t, nil, err)
+
+	flags := v1trace.UtilSTW | v1trace.UtilBackground | v1trace.UtilAssist
+	utils := MutatorUtilization(res.Events, flags)
+	require.Equal(t, 1, len(utils))
+	last := utils[0][len(utils[0])-1]
+	require.Equal(t, 0.0, last.Util)
+	for i, u := range utils[0] {
+		require.Equal(t, true, u.Util >= 0 && u.Util <= 1)
+		if i > 0 {
+			require.Equal(t, true, u.Time > utils[0][i-1].Time)
+		}
+	}
+	// The test program calls runtime.GC, so some window has no mutator utilization
+	curve := v1trace.NewMMUCurve(utils)
+	require.Equal(t, true, curve.MMU(time.Millisecond) < 1)
+
+	perProc := MutatorUtilization(res.Events, flags|v1trace.UtilPerProc)
+	require.Equal(t, true, len(perProc) >= 1)
+
+	require.Equal(t, 0, len(MutatorUtilization(nil, flags)))
+}
+
+func TestGenerateTrace(t *testing.T) {
+	res, err := Parse(readTestTrace(t))
+	require.Equal(t, nil, err)
+
+	data := generateTrace(res.Events)
+	slices := 0
+	n := metadataEvents(data)
+	for i, ev := range data.Events {
+		require.Equal(t, i < n, ev.Phase == "M")
+		if i > n {
+			require.Equal(t, true, ev.Time >= data.Events[i-1].Time)
+		}
+		if ev.Phase == "X" {
+			slices++
+			require.Equal(t, true, ev.Time >= 0)
+		}
+	}
+	require.NotEqual(t, 0, slices)
+}
+
+func TestSplitTrace(t *testing.T) {
+	res, err := Parse(readTestTrace(t))
+	require.Equal(t, nil, err)
+
+	data := generateTrace(res.Events)
+	require.Equal(t, 0, len(splitTrace(data, 100<<20)))
+
+	n := metadataEvents(data)
+	ranges := splitTrace(data, 64<<10)
+	require.Equal(t, true, len(ranges) > 1)
+	require.Equal(t, 0, ranges[0].Start)
+	require.Equal(t, len(data.Events)-n, ranges[len(ranges)-1].End)
+	for i, r := range ranges {
+		require.Equal(t, true, r.End > r.Start)
+		require.Equal(t, true, r.EndTime >= r.StartTime)
+		if i > 0 {
+			require.Equal(t, ranges[i-1].End, r.Start)
+		}
+
+		part := rangeTrace(data, r.Start, r.End)
+		require.Equal(t, n+r.End-r.Start, len(part.Events))
+		b, err := json.Marshal(part)
+		require.Equal(t, nil, err)
+		require.Equal(t, true, len(b) <= 64<<10)
+	}
+	// the whole trace is kept
+	require.Equal(t, len(data.Events), len(rangeTrace(data, 0, len(data.Events)).Events))
+}
+
+func TestUI(t *testing.T) {
+	ui, err := NewUI(readTestTrace(t))
+	require.Equal(t, nil, err)
+
+	var regionPC uint64
+	for key := range ui.analyzeAnnotations().regions {
+		regionPC = key.frame.PC
+	}
+	get := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		ui.Handlers[r.URL.Path](w, r)
+		return w
+	}
+	for _, target := range []string{"/", "/goroutines", "/jsontrace", "/json/usertasks", "/json/userregions",
+		"/mmu", "/mmuPlot?flags=stw|background|assist", "/mmuDetails?window=1000000&flags=stw|background|assist",
+		"/usertasks", "/usertask?type=work", "/userregions"} {
+		require.Equal(t, http.StatusOK, get(target).Code, target)
+	}
+
+	// The test program runs one "work" task with an "alloc" region in each of its 20 workers
+	w := get("/usertasks")
+	require.Contains(t, w.Body.String(), "usertask?type=work&complete=true&latmin=")
+	w = get("/usertask?type=work&complete=true&latmin=0s")
+	require.Equal(t, 1, strings.Count(w.Body.String(), "<td><a href=\"trace#"))
+	w = get("/usertask?type=work&complete=true&latmin=1h")
+	require.Equal(t, 0, strings.Count(w.Body.String(), "<td><a href=\"trace#"))
+	w = get(fmt.Sprintf("/userregion?type=alloc&pc=%x", regionPC))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, 20, strings.Count(w.Body.String(), "<td><a href=\"trace#"))
+	require.Equal(t, http.StatusBadRequest, get("/userregion?type=alloc&pc=haha").Code)
+	require.Equal(t, http.StatusBadRequest, get("/usertask?type=work&latmin=haha").Code)
+
+	w = get("/mmuDetails?window=1000000&flags=stw|background|assist")
+	var details []struct{ URL string }
+	require.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &details))
+	require.NotEqual(t, 0, len(details))
+	require.Equal(t, true, strings.HasPrefix(details[0].URL, "trace#"))
+
+	// A range of the trace keeps the metadata events
+	w = get("/jsontrace?start=0&end=1")
+	var data struct{ TraceEvents []map[string]interface{} }
+	require.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &data))
+	require.Equal(t, metadataEvents(generateTrace(ui.res.Events))+1, len(data.TraceEvents))
+
+	require.Equal(t, http.StatusInternalServerError, get("/goroutine?id=bad").Code)
+}
+
+func TestAnalyzeAnnotations(t *testing.T) {
+	res, err := Parse(readTestTrace(t))
+	require.Equal(t, nil, err)
+
+	// The test program runs one "work" task with an "alloc" region in each of its 20 workers
+	annotations := analyzeAnnotations(res.Events)
+	require.Equal(t, 1, len(annotations.tasks))
+	for _, task := range annotations.tasks {
+		require.Equal(t, "work", task.typ)
+		require.Equal(t, true, task.end > task.start)
+	}
+
+	regions := 0
+	for key, descs := range annotations.regions {
+		require.Equal(t, "alloc", key.typ)
+		require.NotEqual(t, "", key.frame.Func)
+		for _, desc := range descs {
+			require.NotEqual(t, uint64(0), uint64(desc.goid))
+			require.Equal(t, true, desc.duration > 0)
+		}
+		regions += len(descs)
+	}
+	require.Equal(t, 20, regions)
+}
+
+func TestPprofProfiles(t *testing.T) {
+	ui, err := NewUI(readTestTrace(t))
+	require.Equal(t, nil, err)
+
+	profiles, err := ui.PprofProfiles()
+	require.Equal(t, nil, err)
+	require.Equal(t, 4, len(profiles))
+	for kind, p := range profiles {
+		require.Equal(t, nil, p.CheckValid(), kind)
+	}
+	// main waits for the workers with a sync.WaitGroup, and all the workers get scheduled
+	require.NotEqual(t, 0, len(profiles["block"].Sample))
+	require.NotEqual(t, 0, len(profiles["sched"].Sample))
+}
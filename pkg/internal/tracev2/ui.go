@@ -0,0 +1,353 @@
+package tracev2
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	v1trace "cprofiler/pkg/internal/v1175/trace"
+	"cprofiler/pkg/internal/v1175/traceui"
+)
+
+// TraceUI serves the web UI of a generation-based trace, its Handlers
+// use the same patterns as v1175 traceui.TraceUI.
+type TraceUI struct {
+	res      ParseResult
+	ranges   []traceui.Range
+	Handlers map[string]http.HandlerFunc
+	gsInit   sync.Once
+	gs       map[uint64]*GDesc
+
+	annotationsInit sync.Once
+	annotations     annotationAnalysisResult
+
+	mmuLock  sync.Mutex
+	mmuCache map[v1trace.UtilFlags]*mmuCacheEntry
+}
+
+type mmuCacheEntry struct {
+	init     sync.Once
+	util     [][]v1trace.MutatorUtil
+	mmuCurve *v1trace.MMUCurve
+	err      error
+}
+
+func NewUI(data []byte) (*TraceUI, error) {
+	res, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	traceUI := &TraceUI{
+		res:      res,
+		mmuCache: make(map[v1trace.UtilFlags]*mmuCacheEntry),
+	}
+	traceUI.ranges = splitTrace(generateTrace(res.Events), 100<<20) // 100M
+
+	handlers := make(map[string]http.HandlerFunc)
+	handlers["/"] = traceUI.httpMain
+	handlers["/mmu"] = traceui.MMU
+	handlers["/mmuPlot"] = traceUI.httpMMUPlot
+	handlers["/mmuDetails"] = traceUI.httpMMUDetails
+	handlers["/usertasks"] = traceUI.httpUserTasks
+	handlers["/usertask"] = traceUI.httpUserTask
+	handlers["/userregions"] = traceUI.httpUserRegions
+	handlers["/userregion"] = traceUI.httpUserRegion
+	handlers["/trace"] = traceui.TraceViewer
+	handlers["/jsontrace"] = traceUI.httpJsonTrace
+	handlers["/trace_viewer_html"] = traceui.TraceViewerHTML
+	handlers["/webcomponents.min.js"] = traceui.WebcomponentsJS
+	handlers["/goroutines"] = traceUI.httpGoroutines
+	handlers["/goroutine"] = traceUI.httpGoroutine
+	handlers["/json/goroutines"] = traceUI.httpGoroutinesJSON
+	handlers["/json/goroutine"] = traceUI.httpGoroutineJSON
+	handlers["/json/usertasks"] = traceUI.httpUserTasksJSON
+	handlers["/json/userregions"] = traceUI.httpUserRegionsJSON
+	handlers["/json/mmu"] = traceUI.httpMMUJSON
+
+	traceUI.Handlers = handlers
+	return traceUI, nil
+}
+
+// GoroutineStats returns statistics of all goroutines in the trace.
+func (traceUI *TraceUI) GoroutineStats() map[uint64]*GDesc {
+	traceUI.gsInit.Do(func() {
+		traceUI.gs = GoroutineStats(traceUI.res.Events)
+	})
+	return traceUI.gs
+}
+
+// timeRange returns the first and the last timestamp of the trace.
+func (traceUI *TraceUI) timeRange() (int64, int64) {
+	events := traceUI.res.Events
+	return int64(events[0].Time()), int64(events[len(events)-1].Time())
+}
+
+// getMMUCurve returns the mutator utilization and the MMU curve with flags, they are cached by flags.
+// The times are relative to the trace start like v1175, so the worst windows can be linked to the trace view.
+func (traceUI *TraceUI) getMMUCurve(flags v1trace.UtilFlags) ([][]v1trace.MutatorUtil, *v1trace.MMUCurve, error) {
+	traceUI.mmuLock.Lock()
+	c := traceUI.mmuCache[flags]
+	if c == nil {
+		c = new(mmuCacheEntry)
+		traceUI.mmuCache[flags] = c
+	}
+	traceUI.mmuLock.Unlock()
+
+	c.init.Do(func() {
+		c.util = MutatorUtilization(traceUI.res.Events, flags)
+		if len(c.util) == 0 {
+			c.err = errors.New("no GOMAXPROCS in the trace")
+			return
+		}
+		firstTs, _ := traceUI.timeRange()
+		for _, util := range c.util {
+			for i := range util {
+				util[i].Time -= firstTs
+			}
+		}
+		c.mmuCurve = v1trace.NewMMUCurve(c.util)
+	})
+	return c.util, c.mmuCurve, c.err
+}
+
+// httpMMUJSON serves the MMU curve as JSON, the same as v1175 traceui.
+func (traceUI *TraceUI) httpMMUJSON(w http.ResponseWriter, r *http.Request) {
+	traceui.ServeMMUJSON(w, r, traceUI.getMMUCurve)
+}
+
+// httpMMUPlot serves the JSON data for the MMU plot.
+func (traceUI *TraceUI) httpMMUPlot(w http.ResponseWriter, r *http.Request) {
+	traceui.ServeMMUPlot(w, r, traceUI.getMMUCurve)
+}
+
+// httpMMUDetails serves details of an MMU graph at a particular window.
+func (traceUI *TraceUI) httpMMUDetails(w http.ResponseWriter, r *http.Request) {
+	traceui.ServeMMUDetails(w, r, traceUI.getMMUCurve, traceUI.ranges)
+}
+
+// httpMain serves the starting page.
+func (traceUI *TraceUI) httpMain(w http.ResponseWriter, r *http.Request) {
+	if err := templMain.Execute(w, traceUI.ranges); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+var templMain = template.Must(template.New("").Parse(`
+<html>
+<body>
+{{if $}}
+	{{range $e := $}}
+		<a href="{{$e.URL}}">View trace ({{$e.Name}})</a><br>
+	{{end}}
+	<br>
+{{else}}
+	<a href="trace">View trace</a><br>
+{{end}}
+<a href="goroutines">Goroutine analysis</a><br>
+<a href="usertasks">User-defined tasks</a><br>
+<a href="userregions">User-defined regions</a><br>
+<a href="mmu">Minimum mutator utilization</a><br>
+
+<a href="pprof/io/">Network blocking profile</a><br>
+<a href="pprof/block/">Synchronization blocking profile</a><br>
+<a href="pprof/syscall/">Syscall blocking profile</a><br>
+<a href="pprof/sched/">Scheduler latency profile</a><br>
+</body>
+</html>
+`))
+
+// httpJsonTrace serves json trace, requested from within the trace viewer page.
+// The start and end parameters select a range of the split trace.
+func (traceUI *TraceUI) httpJsonTrace(w http.ResponseWriter, r *http.Request) {
+	data := generateTrace(traceUI.res.Events)
+	if startStr, endStr := r.FormValue("start"), r.FormValue("end"); startStr != "" && endStr != "" {
+		start, err := strconv.Atoi(startStr)
+		if err != nil {
+			log.Printf("failed to parse start parameter %q: %v", startStr, err)
+			return
+		}
+		end, err := strconv.Atoi(endStr)
+		if err != nil {
+			log.Printf("failed to parse end parameter %q: %v", endStr, err)
+			return
+		}
+		data = rangeTrace(data, start, end)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		// This is an AJAX handler, so instead of http.Error we use log.Printf to log errors.
+		log.Printf("failed to write json trace: %v", err)
+	}
+}
+
+// goroutineList returns all goroutines of the trace.
+func (traceUI *TraceUI) goroutineList() []traceui.Goroutine {
+	stats := traceUI.GoroutineStats()
+	gs := make([]traceui.Goroutine, 0, len(stats))
+	for _, g := range stats {
+		gs = append(gs, traceui.Goroutine{
+			ID:             g.ID,
+			Name:           g.Name,
+			PC:             g.PC,
+			CreationTime:   g.CreationTime,
+			StartTime:      g.StartTime,
+			EndTime:        g.EndTime,
+			GExecutionStat: g.GExecutionStat,
+		})
+	}
+	return gs
+}
+
+// httpGoroutinesJSON serves goroutine groups as JSON.
+func (traceUI *TraceUI) httpGoroutinesJSON(w http.ResponseWriter, r *http.Request) {
+	traceui.ServeGoroutinesJSON(w, traceUI.goroutineList())
+}
+
+// httpGoroutineJSON serves goroutines in a particular group as JSON.
+func (traceUI *TraceUI) httpGoroutineJSON(w http.ResponseWriter, r *http.Request) {
+	traceui.ServeGoroutineJSON(w, r, traceUI.goroutineList())
+}
+
+// gtype describes a group of goroutines grouped by start PC.
+type gtype struct {
+	ID       uint64 // Unique identifier (PC).
+	Name     string // Start function.
+	N        int    // Total number of goroutines in this group.
+	ExecTime int64  // Total execution time of all goroutines in this group.
+}
+
+// httpGoroutines serves list of goroutine groups.
+func (traceUI *TraceUI) httpGoroutines(w http.ResponseWriter, r *http.Request) {
+	gss := make(map[uint64]gtype)
+	for _, g := range traceUI.GoroutineStats() {
+		gs1 := gss[g.PC]
+		gs1.ID = g.PC
+		gs1.Name = g.Name
+		gs1.N++
+		gs1.ExecTime += g.ExecTime
+		gss[g.PC] = gs1
+	}
+	var glist []gtype
+	for _, v := range gss {
+		glist = append(glist, v)
+	}
+	sort.Slice(glist, func(i, j int) bool { return glist[i].ExecTime > glist[j].ExecTime })
+	w.Header().Set("Content-Type", "text/html;charset=utf-8")
+	if err := templGoroutines.Execute(w, glist); err != nil {
+		log.Printf("failed to execute template: %v", err)
+		return
+	}
+}
+
+var templGoroutines = template.Must(template.New("").Parse(`
+<html>
+<body>
+Goroutines: <br>
+{{range $}}
+  <a href="goroutine?id={{.ID}}">{{if .Name}}{{.Name}}{{else}}N/A{{end}}</a> N={{.N}} <br>
+{{end}}
+</body>
+</html>
+`))
+
+// httpGoroutine serves list of goroutines in a particular group.
+func (traceUI *TraceUI) httpGoroutine(w http.ResponseWriter, r *http.Request) {
+	pc, err := strconv.ParseUint(r.FormValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to parse id parameter '%v': %v", r.FormValue("id"), err), http.StatusInternalServerError)
+		return
+	}
+
+	var (
+		glist []*GDesc
+		name  string
+	)
+	for _, g := range traceUI.GoroutineStats() {
+		if g.PC != pc {
+			continue
+		}
+		glist = append(glist, g)
+		name = g.Name
+	}
+	sort.Slice(glist, func(i, j int) bool { return glist[i].TotalTime > glist[j].TotalTime })
+
+	w.Header().Set("Content-Type", "text/html;charset=utf-8")
+	err = templGoroutine.Execute(w, struct {
+		Name  string
+		PC    uint64
+		GList []*GDesc
+	}{
+		Name:  name,
+		PC:    pc,
+		GList: glist,
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to execute template: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+var templGoroutine = template.Must(template.New("").Funcs(template.FuncMap{
+	"prettyDuration": func(nsec int64) template.HTML {
+		d := time.Duration(nsec) * time.Nanosecond
+		return template.HTML(d.String())
+	},
+}).Parse(`
+<!DOCTYPE html>
+<title>Goroutine {{.Name}}</title>
+<style>
+th {
+  background-color: #050505;
+  color: #fff;
+}
+table {
+  border-collapse: collapse;
+}
+td,
+th {
+  padding-left: 8px;
+  padding-right: 8px;
+  padding-top: 4px;
+  padding-bottom: 4px;
+}
+</style>
+<table>
+<tr><td>Goroutine Name:</td><td>{{.Name}}</td></tr>
+<tr><td>Number of Goroutines:</td><td>{{len .GList}}</td></tr>
+</table>
+<p>
+<table>
+<tr>
+<th> Goroutine</th>
+<th> Total</th>
+<th> Execution</th>
+<th> Network wait</th>
+<th> Sync block</th>
+<th> Blocking syscall</th>
+<th> Scheduler wait</th>
+<th> GC sweeping</th>
+<th> GC pause</th>
+</tr>
+{{range .GList}}
+  <tr>
+    <td>{{.ID}}</td>
+    <td>{{prettyDuration .TotalTime}}</td>
+    <td>{{prettyDuration .ExecTime}}</td>
+    <td>{{prettyDuration .IOTime}}</td>
+    <td>{{prettyDuration .BlockTime}}</td>
+    <td>{{prettyDuration .SyscallTime}}</td>
+    <td>{{prettyDuration .SchedWaitTime}}</td>
+    <td>{{prettyDuration .SweepTime}}</td>
+    <td>{{prettyDuration .GCTime}}</td>
+  </tr>
+{{end}}
+</table>
+`))
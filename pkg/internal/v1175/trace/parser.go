@@ -149,12 +149,7 @@ func readTrace(r io.Reader) (ver int, events []rawEvent, strings map[uint64]stri
 	if err != nil {
 		return
 	}
-	switch ver {
-	case 1005, 1007, 1008, 1009, 1010, 1011:
-		// Note: When adding a new version, add canned traces
-		// from the old version to the test suite using mkcanned.bash.
-		break
-	default:
+	if !SupportedVersion(ver) {
 		err = fmt.Errorf("unsupported trace file version %v.%v (update Go toolchain) %v", ver/1000, ver%1000, ver)
 		return
 	}
@@ -287,6 +282,22 @@ func readStr(r io.Reader, off0 int) (s string, off int, err error) {
 	return string(buf), off + n, nil
 }
 
+// SupportedVersion reports whether the trace version (as returned by ParseHeader) can be parsed.
+func SupportedVersion(ver int) bool {
+	switch ver {
+	case 1005, 1007, 1008, 1009, 1010, 1011:
+		// Note: When adding a new version, add canned traces
+		// from the old version to the test suite using mkcanned.bash.
+		return true
+	}
+	return false
+}
+
+// ParseHeader parses the 16 bytes trace header and returns parsed version as 1007.
+func ParseHeader(buf []byte) (int, error) {
+	return parseHeader(buf)
+}
+
 // parseHeader parses trace header of the form "go 1.7 trace\x00\x00\x00\x00"
 // and returns parsed version as 1007.
 func parseHeader(buf []byte) (int, error) {
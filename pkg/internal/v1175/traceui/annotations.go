@@ -897,6 +897,16 @@ func (h *durationHistogram) ToHTML(urlmaker func(min, max time.Duration) string)
 	return template.HTML(w.String())
 }
 
+// HistogramHTML renders the histogram of ds like the user tasks page, urlmaker returns the url
+// listing the durations of a bucket.
+func HistogramHTML(ds []time.Duration, urlmaker func(min, max time.Duration) string) template.HTML {
+	var h durationHistogram
+	for _, d := range ds {
+		h.add(d)
+	}
+	return h.ToHTML(urlmaker)
+}
+
 func (h *durationHistogram) String() string {
 	const barWidth = 40
 
@@ -932,7 +942,7 @@ type regionStats struct {
 
 func (s *regionStats) UserRegionURL() func(min, max time.Duration) string {
 	return func(min, max time.Duration) string {
-		return fmt.Sprintf("/userregion?type=%s&pc=%x&latmin=%v&latmax=%v", template.URLQueryEscaper(s.Type), s.Frame.PC, template.URLQueryEscaper(min), template.URLQueryEscaper(max))
+		return fmt.Sprintf("userregion?type=%s&pc=%x&latmin=%v&latmax=%v", template.URLQueryEscaper(s.Type), s.Frame.PC, template.URLQueryEscaper(min), template.URLQueryEscaper(max))
 	}
 }
 
@@ -976,7 +986,7 @@ type taskStats struct {
 
 func (s *taskStats) UserTaskURL(complete bool) func(min, max time.Duration) string {
 	return func(min, max time.Duration) string {
-		return fmt.Sprintf("/usertask?type=%s&complete=%v&latmin=%v&latmax=%v", template.URLQueryEscaper(s.Type), template.URLQueryEscaper(complete), template.URLQueryEscaper(min), template.URLQueryEscaper(max))
+		return fmt.Sprintf("usertask?type=%s&complete=%v&latmin=%v&latmax=%v", template.URLQueryEscaper(s.Type), template.URLQueryEscaper(complete), template.URLQueryEscaper(min), template.URLQueryEscaper(max))
 	}
 }
 
@@ -0,0 +1,406 @@
+// JSON endpoints of the trace analysis, for dashboards which can't consume the HTML pages.
+
+package traceui
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cprofiler/pkg/internal/v1175/trace"
+)
+
+// Goroutine is a goroutine and its execution stats, times are in nanoseconds.
+type Goroutine struct {
+	ID           uint64
+	Name         string
+	PC           uint64
+	CreationTime int64
+	StartTime    int64
+	EndTime      int64
+	trace.GExecutionStat
+}
+
+// GoroutineGroup is a group of goroutines sharing the same start PC,
+// the execution stats are the sums over the goroutines in the group.
+type GoroutineGroup struct {
+	PC    uint64
+	Name  string
+	Count int
+	trace.GExecutionStat
+}
+
+// GoroutineGroupDetail is a goroutine group and its goroutines.
+type GoroutineGroupDetail struct {
+	GoroutineGroup
+	Goroutines []Goroutine
+}
+
+// GroupGoroutines groups goroutines by start PC, sorted by execution time in descending order.
+func GroupGoroutines(gs []Goroutine) []GoroutineGroup {
+	groups := make(map[uint64]*GoroutineGroup)
+	for _, g := range gs {
+		group, ok := groups[g.PC]
+		if !ok {
+			group = &GoroutineGroup{PC: g.PC, Name: g.Name}
+			groups[g.PC] = group
+		}
+		group.Count++
+		group.ExecTime += g.ExecTime
+		group.SchedWaitTime += g.SchedWaitTime
+		group.IOTime += g.IOTime
+		group.BlockTime += g.BlockTime
+		group.SyscallTime += g.SyscallTime
+		group.GCTime += g.GCTime
+		group.SweepTime += g.SweepTime
+		group.TotalTime += g.TotalTime
+	}
+
+	list := make([]GoroutineGroup, 0, len(groups))
+	for _, group := range groups {
+		list = append(list, *group)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].ExecTime != list[j].ExecTime {
+			return list[i].ExecTime > list[j].ExecTime
+		}
+		return list[i].PC < list[j].PC
+	})
+	return list
+}
+
+// ServeGoroutinesJSON serves the goroutine groups.
+func ServeGoroutinesJSON(w http.ResponseWriter, gs []Goroutine) {
+	WriteJSON(w, GroupGoroutines(gs))
+}
+
+// ServeGoroutineJSON serves the goroutine group whose start PC is the id parameter,
+// the goroutines are sorted by total time in descending order.
+func ServeGoroutineJSON(w http.ResponseWriter, r *http.Request, gs []Goroutine) {
+	pc, err := strconv.ParseUint(r.FormValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to parse id parameter '%v': %v", r.FormValue("id"), err), http.StatusBadRequest)
+		return
+	}
+
+	detail := GoroutineGroupDetail{Goroutines: make([]Goroutine, 0)}
+	for _, g := range gs {
+		if g.PC == pc {
+			detail.Goroutines = append(detail.Goroutines, g)
+		}
+	}
+	groups := GroupGoroutines(detail.Goroutines)
+	if len(groups) == 0 {
+		http.Error(w, fmt.Sprintf("goroutine group %d not found", pc), http.StatusNotFound)
+		return
+	}
+	detail.GoroutineGroup = groups[0]
+	sort.Slice(detail.Goroutines, func(i, j int) bool {
+		if detail.Goroutines[i].TotalTime != detail.Goroutines[j].TotalTime {
+			return detail.Goroutines[i].TotalTime > detail.Goroutines[j].TotalTime
+		}
+		return detail.Goroutines[i].ID < detail.Goroutines[j].ID
+	})
+	WriteJSON(w, detail)
+}
+
+// WriteJSON writes v as a json response, encoding errors are only logged.
+func WriteJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("failed to write json: %v", err)
+	}
+}
+
+// goroutineList returns all goroutines of the trace.
+func (traceUI *TraceUI) goroutineList() ([]Goroutine, error) {
+	events, err := traceUI.parseEvents()
+	if err != nil {
+		return nil, err
+	}
+	traceUI.analyzeGoroutines(events)
+
+	gs := make([]Goroutine, 0, len(traceUI.gs))
+	for _, g := range traceUI.gs {
+		gs = append(gs, Goroutine{
+			ID:             g.ID,
+			Name:           g.Name,
+			PC:             g.PC,
+			CreationTime:   g.CreationTime,
+			StartTime:      g.StartTime,
+			EndTime:        g.EndTime,
+			GExecutionStat: g.GExecutionStat,
+		})
+	}
+	return gs, nil
+}
+
+// httpGoroutinesJSON serves goroutine groups as JSON.
+func (traceUI *TraceUI) httpGoroutinesJSON(w http.ResponseWriter, r *http.Request) {
+	gs, err := traceUI.goroutineList()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ServeGoroutinesJSON(w, gs)
+}
+
+// httpGoroutineJSON serves goroutines in a particular group as JSON.
+func (traceUI *TraceUI) httpGoroutineJSON(w http.ResponseWriter, r *http.Request) {
+	gs, err := traceUI.goroutineList()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ServeGoroutineJSON(w, r, gs)
+}
+
+// defaultMMUFlags are the flags of the MMU curve when the flags parameter is empty, all kinds of GC work.
+const defaultMMUFlags = "stw|background|assist|sweep"
+
+// mudQuantiles are the mutator utilization distribution quantiles served with the "mut" flag.
+var mudQuantiles = []float64{0, 1 - .999, 1 - .99, 1 - .95}
+
+// MMUPoint is the minimum mutator utilization at a window size in nanoseconds.
+type MMUPoint struct {
+	Window int64
+	MMU    float64
+	// MUD is the mutator utilization distribution at the MMUResult Quantiles, set with the "mut" flag.
+	MUD []float64 `json:",omitempty"`
+}
+
+// MMUResult is a MMU curve.
+type MMUResult struct {
+	Flags     []string
+	Quantiles []float64 `json:",omitempty"`
+	Points    []MMUPoint
+}
+
+// MMUCurveFunc returns the mutator utilization and the MMU curve of a trace with flags.
+type MMUCurveFunc func(flags trace.UtilFlags) ([][]trace.MutatorUtil, *trace.MMUCurve, error)
+
+// httpMMUJSON serves the MMU curve as JSON.
+func (traceUI *TraceUI) httpMMUJSON(w http.ResponseWriter, r *http.Request) {
+	ServeMMUJSON(w, r, traceUI.getMMUCurveByFlags)
+}
+
+// ServeMMUJSON serves the MMU curve got by curve as JSON. The flags parameter is a "|" separated list of
+// perProc, stw, background, assist, sweep and mut, the windows parameter is an optional
+// comma separated list of window durations such as 1ms,10ms.
+func ServeMMUJSON(w http.ResponseWriter, r *http.Request, curve MMUCurveFunc) {
+	flagsStr := r.FormValue("flags")
+	if flagsStr == "" {
+		flagsStr = defaultMMUFlags
+	}
+	var flags trace.UtilFlags
+	var mud bool
+	flagNames := strings.Split(flagsStr, "|")
+	for _, flagStr := range flagNames {
+		if flagStr == "mut" {
+			mud = true
+			continue
+		}
+		flag, ok := utilFlagNames[flagStr]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown flag %q", flagStr), http.StatusBadRequest)
+			return
+		}
+		flags |= flag
+	}
+
+	var windows []time.Duration
+	if windowsStr := r.FormValue("windows"); windowsStr != "" {
+		for _, windowStr := range strings.Split(windowsStr, ",") {
+			window, err := time.ParseDuration(windowStr)
+			if err != nil || window <= 0 {
+				http.Error(w, fmt.Sprintf("failed to parse window %q", windowStr), http.StatusBadRequest)
+				return
+			}
+			windows = append(windows, window)
+		}
+	}
+
+	mu, mmuCurve, err := curve(flags)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to compute MMU: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if windows == nil {
+		_, _, plot := mmuPlot(mu, mmuCurve, nil)
+		for _, p := range plot {
+			windows = append(windows, time.Duration(p[0]))
+		}
+	}
+
+	res := MMUResult{Flags: flagNames, Points: make([]MMUPoint, 0, len(windows))}
+	if mud {
+		res.Quantiles = mudQuantiles
+	}
+	for _, window := range windows {
+		p := MMUPoint{Window: int64(window), MMU: mmuCurve.MMU(window)}
+		if mud {
+			p.MUD = mmuCurve.MUD(window, mudQuantiles)
+		}
+		res.Points = append(res.Points, p)
+	}
+	WriteJSON(w, res)
+}
+
+// HistogramBucket is a duration histogram bucket, Min and Max are in nanoseconds.
+type HistogramBucket struct {
+	Min   int64
+	Max   int64
+	Count int
+}
+
+// DurationStats is the distribution of durations, all values are in nanoseconds.
+type DurationStats struct {
+	Count     int
+	Min       int64
+	Max       int64
+	Mean      int64
+	P50       int64
+	P90       int64
+	P95       int64
+	P99       int64
+	Histogram []HistogramBucket
+}
+
+// NewDurationStats computes the percentiles and the histogram of ds,
+// the histogram has five buckets for every power of 10 like the user tasks page.
+func NewDurationStats(ds []time.Duration) DurationStats {
+	stats := DurationStats{Count: len(ds), Histogram: make([]HistogramBucket, 0)}
+	if len(ds) == 0 {
+		return stats
+	}
+
+	sorted := make([]time.Duration, len(ds))
+	copy(sorted, ds)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var h durationHistogram
+	var sum time.Duration
+	for _, d := range sorted {
+		h.add(d)
+		sum += d
+	}
+	percentile := func(p float64) int64 {
+		rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+		if rank < 0 {
+			rank = 0
+		}
+		return int64(sorted[rank])
+	}
+
+	stats.Min = int64(sorted[0])
+	stats.Max = int64(sorted[len(sorted)-1])
+	stats.Mean = int64(sum) / int64(len(sorted))
+	stats.P50 = percentile(0.5)
+	stats.P90 = percentile(0.9)
+	stats.P95 = percentile(0.95)
+	stats.P99 = percentile(0.99)
+	for i := h.MinBucket; i <= h.MaxBucket; i++ {
+		stats.Histogram = append(stats.Histogram, HistogramBucket{
+			Min:   int64(h.BucketMin(i)),
+			Max:   int64(h.BucketMin(i + 1)),
+			Count: h.Buckets[i],
+		})
+	}
+	return stats
+}
+
+// TaskTypeStats is the stats of user tasks of a type, Durations only include complete tasks.
+type TaskTypeStats struct {
+	Type      string
+	Count     int // Complete + incomplete tasks
+	Durations DurationStats
+}
+
+// RegionTypeStats is the stats of user regions of a type started at the same PC.
+type RegionTypeStats struct {
+	Type      string
+	PC        uint64
+	Function  string
+	File      string
+	Line      int
+	Durations DurationStats
+}
+
+// SortTaskTypeStats sorts tasks by type.
+func SortTaskTypeStats(tasks []TaskTypeStats) {
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].Type < tasks[j].Type
+	})
+}
+
+// SortRegionTypeStats sorts regions by type and pc.
+func SortRegionTypeStats(regions []RegionTypeStats) {
+	sort.Slice(regions, func(i, j int) bool {
+		if regions[i].Type != regions[j].Type {
+			return regions[i].Type < regions[j].Type
+		}
+		return regions[i].PC < regions[j].PC
+	})
+}
+
+// httpUserTasksJSON serves the duration stats of user tasks by type as JSON.
+func (traceUI *TraceUI) httpUserTasksJSON(w http.ResponseWriter, r *http.Request) {
+	res, err := traceUI.analyzeAnnotations()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	counts := make(map[string]int)
+	durations := make(map[string][]time.Duration)
+	for _, task := range res.tasks {
+		counts[task.name]++
+		if task.complete() {
+			durations[task.name] = append(durations[task.name], task.duration())
+		}
+	}
+
+	tasks := make([]TaskTypeStats, 0, len(counts))
+	for typ, count := range counts {
+		tasks = append(tasks, TaskTypeStats{
+			Type:      typ,
+			Count:     count,
+			Durations: NewDurationStats(durations[typ]),
+		})
+	}
+	SortTaskTypeStats(tasks)
+	WriteJSON(w, tasks)
+}
+
+// httpUserRegionsJSON serves the duration stats of user regions by type as JSON.
+func (traceUI *TraceUI) httpUserRegionsJSON(w http.ResponseWriter, r *http.Request) {
+	res, err := traceUI.analyzeAnnotations()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	regions := make([]RegionTypeStats, 0, len(res.regions))
+	for id, descs := range res.regions {
+		durations := make([]time.Duration, 0, len(descs))
+		for _, desc := range descs {
+			durations = append(durations, desc.duration())
+		}
+		regions = append(regions, RegionTypeStats{
+			Type:      id.Type,
+			PC:        id.Frame.PC,
+			Function:  id.Frame.Fn,
+			File:      id.Frame.File,
+			Line:      id.Frame.Line,
+			Durations: NewDurationStats(durations),
+		})
+	}
+	SortRegionTypeStats(regions)
+	WriteJSON(w, regions)
+}
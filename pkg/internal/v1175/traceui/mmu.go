@@ -54,12 +54,16 @@ type mmuCacheEntry struct {
 	err      error
 }
 
-func (traceUI *TraceUI) getMMUCurve(r *http.Request) ([][]trace.MutatorUtil, *trace.MMUCurve, error) {
+// mmuFlags returns the flags parameter of the MMU plot page, unknown flags are ignored.
+func mmuFlags(r *http.Request) trace.UtilFlags {
 	var flags trace.UtilFlags
 	for _, flagStr := range strings.Split(r.FormValue("flags"), "|") {
 		flags |= utilFlagNames[flagStr]
 	}
+	return flags
+}
 
+func (traceUI *TraceUI) getMMUCurveByFlags(flags trace.UtilFlags) ([][]trace.MutatorUtil, *trace.MMUCurve, error) {
 	traceUI.mmuCache.lock.Lock()
 	c := traceUI.mmuCache.m[flags]
 	if c == nil {
@@ -80,14 +84,19 @@ func (traceUI *TraceUI) getMMUCurve(r *http.Request) ([][]trace.MutatorUtil, *tr
 	return c.util, c.mmuCurve, c.err
 }
 
-// httpMMU serves the MMU plot page.
-func httpMMU(w http.ResponseWriter, r *http.Request) {
+// MMU serves the MMU plot page, which loads mmuPlot and mmuDetails.
+func MMU(w http.ResponseWriter, r *http.Request) {
 	http.ServeContent(w, r, "", time.Time{}, strings.NewReader(templMMU))
 }
 
 // httpMMUPlot serves the JSON data for the MMU plot.
 func (traceUI *TraceUI) httpMMUPlot(w http.ResponseWriter, r *http.Request) {
-	mu, mmuCurve, err := traceUI.getMMUCurve(r)
+	ServeMMUPlot(w, r, traceUI.getMMUCurveByFlags)
+}
+
+// ServeMMUPlot serves the JSON data for the MMU plot of the curve got by curve.
+func ServeMMUPlot(w http.ResponseWriter, r *http.Request, curve MMUCurveFunc) {
+	mu, mmuCurve, err := curve(mmuFlags(r))
 	if err != nil {
 		http.Error(w, fmt.Sprintf("failed to parse events: %v", err), http.StatusInternalServerError)
 		return
@@ -101,8 +110,21 @@ func (traceUI *TraceUI) httpMMUPlot(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	xMin, xMax, plot := mmuPlot(mu, mmuCurve, quantiles)
+
+	// Create JSON response.
+	err = json.NewEncoder(w).Encode(map[string]interface{}{"xMin": int64(xMin), "xMax": int64(xMax), "quantiles": quantiles, "curve": plot})
+	if err != nil {
+		log.Printf("failed to serialize response: %v", err)
+		return
+	}
+}
+
+// mmuPlot computes the MMU curve, or the MUD quantiles if quantiles is not nil,
+// at 100 window sizes spaced logarithmically over a nice range for the trace.
+func mmuPlot(mu [][]trace.MutatorUtil, mmuCurve *trace.MMUCurve, quantiles []float64) (xMin, xMax time.Duration, plot [][]float64) {
 	// Find a nice starting point for the plot.
-	xMin := time.Second
+	xMin = time.Second
 	for xMin > 1 {
 		if mmu := mmuCurve.MMU(xMin); mmu < 0.0001 {
 			break
@@ -110,7 +132,7 @@ func (traceUI *TraceUI) httpMMUPlot(w http.ResponseWriter, r *http.Request) {
 		xMin /= 1000
 	}
 	// Cover six orders of magnitude.
-	xMax := xMin * 1e6
+	xMax = xMin * 1e6
 	// But no more than the length of the trace.
 	minEvent, maxEvent := mu[0][0].Time, mu[0][len(mu[0])-1].Time
 	for _, mu1 := range mu[1:] {
@@ -127,7 +149,7 @@ func (traceUI *TraceUI) httpMMUPlot(w http.ResponseWriter, r *http.Request) {
 	// Compute MMU curve.
 	logMin, logMax := math.Log(float64(xMin)), math.Log(float64(xMax))
 	const samples = 100
-	plot := make([][]float64, samples)
+	plot = make([][]float64, samples)
 	for i := 0; i < samples; i++ {
 		window := time.Duration(math.Exp(float64(i)/(samples-1)*(logMax-logMin) + logMin))
 		if quantiles == nil {
@@ -139,13 +161,7 @@ func (traceUI *TraceUI) httpMMUPlot(w http.ResponseWriter, r *http.Request) {
 		}
 		plot[i][0] = float64(window)
 	}
-
-	// Create JSON response.
-	err = json.NewEncoder(w).Encode(map[string]interface{}{"xMin": int64(xMin), "xMax": int64(xMax), "quantiles": quantiles, "curve": plot})
-	if err != nil {
-		log.Printf("failed to serialize response: %v", err)
-		return
-	}
+	return xMin, xMax, plot
 }
 
 var templMMU = `<!doctype html>
@@ -345,7 +361,13 @@ var templMMU = `<!doctype html>
 
 // httpMMUDetails serves details of an MMU graph at a particular window.
 func (traceUI *TraceUI) httpMMUDetails(w http.ResponseWriter, r *http.Request) {
-	_, mmuCurve, err := traceUI.getMMUCurve(r)
+	ServeMMUDetails(w, r, traceUI.getMMUCurveByFlags, traceUI.ranges)
+}
+
+// ServeMMUDetails serves the worst windows of the curve got by curve at the window parameter,
+// each is linked to the trace range containing it. The times of the curve are relative to the trace start.
+func ServeMMUDetails(w http.ResponseWriter, r *http.Request, curve MMUCurveFunc, ranges []Range) {
+	_, mmuCurve, err := curve(mmuFlags(r))
 	if err != nil {
 		http.Error(w, fmt.Sprintf("failed to parse events: %v", err), http.StatusInternalServerError)
 		return
@@ -362,7 +384,7 @@ func (traceUI *TraceUI) httpMMUDetails(w http.ResponseWriter, r *http.Request) {
 	// Construct a link for each window.
 	var links []linkedUtilWindow
 	for _, ui := range worst {
-		links = append(links, newLinkedUtilWindow(traceUI, ui, time.Duration(window)))
+		links = append(links, linkedUtilWindow{ui, RangeURL(ranges, ui.Time, ui.Time+int64(window))})
 	}
 
 	err = json.NewEncoder(w).Encode(links)
@@ -376,14 +398,3 @@ type linkedUtilWindow struct {
 	trace.UtilWindow
 	URL string
 }
-
-func newLinkedUtilWindow(traceUI *TraceUI, ui trace.UtilWindow, window time.Duration) linkedUtilWindow {
-	// Find the range containing this window.
-	var r Range
-	for _, r = range traceUI.ranges {
-		if r.EndTime > ui.Time {
-			break
-		}
-	}
-	return linkedUtilWindow{ui, fmt.Sprintf("%s#%v:%v", r.URL(), float64(ui.Time)/1e6, float64(ui.Time+int64(window))/1e6)}
-}
@@ -7,36 +7,23 @@
 package traceui
 
 import (
-	"bufio"
+	"bytes"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
-	"path/filepath"
-	"runtime"
+	"net/url"
+	"os/exec"
+	"path"
 	"sort"
 	"strconv"
 	"time"
 
-	exec "cprofiler/pkg/internal/v1175/execabs"
-
+	"cprofiler/pkg/internal/pprofdriver"
 	"cprofiler/pkg/internal/v1175/trace"
 
 	"github.com/google/pprof/profile"
 )
 
-func goCmd() string {
-	var exeSuffix string
-	if runtime.GOOS == "windows" {
-		exeSuffix = ".exe"
-	}
-	path := filepath.Join(runtime.GOROOT(), "bin", "go"+exeSuffix)
-	if _, err := os.Stat(path); err == nil {
-		return path
-	}
-	return "go"
-}
-
 // Record represents one entry in pprof-like profiles.
 type Record struct {
 	stk  []*trace.Frame
@@ -49,7 +36,7 @@ type interval struct {
 	begin, end int64 // nanoseconds.
 }
 
-func (traceUI *TraceUI) pprofByGoroutine(compute func(io.Writer, map[uint64][]interval, []*trace.Event) error) func(w io.Writer, r *http.Request) error {
+func (traceUI *TraceUI) pprofByGoroutine(compute func(map[uint64][]interval, []*trace.Event) *profile.Profile) func(w io.Writer, r *http.Request) error {
 	return func(w io.Writer, r *http.Request) error {
 		id := r.FormValue("id")
 		events, err := traceUI.parseEvents()
@@ -60,11 +47,11 @@ func (traceUI *TraceUI) pprofByGoroutine(compute func(io.Writer, map[uint64][]in
 		if err != nil {
 			return err
 		}
-		return compute(w, gToIntervals, events)
+		return compute(gToIntervals, events).Write(w)
 	}
 }
 
-func (traceUI *TraceUI) pprofByRegion(compute func(io.Writer, map[uint64][]interval, []*trace.Event) error) func(w io.Writer, r *http.Request) error {
+func (traceUI *TraceUI) pprofByRegion(compute func(map[uint64][]interval, []*trace.Event) *profile.Profile) func(w io.Writer, r *http.Request) error {
 	return func(w io.Writer, r *http.Request) error {
 		filter, err := newRegionFilter(r)
 		if err != nil {
@@ -76,7 +63,7 @@ func (traceUI *TraceUI) pprofByRegion(compute func(io.Writer, map[uint64][]inter
 		}
 		events, _ := traceUI.parseEvents()
 
-		return compute(w, gToIntervals, events)
+		return compute(gToIntervals, events).Write(w)
 	}
 }
 
@@ -161,7 +148,7 @@ func (traceUI *TraceUI) pprofMatchingRegions(filter *regionFilter) (map[uint64][
 }
 
 // computePprofIO generates IO pprof-like profile (time spent in IO wait, currently only network blocking event).
-func computePprofIO(w io.Writer, gToIntervals map[uint64][]interval, events []*trace.Event) error {
+func computePprofIO(gToIntervals map[uint64][]interval, events []*trace.Event) *profile.Profile {
 	prof := make(map[uint64]Record)
 	for _, ev := range events {
 		if ev.Type != trace.EvGoBlockNet || ev.Link == nil || ev.StkID == 0 || len(ev.Stk) == 0 {
@@ -176,11 +163,11 @@ func computePprofIO(w io.Writer, gToIntervals map[uint64][]interval, events []*t
 			prof[ev.StkID] = rec
 		}
 	}
-	return buildProfile(prof).Write(w)
+	return buildProfile(prof)
 }
 
 // computePprofBlock generates blocking pprof-like profile (time spent blocked on synchronization primitives).
-func computePprofBlock(w io.Writer, gToIntervals map[uint64][]interval, events []*trace.Event) error {
+func computePprofBlock(gToIntervals map[uint64][]interval, events []*trace.Event) *profile.Profile {
 	prof := make(map[uint64]Record)
 	for _, ev := range events {
 		switch ev.Type {
@@ -204,11 +191,11 @@ func computePprofBlock(w io.Writer, gToIntervals map[uint64][]interval, events [
 			prof[ev.StkID] = rec
 		}
 	}
-	return buildProfile(prof).Write(w)
+	return buildProfile(prof)
 }
 
 // computePprofSyscall generates syscall pprof-like profile (time spent blocked in syscalls).
-func computePprofSyscall(w io.Writer, gToIntervals map[uint64][]interval, events []*trace.Event) error {
+func computePprofSyscall(gToIntervals map[uint64][]interval, events []*trace.Event) *profile.Profile {
 	prof := make(map[uint64]Record)
 	for _, ev := range events {
 		if ev.Type != trace.EvGoSysCall || ev.Link == nil || ev.StkID == 0 || len(ev.Stk) == 0 {
@@ -223,12 +210,12 @@ func computePprofSyscall(w io.Writer, gToIntervals map[uint64][]interval, events
 			prof[ev.StkID] = rec
 		}
 	}
-	return buildProfile(prof).Write(w)
+	return buildProfile(prof)
 }
 
 // computePprofSched generates scheduler latency pprof-like profile
 // (time between a goroutine become runnable and actually scheduled for execution).
-func computePprofSched(w io.Writer, gToIntervals map[uint64][]interval, events []*trace.Event) error {
+func computePprofSched(gToIntervals map[uint64][]interval, events []*trace.Event) *profile.Profile {
 	prof := make(map[uint64]Record)
 	for _, ev := range events {
 		if (ev.Type != trace.EvGoUnblock && ev.Type != trace.EvGoCreate) ||
@@ -244,7 +231,22 @@ func computePprofSched(w io.Writer, gToIntervals map[uint64][]interval, events [
 			prof[ev.StkID] = rec
 		}
 	}
-	return buildProfile(prof).Write(w)
+	return buildProfile(prof)
+}
+
+// PprofProfiles returns the network blocking, synchronization blocking, syscall blocking
+// and scheduler latency profiles of all goroutines, keyed by io, block, syscall and sched.
+func (traceUI *TraceUI) PprofProfiles() (map[string]*profile.Profile, error) {
+	events, err := traceUI.parseEvents()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]*profile.Profile{
+		"io":      computePprofIO(nil, events),
+		"block":   computePprofBlock(nil, events),
+		"syscall": computePprofSyscall(nil, events),
+		"sched":   computePprofSched(nil, events),
+	}, nil
 }
 
 // pprofOverlappingDuration returns the overlapping duration between
@@ -268,7 +270,13 @@ func pprofOverlappingDuration(gToIntervals map[uint64][]interval, ev *trace.Even
 	return overlapping
 }
 
-// serveSVGProfile serves pprof-like profile generated by prof as svg.
+// tracePprofKinds are the profiles of the whole trace served by the pprof UI under pprof/<kind>/
+var tracePprofKinds = map[string]bool{"io": true, "block": true, "syscall": true, "sched": true}
+
+// serveSVGProfile serves pprof-like profile generated by prof, rendered in-process by the pprof driver.
+// The output parameter is one of svg, dot, top and flame, the default is svg if graphviz is
+// installed or else the flame graph. The raw parameter serves the profile itself.
+// The flame graph of the whole trace redirects to the pprof UI, the filtered ones are served by the pprof flame graph page
 func serveSVGProfile(prof func(w io.Writer, r *http.Request) error) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
@@ -283,39 +291,58 @@ func serveSVGProfile(prof func(w io.Writer, r *http.Request) error) http.Handler
 			return
 		}
 
-		blockf, err := os.CreateTemp("", "block")
-		if err != nil {
-			http.Error(w, fmt.Sprintf("failed to create temp file: %v", err), http.StatusInternalServerError)
-			return
+		output := r.FormValue("output")
+		if output == "" {
+			output = "flame"
+			if _, err := exec.LookPath("dot"); err == nil {
+				output = "svg"
+			}
 		}
-		defer func() {
-			blockf.Close()
-			os.Remove(blockf.Name())
-		}()
-		blockb := bufio.NewWriter(blockf)
-		if err := prof(blockb, r); err != nil {
-			http.Error(w, fmt.Sprintf("failed to generate profile: %v", err), http.StatusInternalServerError)
+		if kind := path.Base(r.URL.Path); output == "flame" && tracePprofKinds[kind] && r.FormValue("id") == "" {
+			target := "pprof/" + kind + "/flamegraph"
+			if si := r.FormValue("si"); si != "" {
+				target += "?si=" + url.QueryEscape(si)
+			}
+			http.Redirect(w, r, target, http.StatusFound)
 			return
 		}
-		if err := blockb.Flush(); err != nil {
-			http.Error(w, fmt.Sprintf("failed to flush temp file: %v", err), http.StatusInternalServerError)
+
+		var buf bytes.Buffer
+		if err := prof(&buf, r); err != nil {
+			http.Error(w, fmt.Sprintf("failed to generate profile: %v", err), http.StatusInternalServerError)
 			return
 		}
-		if err := blockf.Close(); err != nil {
-			http.Error(w, fmt.Sprintf("failed to close temp file: %v", err), http.StatusInternalServerError)
+		p, err := profile.Parse(&buf)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to parse profile: %v", err), http.StatusInternalServerError)
 			return
 		}
-		svgFilename := blockf.Name() + ".svg"
-		if output, err := exec.Command(goCmd(), "tool", "pprof", "-svg", "-output", svgFilename, blockf.Name()).CombinedOutput(); err != nil {
-			http.Error(w, fmt.Sprintf("failed to execute go tool pprof: %v\n%s", err, output), http.StatusInternalServerError)
-			return
+
+		switch output {
+		case "flame":
+			if err := pprofdriver.FlameGraph(w, r, p); err != nil {
+				http.Error(w, fmt.Sprintf("failed to render profile: %v", err), http.StatusInternalServerError)
+			}
+		case "svg", "dot", "top":
+			var report bytes.Buffer
+			if err := pprofdriver.Render(&report, p, output); err != nil {
+				http.Error(w, fmt.Sprintf("failed to render profile: %v", err), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", profileContentTypes[output])
+			w.Write(report.Bytes())
+		default:
+			http.Error(w, fmt.Sprintf("unknown output %q", output), http.StatusBadRequest)
 		}
-		defer os.Remove(svgFilename)
-		w.Header().Set("Content-Type", "image/svg+xml")
-		http.ServeFile(w, r, svgFilename)
 	}
 }
 
+var profileContentTypes = map[string]string{
+	"svg": "image/svg+xml",
+	"dot": "text/vnd.graphviz; charset=utf-8",
+	"top": "text/plain; charset=utf-8",
+}
+
 func buildProfile(prof map[uint64]Record) *profile.Profile {
 	p := &profile.Profile{
 		PeriodType: &profile.ValueType{Type: "trace", Unit: "count"},
@@ -5,6 +5,8 @@
 package traceui
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -29,13 +31,18 @@ func (traceUI *TraceUI) httpTrace(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	TraceViewer(w, r)
+}
+
+// TraceViewer serves the trace viewer page, which loads the trace
+// from jsontrace with the same query parameters.
+func TraceViewer(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	html := strings.ReplaceAll(templTrace, "{{PARAMS}}", r.Form.Encode())
 	w.Write([]byte(html))
-
 }
 
 // https://chromium.googlesource.com/catapult/+/9508452e18f130c98499cb4c4f1e1efaedee8962/tracing/docs/embedding-trace-viewer.md
@@ -166,13 +173,14 @@ function onTraceViewerImportFail() {
 </html>
 `
 
-// httpTraceViewerHTML serves static part of trace-viewer.
+// TraceViewerHTML serves static part of trace-viewer.
 // This URL is queried from templTrace HTML.
-func httpTraceViewerHTML(w http.ResponseWriter, r *http.Request) {
+func TraceViewerHTML(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, "./pkg/internal/v1175/assets/trace_viewer_full")
 }
 
-func webcomponentsJS(w http.ResponseWriter, r *http.Request) {
+// WebcomponentsJS serves the webcomponents polyfill loaded by the trace viewer page.
+func WebcomponentsJS(w http.ResponseWriter, r *http.Request) {
 	path := filepath.Join("./pkg/internal/v1175/assets/webcomponents.min.js")
 	http.ServeFile(w, r, path)
 }
@@ -281,6 +289,20 @@ func (traceUI *TraceUI) httpJsonTrace(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// ExportJSON writes the whole trace in the Chrome Trace Event JSON format,
+// which can be opened in ui.perfetto.dev or chrome://tracing.
+func ExportJSON(w io.Writer, data []byte) error {
+	res, err := trace.Parse(bufio.NewReader(bytes.NewReader(data)), "")
+	if err != nil {
+		return fmt.Errorf("failed to parse trace: %v", err)
+	}
+	params := &traceParams{
+		parsed:  res,
+		endTime: math.MaxInt64,
+	}
+	return generateTrace(params, viewerDataTraceConsumer(w, 0, math.MaxInt64))
+}
+
 type Range struct {
 	Name      string
 	Start     int
@@ -289,8 +311,22 @@ type Range struct {
 	EndTime   int64
 }
 
+// URL returns the url of the trace viewer showing the range, relative to the trace UI.
 func (r Range) URL() string {
-	return fmt.Sprintf("/trace?start=%d&end=%d", r.Start, r.End)
+	return fmt.Sprintf("trace?start=%d&end=%d", r.Start, r.End)
+}
+
+// RangeURL returns the url of the trace viewer selecting [start, end] in nanoseconds since the trace start,
+// it shows the range containing start if the trace is split.
+func RangeURL(ranges []Range, start, end int64) string {
+	u := "trace"
+	for _, r := range ranges {
+		u = r.URL()
+		if r.EndTime > start {
+			break
+		}
+	}
+	return fmt.Sprintf("%s#%v:%v", u, float64(start)/1e6, float64(end)/1e6)
 }
 
 // splitTrace splits the trace into a number of ranges,
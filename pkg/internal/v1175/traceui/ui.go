@@ -51,7 +51,7 @@ func NewUI(data []byte) (*TraceUI, error) {
 
 	handlers := make(map[string]http.HandlerFunc)
 	handlers["/"] = traceUI.httpMain
-	handlers["/mmu"] = httpMMU
+	handlers["/mmu"] = MMU
 	handlers["/mmuPlot"] = traceUI.httpMMUPlot
 	handlers["/mmuDetails"] = traceUI.httpMMUDetails
 	handlers["/usertasks"] = traceUI.httpUserTasks
@@ -60,9 +60,8 @@ func NewUI(data []byte) (*TraceUI, error) {
 	handlers["/userregion"] = traceUI.httpUserRegion
 	handlers["/trace"] = traceUI.httpTrace
 	handlers["/jsontrace"] = traceUI.httpJsonTrace
-	handlers["/jsontrace"] = traceUI.httpJsonTrace
-	handlers["/trace_viewer_html"] = httpTraceViewerHTML
-	handlers["/webcomponents.min.js"] = webcomponentsJS
+	handlers["/trace_viewer_html"] = TraceViewerHTML
+	handlers["/webcomponents.min.js"] = WebcomponentsJS
 	handlers["/io"] = serveSVGProfile(traceUI.pprofByGoroutine(computePprofIO))
 	handlers["/block"] = serveSVGProfile(traceUI.pprofByGoroutine(computePprofBlock))
 	handlers["/syscall"] = serveSVGProfile(traceUI.pprofByGoroutine(computePprofSyscall))
@@ -73,6 +72,11 @@ func NewUI(data []byte) (*TraceUI, error) {
 	handlers["/regionsched"] = serveSVGProfile(traceUI.pprofByRegion(computePprofSched))
 	handlers["/goroutines"] = traceUI.httpGoroutines
 	handlers["/goroutine"] = traceUI.httpGoroutine
+	handlers["/json/goroutines"] = traceUI.httpGoroutinesJSON
+	handlers["/json/goroutine"] = traceUI.httpGoroutineJSON
+	handlers["/json/mmu"] = traceUI.httpMMUJSON
+	handlers["/json/usertasks"] = traceUI.httpUserTasksJSON
+	handlers["/json/userregions"] = traceUI.httpUserRegionsJSON
 
 	traceUI.Handlers = handlers
 	return traceUI, nil
@@ -126,14 +130,10 @@ var templMain = template.Must(template.New("").Parse(`
 <a href="userregions">User-defined regions</a><br>
 <a href="mmu">Minimum mutator utilization</a><br>
 
-<!-- <a href="io">Network blocking profile</a> -->
-(<a href="io?raw=1" download="io.profile">Network blocking profile⬇</a>)<br> 
-<!-- <a href="block">Synchronization blocking profile</a> -->
-(<a href="block?raw=1" download="block.profile">Synchronization blocking profile⬇</a>)<br> 
-<!-- <a href="syscall">Syscall blocking profile</a> --> 
-(<a href="syscall?raw=1" download="syscall.profile">Syscall blocking profile⬇</a>)<br> 
-<!-- <a href="sched">Scheduler latency profile</a> --> 
-(<a href="sche?raw=1" download="sched.profile">Scheduler latency profile⬇</a>)<br>
+<a href="pprof/io/">Network blocking profile</a> (<a href="io?raw=1" download="io.profile">⬇</a>)<br>
+<a href="pprof/block/">Synchronization blocking profile</a> (<a href="block?raw=1" download="block.profile">⬇</a>)<br>
+<a href="pprof/syscall/">Syscall blocking profile</a> (<a href="syscall?raw=1" download="syscall.profile">⬇</a>)<br>
+<a href="pprof/sched/">Scheduler latency profile</a> (<a href="sched?raw=1" download="sched.profile">⬇</a>)<br>
 
 </body>
 </html>
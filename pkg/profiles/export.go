@@ -0,0 +1,202 @@
+package profiles
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// WriteFolded Write the samples of p as the folded stacks of flamegraph.pl,
+// one line "root;caller;callee value" per stack, sorted by stack
+func WriteFolded(w io.Writer, p *profile.Profile, sampleIndex int) error {
+	values := make(map[string]int64)
+	for _, s := range p.Sample {
+		v := s.Value[sampleIndex]
+		if v == 0 {
+			continue
+		}
+		names := make([]string, 0, len(s.Location))
+		for i := len(s.Location) - 1; i >= 0; i-- {
+			for _, name := range locationNames(s.Location[i]) {
+				// ; separates the frames, and space separates the value
+				names = append(names, strings.NewReplacer(";", ":", " ", "_").Replace(name))
+			}
+		}
+		values[strings.Join(names, ";")] += v
+	}
+
+	stacks := make([]string, 0, len(values))
+	for stack := range values {
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+
+	bw := bufio.NewWriter(w)
+	for _, stack := range stacks {
+		if _, err := fmt.Fprintf(bw, "%s %d\n", stack, values[stack]); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ParseFolded Parse the folded stacks "root;caller;callee value" into a profile,
+// the values of a stack are the value multiplied by the scales of sampleTypes
+func ParseFolded(r io.Reader, sampleTypes []*profile.ValueType, scales []int64) (*profile.Profile, error) {
+	if len(sampleTypes) == 0 || len(sampleTypes) != len(scales) {
+		return nil, errors.New("sample types and scales mismatch")
+	}
+	p := &profile.Profile{SampleType: sampleTypes}
+
+	functions := make(map[string]*profile.Function)
+	location := func(name string) *profile.Location {
+		fn, ok := functions[name]
+		if !ok {
+			fn = &profile.Function{ID: uint64(len(p.Function) + 1), Name: name, SystemName: name}
+			functions[name] = fn
+			p.Function = append(p.Function, fn)
+			p.Location = append(p.Location, &profile.Location{ID: fn.ID, Line: []profile.Line{{Function: fn}}})
+		}
+		return p.Location[fn.ID-1]
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: no value", n)
+		}
+		v, err := strconv.ParseInt(line[i+1:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+
+		names := strings.Split(line[:i], ";")
+		sample := &profile.Sample{
+			Location: make([]*profile.Location, 0, len(names)),
+			Value:    make([]int64, len(scales)),
+		}
+		// The locations of a sample are from the leaf to the root
+		for j := len(names) - 1; j >= 0; j-- {
+			sample.Location = append(sample.Location, location(names[j]))
+		}
+		for j, scale := range scales {
+			sample.Value[j] = v * scale
+		}
+		p.Sample = append(p.Sample, sample)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return p, p.CheckValid()
+}
+
+// SpeedscopeSchema The schema of the speedscope file format
+const SpeedscopeSchema = "https://www.speedscope.app/file-format-schema.json"
+
+// Speedscope The speedscope file, https://github.com/jlfwong/speedscope/wiki/Importing-from-custom-sources
+type Speedscope struct {
+	Schema   string               `json:"$schema"`
+	Shared   SpeedscopeShared     `json:"shared"`
+	Profiles []*SpeedscopeProfile `json:"profiles"`
+	Name     string               `json:"name"`
+	Exporter string               `json:"exporter"`
+}
+
+type SpeedscopeShared struct {
+	Frames []*SpeedscopeFrame `json:"frames"`
+}
+
+type SpeedscopeFrame struct {
+	Name string `json:"name"`
+	File string `json:"file,omitempty"`
+	Line int64  `json:"line,omitempty"`
+}
+
+// SpeedscopeProfile A sampled profile of one sample type, the samples are the frame indexes from the root
+type SpeedscopeProfile struct {
+	Type       string  `json:"type"`
+	Name       string  `json:"name"`
+	Unit       string  `json:"unit"`
+	StartValue int64   `json:"startValue"`
+	EndValue   int64   `json:"endValue"`
+	Samples    [][]int `json:"samples"`
+	Weights    []int64 `json:"weights"`
+}
+
+// ToSpeedscope Convert p to the speedscope file, one profile per sample index
+func ToSpeedscope(p *profile.Profile, name string, sampleIndexes ...int) *Speedscope {
+	res := &Speedscope{
+		Schema:   SpeedscopeSchema,
+		Shared:   SpeedscopeShared{Frames: make([]*SpeedscopeFrame, 0)},
+		Profiles: make([]*SpeedscopeProfile, 0, len(sampleIndexes)),
+		Name:     name,
+		Exporter: "cprofiler",
+	}
+
+	frames := make(map[*profile.Function]int)
+	frameIndex := func(fn *profile.Function) int {
+		if i, ok := frames[fn]; ok {
+			return i
+		}
+		frame := &SpeedscopeFrame{Name: "unknown"}
+		if fn != nil {
+			frame = &SpeedscopeFrame{Name: fn.Name, File: fn.Filename, Line: fn.StartLine}
+		}
+		frames[fn] = len(res.Shared.Frames)
+		res.Shared.Frames = append(res.Shared.Frames, frame)
+		return frames[fn]
+	}
+
+	stacks := make([][]int, len(p.Sample))
+	for i, s := range p.Sample {
+		stacks[i] = make([]int, 0, len(s.Location))
+		for j := len(s.Location) - 1; j >= 0; j-- {
+			for _, fn := range locationFunctions(s.Location[j]) {
+				stacks[i] = append(stacks[i], frameIndex(fn))
+			}
+		}
+	}
+
+	for _, sampleIndex := range sampleIndexes {
+		sp := &SpeedscopeProfile{
+			Type:    "sampled",
+			Name:    p.SampleType[sampleIndex].Type,
+			Unit:    speedscopeUnit(p.SampleType[sampleIndex].Unit),
+			Samples: make([][]int, 0, len(p.Sample)),
+			Weights: make([]int64, 0, len(p.Sample)),
+		}
+		for i, s := range p.Sample {
+			v := s.Value[sampleIndex]
+			if v == 0 {
+				continue
+			}
+			sp.Samples = append(sp.Samples, stacks[i])
+			sp.Weights = append(sp.Weights, v)
+			sp.EndValue += v
+		}
+		res.Profiles = append(res.Profiles, sp)
+	}
+	return res
+}
+
+// speedscopeUnit Map the pprof unit to the speedscope unit
+func speedscopeUnit(unit string) string {
+	switch unit {
+	case "nanoseconds", "microseconds", "milliseconds", "seconds", "bytes":
+		return unit
+	default:
+		return "none"
+	}
+}
@@ -0,0 +1,64 @@
+package profiles
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFolded(t *testing.T) {
+	b := &bytes.Buffer{}
+	require.Equal(t, nil, WriteFolded(b, newStackProfile(), 1))
+	require.Equal(t, "main.main;main.a 10\nmain.main;main.a;main.c;main.b 20\nmain.main;main.b 30\n", b.String())
+
+	b.Reset()
+	require.Equal(t, nil, WriteFolded(b, newStackProfile(), 0))
+	require.Equal(t, "main.main 4\nmain.main;main.a 1\nmain.main;main.a;main.c;main.b 2\nmain.main;main.b 3\n", b.String())
+}
+
+func TestToSpeedscope(t *testing.T) {
+	res := ToSpeedscope(newStackProfile(), "test", 0, 1)
+	require.Equal(t, SpeedscopeSchema, res.Schema)
+	require.Equal(t, "test", res.Name)
+	names := make([]string, 0)
+	for _, frame := range res.Shared.Frames {
+		names = append(names, frame.Name)
+	}
+	require.Equal(t, []string{"main.main", "main.a", "main.c", "main.b"}, names)
+
+	require.Equal(t, 2, len(res.Profiles))
+	require.Equal(t, &SpeedscopeProfile{
+		Type:     "sampled",
+		Name:     "cpu",
+		Unit:     "nanoseconds",
+		EndValue: 60,
+		Samples:  [][]int{{0, 1}, {0, 1, 2, 3}, {0, 3}},
+		Weights:  []int64{10, 20, 30},
+	}, res.Profiles[1])
+	require.Equal(t, "none", res.Profiles[0].Unit)
+	require.Equal(t, 4, len(res.Profiles[0].Samples))
+}
+
+func TestParseFolded(t *testing.T) {
+	folded := "main.main;main.a 10\nmain.main;main.a;main.c;main.b 20\n\nmain.main;main.b 30\n"
+	p, err := ParseFolded(strings.NewReader(folded),
+		[]*profile.ValueType{{Type: "samples", Unit: "count"}, {Type: "cpu", Unit: "nanoseconds"}}, []int64{1, 1000})
+	require.Equal(t, nil, err)
+	require.Equal(t, 3, len(p.Sample))
+	require.Equal(t, 4, len(p.Function))
+	require.Equal(t, []int64{20, 20000}, p.Sample[1].Value)
+
+	b := &bytes.Buffer{}
+	require.Equal(t, nil, WriteFolded(b, p, 0))
+	require.Equal(t, "main.main;main.a 10\nmain.main;main.a;main.c;main.b 20\nmain.main;main.b 30\n", b.String())
+
+	_, err = ParseFolded(strings.NewReader("main.main"), []*profile.ValueType{{Type: "samples", Unit: "count"}}, []int64{1})
+	require.NotEqual(t, nil, err)
+	_, err = ParseFolded(strings.NewReader("main.main x"), []*profile.ValueType{{Type: "samples", Unit: "count"}}, []int64{1})
+	require.NotEqual(t, nil, err)
+	_, err = ParseFolded(strings.NewReader(folded), nil, nil)
+	require.NotEqual(t, nil, err)
+}
@@ -0,0 +1,125 @@
+package profiles
+
+import (
+	"regexp"
+	"sort"
+
+	"github.com/google/pprof/profile"
+)
+
+// FlameNode A function in the flame graph, the call stacks of the samples are merged from the root
+type FlameNode struct {
+	Name string
+	// Self The value of the samples whose leaf is the function
+	Self int64
+	// Total The value of the samples passing through the function
+	Total    int64
+	Children []*FlameNode `json:",omitempty"`
+}
+
+// FlameGraphOptions The options of building the flame graph
+type FlameGraphOptions struct {
+	// SampleIndex The index of the sample value
+	SampleIndex int
+	// Focus Keep only the samples with a function matching it, as pprof -focus
+	Focus *regexp.Regexp
+	// Ignore Drop the samples with a function matching it, as pprof -ignore
+	Ignore *regexp.Regexp
+	// NodeFraction Prune the nodes whose total is less than NodeFraction of the root total
+	NodeFraction float64
+	// MaxDepth Prune the nodes deeper than MaxDepth, 0 means no limit
+	MaxDepth int
+}
+
+// FlameGraph Build the flame graph of p, the root node named "root" sums all samples
+func FlameGraph(p *profile.Profile, opt FlameGraphOptions) *FlameNode {
+	if opt.Focus != nil || opt.Ignore != nil {
+		p = p.Copy()
+		p.FilterSamplesByName(opt.Focus, opt.Ignore, nil, nil)
+	}
+
+	root := &FlameNode{Name: "root"}
+	children := make(map[*FlameNode]map[string]*FlameNode)
+	child := func(parent *FlameNode, name string) *FlameNode {
+		if _, ok := children[parent]; !ok {
+			children[parent] = make(map[string]*FlameNode)
+		}
+		node, ok := children[parent][name]
+		if !ok {
+			node = &FlameNode{Name: name}
+			children[parent][name] = node
+			parent.Children = append(parent.Children, node)
+		}
+		return node
+	}
+
+	for _, s := range p.Sample {
+		v := s.Value[opt.SampleIndex]
+		if v == 0 {
+			continue
+		}
+		node := root
+		node.Total += v
+		// The locations are from the leaf to the root, and so are the inlined lines of a location
+		for i := len(s.Location) - 1; i >= 0; i-- {
+			for _, name := range locationNames(s.Location[i]) {
+				node = child(node, name)
+				node.Total += v
+			}
+		}
+		node.Self += v
+	}
+
+	prune(root, int64(opt.NodeFraction*float64(root.Total)), opt.MaxDepth, 0)
+	return root
+}
+
+// locationNames Return the function names of the location from the caller to the callee
+func locationNames(loc *profile.Location) []string {
+	fns := locationFunctions(loc)
+	names := make([]string, 0, len(fns))
+	for _, fn := range fns {
+		name := "unknown"
+		if fn != nil {
+			name = fn.Name
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// locationFunctions Return the functions of the location from the caller to the callee,
+// the unknown function is nil
+func locationFunctions(loc *profile.Location) []*profile.Function {
+	if len(loc.Line) == 0 {
+		return []*profile.Function{nil}
+	}
+	fns := make([]*profile.Function, 0, len(loc.Line))
+	for i := len(loc.Line) - 1; i >= 0; i-- {
+		fns = append(fns, loc.Line[i].Function)
+	}
+	return fns
+}
+
+// prune Drop the children with total less than minTotal or deeper than maxDepth, and sort the rest by name
+func prune(node *FlameNode, minTotal int64, maxDepth, depth int) {
+	if maxDepth > 0 && depth >= maxDepth {
+		node.Children = nil
+		return
+	}
+	kept := node.Children[:0]
+	for _, c := range node.Children {
+		if c.Total < minTotal {
+			continue
+		}
+		prune(c, minTotal, maxDepth, depth+1)
+		kept = append(kept, c)
+	}
+	node.Children = kept
+	if len(kept) == 0 {
+		node.Children = nil
+	}
+	sort.Slice(node.Children, func(i, j int) bool {
+		return node.Children[i].Name < node.Children[j].Name
+	})
+}
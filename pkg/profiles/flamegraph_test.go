@@ -0,0 +1,65 @@
+package profiles
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+)
+
+// newStackProfile main calls a and b, a calls c with b inlined in it
+func newStackProfile() *profile.Profile {
+	fnMain := &profile.Function{ID: 1, Name: "main.main"}
+	fnA := &profile.Function{ID: 2, Name: "main.a"}
+	fnB := &profile.Function{ID: 3, Name: "main.b"}
+	fnC := &profile.Function{ID: 4, Name: "main.c"}
+	locMain := &profile.Location{ID: 1, Line: []profile.Line{{Function: fnMain}}}
+	locA := &profile.Location{ID: 2, Line: []profile.Line{{Function: fnA}}}
+	locB := &profile.Location{ID: 3, Line: []profile.Line{{Function: fnB}}}
+	locCB := &profile.Location{ID: 4, Line: []profile.Line{{Function: fnB}, {Function: fnC}}}
+	return &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}, {Type: "cpu", Unit: "nanoseconds"}},
+		Function:   []*profile.Function{fnMain, fnA, fnB, fnC},
+		Location:   []*profile.Location{locMain, locA, locB, locCB},
+		Sample: []*profile.Sample{
+			{Location: []*profile.Location{locA, locMain}, Value: []int64{1, 10}},
+			{Location: []*profile.Location{locCB, locA, locMain}, Value: []int64{2, 20}},
+			{Location: []*profile.Location{locB, locMain}, Value: []int64{3, 30}},
+			{Location: []*profile.Location{locMain}, Value: []int64{4, 0}},
+		},
+	}
+}
+
+func TestFlameGraph(t *testing.T) {
+	p := newStackProfile()
+
+	root := FlameGraph(p, FlameGraphOptions{SampleIndex: 1})
+	require.Equal(t, &FlameNode{Name: "root", Total: 60, Children: []*FlameNode{
+		{Name: "main.main", Total: 60, Children: []*FlameNode{
+			{Name: "main.a", Self: 10, Total: 30, Children: []*FlameNode{
+				{Name: "main.c", Total: 20, Children: []*FlameNode{
+					{Name: "main.b", Self: 20, Total: 20},
+				}},
+			}},
+			{Name: "main.b", Self: 30, Total: 30},
+		}},
+	}}, root)
+
+	root = FlameGraph(p, FlameGraphOptions{SampleIndex: 0})
+	require.Equal(t, int64(10), root.Total)
+	require.Equal(t, int64(4), root.Children[0].Self)
+
+	// Focus and ignore
+	root = FlameGraph(p, FlameGraphOptions{SampleIndex: 1, Focus: regexp.MustCompile(`main\.c`)})
+	require.Equal(t, int64(20), root.Total)
+	root = FlameGraph(p, FlameGraphOptions{SampleIndex: 1, Ignore: regexp.MustCompile(`main\.a`)})
+	require.Equal(t, int64(30), root.Total)
+	require.Equal(t, 4, len(p.Sample))
+
+	// Prune
+	root = FlameGraph(p, FlameGraphOptions{SampleIndex: 1, NodeFraction: 0.4})
+	require.Equal(t, []*FlameNode{{Name: "main.a", Self: 10, Total: 30}, {Name: "main.b", Self: 30, Total: 30}}, root.Children[0].Children)
+	root = FlameGraph(p, FlameGraphOptions{SampleIndex: 1, MaxDepth: 1})
+	require.Equal(t, []*FlameNode{{Name: "main.main", Total: 60}}, root.Children)
+}
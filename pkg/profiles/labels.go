@@ -0,0 +1,123 @@
+package profiles
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"cprofiler/pkg/storage"
+
+	"github.com/google/pprof/profile"
+)
+
+// SampleLabels Return the distinct pprof sample labels (set by pprof.Do / pprof.SetGoroutineLabels),
+// sorted by key and value
+func SampleLabels(p *profile.Profile) []storage.Label {
+	seen := make(map[storage.Label]struct{})
+	labels := make([]storage.Label, 0)
+	for _, s := range p.Sample {
+		for key, values := range s.Label {
+			for _, value := range values {
+				label := storage.Label{Key: key, Value: value}
+				if _, ok := seen[label]; ok {
+					continue
+				}
+				seen[label] = struct{}{}
+				labels = append(labels, label)
+			}
+		}
+	}
+
+	sort.Slice(labels, func(i, j int) bool {
+		if labels[i].Key != labels[j].Key {
+			return labels[i].Key < labels[j].Key
+		}
+		return labels[i].Value < labels[j].Value
+	})
+	return labels
+}
+
+// FilterByLabels Return a copy of p keeping only the samples that carry all of labels
+func FilterByLabels(p *profile.Profile, labels []storage.Label) *profile.Profile {
+	res := p.Copy()
+	if len(labels) == 0 {
+		return res
+	}
+
+	samples := make([]*profile.Sample, 0, len(res.Sample))
+	for _, s := range res.Sample {
+		if hasLabels(s, labels) {
+			samples = append(samples, s)
+		}
+	}
+	res.Sample = samples
+	return res
+}
+
+// AggregateByLabel Sum the values of sample type sampleIndex grouped by label key and value
+// Samples without label key are summed under the empty value
+func AggregateByLabel(p *profile.Profile, sampleIndex int, keys ...string) map[string]map[string]int64 {
+	if len(keys) == 0 {
+		for _, label := range SampleLabels(p) {
+			keys = append(keys, label.Key)
+		}
+	}
+
+	res := make(map[string]map[string]int64, len(keys))
+	for _, key := range keys {
+		res[key] = make(map[string]int64)
+	}
+
+	for _, s := range p.Sample {
+		for key, values := range res {
+			labelValues := s.Label[key]
+			if len(labelValues) == 0 {
+				values[""] += s.Value[sampleIndex]
+				continue
+			}
+			for _, value := range labelValues {
+				values[value] += s.Value[sampleIndex]
+			}
+		}
+	}
+	return res
+}
+
+func hasLabels(s *profile.Sample, labels []storage.Label) bool {
+	for _, label := range labels {
+		found := false
+		for _, value := range s.Label[label.Key] {
+			if value == label.Value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// SampleIndex Return the index of sample type name in p
+// name is the sample type ("alloc_space") or the cprofiler sample type ("heap_alloc_space"),
+// the default sample type is used if name is empty
+func SampleIndex(p *profile.Profile, name string) (int, error) {
+	if len(p.SampleType) == 0 {
+		return 0, errors.New("sample type is nil")
+	}
+	if name == "" {
+		name = p.DefaultSampleType
+	}
+	if name == "" {
+		return len(p.SampleType) - 1, nil
+	}
+
+	for i, st := range p.SampleType {
+		if st.Type == name || strings.HasSuffix(name, "_"+st.Type) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("sample type %q not found", name)
+}
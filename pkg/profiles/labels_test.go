@@ -0,0 +1,84 @@
+package profiles
+
+import (
+	"testing"
+
+	"cprofiler/pkg/storage"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+)
+
+func newLabelProfile() *profile.Profile {
+	fn := &profile.Function{ID: 1, Name: "main.handle"}
+	loc := &profile.Location{ID: 1, Line: []profile.Line{{Function: fn}}}
+	return &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}, {Type: "cpu", Unit: "nanoseconds"}},
+		Function:   []*profile.Function{fn},
+		Location:   []*profile.Location{loc},
+		Sample: []*profile.Sample{
+			{Location: []*profile.Location{loc}, Value: []int64{1, 10}, Label: map[string][]string{"tenant": {"acme"}, "endpoint": {"/a"}}},
+			{Location: []*profile.Location{loc}, Value: []int64{2, 20}, Label: map[string][]string{"tenant": {"acme"}, "endpoint": {"/b"}}},
+			{Location: []*profile.Location{loc}, Value: []int64{3, 30}, Label: map[string][]string{"tenant": {"other"}}},
+			{Location: []*profile.Location{loc}, Value: []int64{4, 40}},
+		},
+	}
+}
+
+func TestSampleLabels(t *testing.T) {
+	labels := SampleLabels(newLabelProfile())
+	require.Equal(t, []storage.Label{
+		{Key: "endpoint", Value: "/a"},
+		{Key: "endpoint", Value: "/b"},
+		{Key: "tenant", Value: "acme"},
+		{Key: "tenant", Value: "other"},
+	}, labels)
+}
+
+func TestFilterByLabels(t *testing.T) {
+	p := newLabelProfile()
+
+	res := FilterByLabels(p, []storage.Label{{Key: "tenant", Value: "acme"}})
+	require.Equal(t, 2, len(res.Sample))
+	require.Equal(t, 4, len(p.Sample))
+	require.Equal(t, nil, res.CheckValid())
+
+	res = FilterByLabels(p, []storage.Label{{Key: "tenant", Value: "acme"}, {Key: "endpoint", Value: "/b"}})
+	require.Equal(t, 1, len(res.Sample))
+	require.Equal(t, int64(20), res.Sample[0].Value[1])
+
+	res = FilterByLabels(p, nil)
+	require.Equal(t, 4, len(res.Sample))
+}
+
+func TestAggregateByLabel(t *testing.T) {
+	p := newLabelProfile()
+
+	res := AggregateByLabel(p, 1, "tenant")
+	require.Equal(t, map[string]map[string]int64{
+		"tenant": {"acme": 30, "other": 30, "": 40},
+	}, res)
+
+	res = AggregateByLabel(p, 0)
+	require.Equal(t, 2, len(res))
+	require.Equal(t, map[string]int64{"/a": 1, "/b": 2, "": 7}, res["endpoint"])
+}
+
+func TestSampleIndex(t *testing.T) {
+	p := newLabelProfile()
+
+	i, err := SampleIndex(p, "")
+	require.Equal(t, nil, err)
+	require.Equal(t, 1, i)
+
+	i, err = SampleIndex(p, "samples")
+	require.Equal(t, nil, err)
+	require.Equal(t, 0, i)
+
+	i, err = SampleIndex(p, "profile_cpu")
+	require.Equal(t, nil, err)
+	require.Equal(t, 1, i)
+
+	_, err = SampleIndex(p, "alloc_space")
+	require.NotEqual(t, nil, err)
+}
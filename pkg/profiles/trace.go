@@ -0,0 +1,210 @@
+package profiles
+
+import (
+	"bufio"
+	"bytes"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"cprofiler/pkg/internal/tracev2"
+	"cprofiler/pkg/internal/v1175/trace"
+
+	xtrace "golang.org/x/exp/trace"
+)
+
+// TraceMetric A summary value extracted from an execution trace
+type TraceMetric struct {
+	Name  string
+	Unit  string
+	Value int64
+}
+
+// TraceSummary Summary metrics of an execution trace
+type TraceSummary struct {
+	// Duration The trace time span in nanoseconds
+	Duration int64
+	Metrics  []TraceMetric
+}
+
+// mmuFlags The GC work accounted by the summary MMU, same as the "all GC" line of the trace MMU page
+const mmuFlags = trace.UtilSTW | trace.UtilBackground | trace.UtilAssist | trace.UtilSweep
+
+// SummarizeTrace Parse the execution trace and compute GC count, total GC pause, max STW,
+// goroutine peak, MMU at 1ms/10ms windows and scheduler latency p99
+func SummarizeTrace(data []byte) (*TraceSummary, error) {
+	if tracev2.IsNewFormat(data) {
+		res, err := tracev2.Parse(data)
+		if err != nil {
+			return nil, err
+		}
+		return summarizeEventsV2(res.Events), nil
+	}
+	res, err := trace.Parse(bufio.NewReader(bytes.NewReader(data)), "")
+	if err != nil {
+		return nil, err
+	}
+	return summarizeEvents(res.Events), nil
+}
+
+func summarizeEvents(events []*trace.Event) *TraceSummary {
+	var gcCount, stwTotal, stwMax, goroutines, goroutinesPeak int64
+	schedLatencies := make([]int64, 0)
+
+	for _, ev := range events {
+		switch ev.Type {
+		case trace.EvGCStart:
+			gcCount++
+		case trace.EvGCSTWStart:
+			if ev.Link == nil {
+				continue
+			}
+			stw := ev.Link.Ts - ev.Ts
+			stwTotal += stw
+			if stw > stwMax {
+				stwMax = stw
+			}
+		case trace.EvGoCreate:
+			goroutines++
+			if goroutines > goroutinesPeak {
+				goroutinesPeak = goroutines
+			}
+			if ev.Link != nil {
+				schedLatencies = append(schedLatencies, ev.Link.Ts-ev.Ts)
+			}
+		case trace.EvGoEnd:
+			goroutines--
+		case trace.EvGoUnblock:
+			if ev.Link != nil {
+				schedLatencies = append(schedLatencies, ev.Link.Ts-ev.Ts)
+			}
+		}
+	}
+
+	summary := &TraceSummary{}
+	if len(events) > 0 {
+		summary.Duration = events[len(events)-1].Ts - events[0].Ts
+	}
+
+	var mmu1ms, mmu10ms float64
+	if utils := trace.MutatorUtilization(events, mmuFlags); len(utils) > 0 {
+		curve := trace.NewMMUCurve(utils)
+		mmu1ms = curve.MMU(time.Millisecond)
+		mmu10ms = curve.MMU(10 * time.Millisecond)
+	}
+
+	summary.Metrics = []TraceMetric{
+		{Name: "gc_count", Unit: "count", Value: gcCount},
+		{Name: "gc_pause_total", Unit: "nanoseconds", Value: stwTotal},
+		{Name: "stw_max", Unit: "nanoseconds", Value: stwMax},
+		{Name: "goroutines_peak", Unit: "count", Value: goroutinesPeak},
+		{Name: "mmu_1ms", Unit: "ppm", Value: toPPM(mmu1ms)},
+		{Name: "mmu_10ms", Unit: "ppm", Value: toPPM(mmu10ms)},
+		{Name: "sched_latency_p99", Unit: "nanoseconds", Value: percentile(schedLatencies, 0.99)},
+	}
+	return summary
+}
+
+// summarizeEventsV2 Same as summarizeEvents for the generation-based trace format
+func summarizeEventsV2(events []xtrace.Event) *TraceSummary {
+	var gcCount, stwTotal, stwMax, goroutines, goroutinesPeak int64
+	schedLatencies := make([]int64, 0)
+	stwStart := make(map[xtrace.ResourceID]int64)
+	runnableSince := make(map[xtrace.GoID]int64)
+
+	alive := func(s xtrace.GoState) bool {
+		return s != xtrace.GoNotExist && s != xtrace.GoUndetermined
+	}
+	for _, ev := range events {
+		ts := int64(ev.Time())
+		switch ev.Kind() {
+		case xtrace.EventRangeBegin:
+			r := ev.Range()
+			if r.Name == "GC concurrent mark phase" {
+				gcCount++
+			} else if strings.HasPrefix(r.Name, "stop-the-world") {
+				stwStart[r.Scope] = ts
+			}
+		case xtrace.EventRangeEnd:
+			r := ev.Range()
+			start, ok := stwStart[r.Scope]
+			if !ok || !strings.HasPrefix(r.Name, "stop-the-world") {
+				continue
+			}
+			delete(stwStart, r.Scope)
+			stw := ts - start
+			stwTotal += stw
+			if stw > stwMax {
+				stwMax = stw
+			}
+		case xtrace.EventStateTransition:
+			st := ev.StateTransition()
+			if st.Resource.Kind != xtrace.ResourceGoroutine {
+				continue
+			}
+			id := st.Resource.Goroutine()
+			from, to := st.Goroutine()
+			if alive(from) != alive(to) {
+				if alive(to) {
+					goroutines++
+				} else {
+					goroutines--
+				}
+				if goroutines > goroutinesPeak {
+					goroutinesPeak = goroutines
+				}
+			}
+			// Goroutines which were already runnable at the trace start have no known latency
+			if to == xtrace.GoRunnable && from != xtrace.GoUndetermined {
+				runnableSince[id] = ts
+			} else if to == xtrace.GoRunning {
+				if since, ok := runnableSince[id]; ok {
+					schedLatencies = append(schedLatencies, ts-since)
+				}
+				delete(runnableSince, id)
+			}
+		}
+	}
+
+	summary := &TraceSummary{}
+	if len(events) > 0 {
+		summary.Duration = int64(events[len(events)-1].Time() - events[0].Time())
+	}
+
+	var mmu1ms, mmu10ms float64
+	if utils := tracev2.MutatorUtilization(events, mmuFlags); len(utils) > 0 {
+		curve := trace.NewMMUCurve(utils)
+		mmu1ms = curve.MMU(time.Millisecond)
+		mmu10ms = curve.MMU(10 * time.Millisecond)
+	}
+
+	summary.Metrics = []TraceMetric{
+		{Name: "gc_count", Unit: "count", Value: gcCount},
+		{Name: "gc_pause_total", Unit: "nanoseconds", Value: stwTotal},
+		{Name: "stw_max", Unit: "nanoseconds", Value: stwMax},
+		{Name: "goroutines_peak", Unit: "count", Value: goroutinesPeak},
+		{Name: "mmu_1ms", Unit: "ppm", Value: toPPM(mmu1ms)},
+		{Name: "mmu_10ms", Unit: "ppm", Value: toPPM(mmu10ms)},
+		{Name: "sched_latency_p99", Unit: "nanoseconds", Value: percentile(schedLatencies, 0.99)},
+	}
+	return summary
+}
+
+// toPPM Convert the utilization in [0, 1] to parts per million, ProfileMeta.Value is an integer
+func toPPM(util float64) int64 {
+	return int64(math.Round(util * 1e6))
+}
+
+// percentile Return the nearest-rank percentile p (0, 1] of values
+func percentile(values []int64, p float64) int64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	rank := int(math.Ceil(p*float64(len(values)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return values[rank]
+}
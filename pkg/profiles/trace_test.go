@@ -0,0 +1,66 @@
+package profiles
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummarizeTrace(t *testing.T) {
+	data, err := ioutil.ReadFile("../apiserver/testdata/trace.out.testdata")
+	require.Equal(t, nil, err)
+
+	summary, err := SummarizeTrace(data)
+	require.Equal(t, nil, err)
+	require.Equal(t, true, summary.Duration > 0)
+
+	metrics := make(map[string]int64)
+	for _, m := range summary.Metrics {
+		metrics[m.Name] = m.Value
+	}
+	require.Equal(t, 7, len(metrics))
+	require.Equal(t, true, metrics["goroutines_peak"] > 0)
+	require.Equal(t, true, metrics["stw_max"] <= metrics["gc_pause_total"])
+	require.Equal(t, true, metrics["mmu_1ms"] >= 0 && metrics["mmu_1ms"] <= 1e6)
+	require.Equal(t, true, metrics["mmu_1ms"] <= metrics["mmu_10ms"])
+	require.Equal(t, true, metrics["sched_latency_p99"] >= 0)
+
+	_, err = SummarizeTrace([]byte("haha"))
+	require.NotEqual(t, nil, err)
+}
+
+func TestPercentile(t *testing.T) {
+	require.Equal(t, int64(0), percentile(nil, 0.99))
+	require.Equal(t, int64(5), percentile([]int64{5}, 0.99))
+
+	values := make([]int64, 0, 100)
+	for i := int64(100); i > 0; i-- {
+		values = append(values, i)
+	}
+	require.Equal(t, int64(99), percentile(values, 0.99))
+	require.Equal(t, int64(50), percentile(values, 0.5))
+}
+
+func TestSummarizeTraceNewFormat(t *testing.T) {
+	data, err := ioutil.ReadFile("../apiserver/testdata/trace_go126.out.testdata")
+	require.Equal(t, nil, err)
+
+	summary, err := SummarizeTrace(data)
+	require.Equal(t, nil, err)
+	require.Equal(t, true, summary.Duration > 0)
+
+	metrics := make(map[string]int64)
+	for _, m := range summary.Metrics {
+		metrics[m.Name] = m.Value
+	}
+	require.Equal(t, 7, len(metrics))
+	// The test program calls runtime.GC and runs 20 goroutines at once
+	require.Equal(t, true, metrics["gc_count"] >= 1)
+	require.Equal(t, true, metrics["mmu_1ms"] >= 0 && metrics["mmu_1ms"] < 1e6)
+	require.Equal(t, true, metrics["mmu_1ms"] <= metrics["mmu_10ms"])
+	require.Equal(t, true, metrics["goroutines_peak"] >= 20)
+	require.Equal(t, true, metrics["stw_max"] > 0)
+	require.Equal(t, true, metrics["stw_max"] <= metrics["gc_pause_total"])
+	require.Equal(t, true, metrics["sched_latency_p99"] > 0)
+}
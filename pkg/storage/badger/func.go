@@ -2,6 +2,7 @@ package badger
 
 import (
 	"bytes"
+	"compress/gzip"
 	"time"
 
 	"cprofiler/pkg/storage"
@@ -19,12 +20,14 @@ var (
 	PrefixTarget      = []byte{0x84}
 	PrefixLabel       = []byte{0x85}
 	PrefixIndex       = []byte{0x86}
+
+	SchemaVersionKey = []byte{0x87}
 )
 
 // JobLabel 内置label
-const JobLabel = "_job"
-const HostLabel = "_host"
-const AppLabel = "_app"
+const JobLabel = storage.JobLabel
+const HostLabel = storage.HostLabel
+const AppLabel = storage.AppLabel
 
 func deletePrefixKey(key []byte) string {
 	return string(key[1:])
@@ -95,12 +98,23 @@ func buildIndexKey(sampleType, key, val string, createAt *time.Time, id *string)
 	return buf.Bytes()
 }
 
-func newProfileEntry(id string, val []byte, ttl time.Duration) *badger.Entry {
-	entry := badger.NewEntry(buildProfileKey(id), val)
+// newProfileEntry Profile binaries are gzip compressed, the gzip header carries the profile name
+func newProfileEntry(id, name string, data []byte, ttl time.Duration) (*badger.Entry, error) {
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	gzipWriter.Name = name
+	if _, err := gzipWriter.Write(data); err != nil {
+		return nil, err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return nil, err
+	}
+
+	entry := badger.NewEntry(buildProfileKey(id), buf.Bytes())
 	if ttl > 0 {
 		entry = entry.WithTTL(ttl)
 	}
-	return entry
+	return entry, nil
 }
 
 func newProfileMetaEntry(id string, meta *storage.ProfileMeta, ttl time.Duration) (*badger.Entry, error) {
@@ -0,0 +1,129 @@
+package badger
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"time"
+
+	"cprofiler/pkg/storage"
+
+	"github.com/dgraph-io/badger/v3"
+	log "github.com/sirupsen/logrus"
+)
+
+// schemaVersion The on-disk layout version written by this store
+// 0: index time is a local RFC3339 string
+// 1: index time is big-endian UTC unix nanoseconds
+const schemaVersion = 1
+
+// migrate Upgrade the data written by an older store to schemaVersion
+func (s *store) migrate() error {
+	version, err := s.getSchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	if version == schemaVersion {
+		return nil
+	}
+
+	log.WithFields(log.Fields{"from": version, "to": schemaVersion}).Info("store migrate start")
+	if version < 1 {
+		if err = s.rebuildIndex(); err != nil {
+			return err
+		}
+	}
+
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(SchemaVersionKey, []byte(strconv.Itoa(schemaVersion)))
+	})
+}
+
+func (s *store) getSchemaVersion() (int, error) {
+	var version int
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(SchemaVersionKey)
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			version, err = strconv.Atoi(string(val))
+			return err
+		})
+	})
+
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return 0, nil
+	}
+	return version, err
+}
+
+// rebuildIndex Delete all index entries and rebuild them from the profile metas
+func (s *store) rebuildIndex() error {
+	wb := s.db.NewWriteBatch()
+	defer wb.Cancel()
+
+	deleted, created := 0, 0
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = PrefixIndex
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(PrefixIndex); it.Valid(); it.Next() {
+			k := it.Item().KeyCopy(nil)
+			// PrefixIndex shares its byte with MetaSequence
+			if bytes.Equal(k, MetaSequence) {
+				continue
+			}
+			if err := wb.Delete(k); err != nil {
+				return err
+			}
+			deleted++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchSize = 1000
+		opts.Prefix = PrefixProfileMeta
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(PrefixProfileMeta); it.Valid(); it.Next() {
+			item := it.Item()
+			id := deletePrefixKey(item.Key())
+			meta := &storage.ProfileMeta{}
+			if err := item.Value(meta.Decode); err != nil {
+				return err
+			}
+
+			createAt := time.Unix(0, meta.Timestamp*time.Millisecond.Nanoseconds())
+			for _, l := range indexLabels(meta) {
+				entry := badger.NewEntry(buildIndexKey(meta.SampleType, l.Key, l.Value, &createAt, &id), nil)
+				entry.ExpiresAt = item.ExpiresAt()
+				if err := wb.SetEntry(entry); err != nil {
+					return err
+				}
+				created++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = wb.Flush(); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{"deleted": deleted, "created": created}).Info("store rebuild index")
+	return nil
+}
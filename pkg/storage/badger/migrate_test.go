@@ -0,0 +1,78 @@
+package badger
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"cprofiler/pkg/storage"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	defer os.RemoveAll(dir)
+	require.Equal(t, nil, err)
+
+	createAt := time.Now().Add(-1 * time.Minute)
+	meta := &storage.ProfileMeta{
+		Timestamp:   createAt.UnixNano() / time.Millisecond.Nanoseconds(),
+		SampleType:  "heap_inuse_space",
+		ProfileType: "heap",
+		JobName:     "profiler-server",
+		Host:        "127.0.0.1:9000",
+		Labels:      []storage.Label{{Key: "env", Value: "test"}},
+	}
+
+	// Write meta and index with the version 0 layout
+	db, err := badger.Open(badger.DefaultOptions(dir).WithLoggingLevel(3))
+	require.Equal(t, nil, err)
+	oldIndexKey := append(buildIndexKey(meta.SampleType, "env", "test", nil, nil), []byte(createAt.Local().Format(time.RFC3339)+"1")...)
+	err = db.Update(func(txn *badger.Txn) error {
+		entry, err := newProfileMetaEntry("1", meta, time.Hour)
+		if err != nil {
+			return err
+		}
+		if err = txn.SetEntry(entry); err != nil {
+			return err
+		}
+		return txn.Set(oldIndexKey, nil)
+	})
+	require.Equal(t, nil, err)
+	require.Equal(t, nil, db.Close())
+
+	s := NewStore(DefaultOptions(dir))
+	defer s.Release()
+
+	version, err := s.(*store).getSchemaVersion()
+	require.Equal(t, nil, err)
+	require.Equal(t, schemaVersion, version)
+
+	err = s.(*store).db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(oldIndexKey)
+		return err
+	})
+	require.Equal(t, badger.ErrKeyNotFound, err)
+
+	min := time.Now().Add(-1 * time.Hour)
+	max := time.Now()
+	for _, filter := range []storage.LabelFilter{
+		{Label: storage.Label{Key: "env", Value: "test"}},
+		{Label: storage.Label{Key: JobLabel, Value: "profiler-server"}},
+		{Label: storage.Label{Key: HostLabel, Value: "127.0.0.1:9000"}},
+	} {
+		targets, err := s.ListProfileMeta("heap_inuse_space", min, max, filter)
+		require.Equal(t, nil, err)
+		require.Equal(t, 1, len(targets))
+		require.Equal(t, 1, len(targets[0].ProfileMetas))
+	}
+
+	targets, err := s.ListProfileMeta("heap_inuse_space", min, createAt.Add(-1*time.Millisecond), storage.LabelFilter{
+		Label: storage.Label{Key: "env", Value: "test"},
+	})
+	require.Equal(t, nil, err)
+	require.Equal(t, 0, len(targets))
+}
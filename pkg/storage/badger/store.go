@@ -47,6 +47,10 @@ func NewStore(opt Options) storage.Store {
 		panic(err)
 	}
 
+	if err = s.migrate(); err != nil {
+		panic(err)
+	}
+
 	go s.GC()
 
 	return s
@@ -88,6 +92,9 @@ func (s *store) GetProfile(id string) (string, []byte, error) {
 	if errors.Is(err, badger.ErrKeyNotFound) {
 		return "", nil, storage.ErrProfileNotFound
 	}
+	if err != nil {
+		return "", nil, err
+	}
 
 	buf := bytes.NewBuffer(data)
 	gzipReader, err := gzip.NewReader(buf)
@@ -103,80 +110,165 @@ func (s *store) GetProfile(id string) (string, []byte, error) {
 }
 
 func (s *store) SaveProfile(name string, profileData []byte, ttl time.Duration) (string, error) {
-	id, err := s.profileSeq.Next()
+	id, err := s.nextProfileID()
+	if err != nil {
+		return "", err
+	}
+
+	entry, err := newProfileEntry(id, name, profileData, ttl)
 	if err != nil {
 		return "", err
 	}
-	idStr := strconv.FormatUint(id, 10)
+
 	err = s.db.Update(func(txn *badger.Txn) error {
-		return txn.SetEntry(newProfileEntry(idStr, profileData, ttl))
+		return txn.SetEntry(entry)
 	})
 
-	return idStr, err
+	return id, err
 }
 
 func (s *store) SaveProfileMeta(metas []*storage.ProfileMeta, ttl time.Duration) error {
 	err := s.db.Update(func(txn *badger.Txn) error {
+		return s.saveProfileMeta(txn, metas, ttl)
+	})
+	return err
+}
 
-		now := time.Now()
-		for _, meta := range metas {
-			id, err := s.metaSeq.Next()
-			if err != nil {
-				return err
-			}
-			idStr := strconv.FormatUint(id, 10)
+func (s *store) SaveProfileWithMeta(name string, profileData []byte, metas []*storage.ProfileMeta, ttl time.Duration) (string, error) {
+	ids, err := s.SaveProfilesWithMeta([]*storage.ProfileWithMeta{{Name: name, Data: profileData, Metas: metas, TTL: ttl}})
+	if err != nil {
+		return "", err
+	}
+	return ids[0], nil
+}
 
-			var profileMetaEntry *badger.Entry
-			if profileMetaEntry, err = newProfileMetaEntry(idStr, meta, ttl); err != nil {
+func (s *store) SaveProfilesWithMeta(profiles []*storage.ProfileWithMeta) ([]string, error) {
+	ids := make([]string, 0, len(profiles))
+	entries := make([]*badger.Entry, 0, len(profiles))
+	for _, p := range profiles {
+		id, err := s.nextProfileID()
+		if err != nil {
+			return nil, err
+		}
+
+		entry, err := newProfileEntry(id, p.Name, p.Data, p.TTL)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, meta := range p.Metas {
+			meta.ProfileID = id
+		}
+		ids = append(ids, id)
+		entries = append(entries, entry)
+	}
+
+	err := s.db.Update(func(txn *badger.Txn) error {
+		for i, p := range profiles {
+			if err := txn.SetEntry(entries[i]); err != nil {
 				return err
 			}
-			if err = txn.SetEntry(profileMetaEntry); err != nil {
+			if err := s.saveProfileMeta(txn, p.Metas, p.TTL); err != nil {
 				return err
 			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-			if err = txn.SetEntry(newSampleTypeEntry(meta.SampleType, ttl)); err != nil {
+	return ids, nil
+}
+
+func (s *store) nextProfileID() (string, error) {
+	id, err := s.profileSeq.Next()
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatUint(id, 10), nil
+}
+
+// saveProfileMeta Write metas, sample types, targets, labels and indexes in txn
+func (s *store) saveProfileMeta(txn *badger.Txn, metas []*storage.ProfileMeta, ttl time.Duration) error {
+	now := time.Now()
+	for _, meta := range metas {
+		id, err := s.metaSeq.Next()
+		if err != nil {
+			return err
+		}
+		idStr := strconv.FormatUint(id, 10)
+
+		var profileMetaEntry *badger.Entry
+		if profileMetaEntry, err = newProfileMetaEntry(idStr, meta, ttl); err != nil {
+			return err
+		}
+		if err = txn.SetEntry(profileMetaEntry); err != nil {
+			return err
+		}
+
+		if err = txn.SetEntry(newSampleTypeEntry(meta.SampleType, ttl)); err != nil {
+			return err
+		}
+
+		if err = txn.SetEntry(newTargetEntry(meta.Host, ttl)); err != nil {
+			return err
+		}
+
+		meta.Labels = indexLabels(meta)
+
+		labelEnters := newLabelEntry(meta.Labels, ttl)
+		for _, entry := range labelEnters {
+			if err = txn.SetEntry(entry); err != nil {
 				return err
 			}
+		}
 
-			if err = txn.SetEntry(newTargetEntry(meta.Host, ttl)); err != nil {
+		// Index by the time the profile is scraped, a forwarded profile may be saved long after it
+		createAt := now
+		if meta.Timestamp > 0 {
+			createAt = time.Unix(0, meta.Timestamp*time.Millisecond.Nanoseconds())
+		}
+		indexEnters := newIndexEntry(meta.SampleType, meta.Labels, idStr, createAt, ttl)
+		for _, entry := range indexEnters {
+			if err = txn.SetEntry(entry); err != nil {
 				return err
 			}
+		}
+	}
+	return nil
+}
 
-			// 添加默认target Index
-			meta.Labels = append(meta.Labels, storage.Label{
-				Key:   JobLabel,
-				Value: meta.JobName,
-			})
+// indexLabels Return meta labels with the built-in job, host and app labels and the pprof sample labels
+func indexLabels(meta *storage.ProfileMeta) []storage.Label {
+	labels := make([]storage.Label, 0, len(meta.Labels)+len(meta.SampleLabels)+3)
+	labels = append(labels, meta.Labels...)
 
-			// 添加默认host Index
-			meta.Labels = append(meta.Labels, storage.Label{
-				Key:   HostLabel,
-				Value: meta.Host,
-			})
+	for _, l := range meta.SampleLabels {
+		labels = append(labels, storage.Label{
+			Key:   storage.SampleLabelKey(l.Key),
+			Value: l.Value,
+		})
+	}
 
-			// 添加默认app Index
-			meta.Labels = append(meta.Labels, storage.Label{
-				Key:   AppLabel,
-				Value: meta.App,
-			})
+	// 添加默认target Index
+	labels = append(labels, storage.Label{
+		Key:   JobLabel,
+		Value: meta.JobName,
+	})
 
-			labelEnters := newLabelEntry(meta.Labels, ttl)
-			for _, entry := range labelEnters {
-				if err = txn.SetEntry(entry); err != nil {
-					return err
-				}
-			}
+	// 添加默认host Index
+	labels = append(labels, storage.Label{
+		Key:   HostLabel,
+		Value: meta.Host,
+	})
 
-			indexEnters := newIndexEntry(meta.SampleType, meta.Labels, idStr, now, ttl)
-			for _, entry := range indexEnters {
-				if err = txn.SetEntry(entry); err != nil {
-					return err
-				}
-			}
-		}
-		return nil
+	// 添加默认app Index
+	labels = append(labels, storage.Label{
+		Key:   AppLabel,
+		Value: meta.App,
 	})
-	return err
+	return labels
 }
 
 func (s *store) ListProfileMeta(sampleType string, startTime, endTime time.Time, filters ...storage.LabelFilter) ([]*storage.ProfileMetaByTarget, error) {
@@ -245,7 +337,7 @@ func (s *store) ListProfileMeta(sampleType string, startTime, endTime time.Time,
 func (s *store) searchProfileMeta(sampleType string, filters []storage.LabelFilter, startTime, endTime time.Time) ([]string, error) {
 	ids := make([]string, 0)
 	err := s.db.View(func(txn *badger.Txn) error {
-		for _, filter := range filters {
+		for i, filter := range filters {
 			func() {
 
 				idsByLabel := make([]string, 0)
@@ -270,7 +362,7 @@ func (s *store) searchProfileMeta(sampleType string, filters []storage.LabelFilt
 					id := string(k[len(min):])
 					idsByLabel = append(idsByLabel, id)
 				}
-				if len(ids) == 0 {
+				if i == 0 {
 					ids = idsByLabel
 				} else {
 					ids = filter.Policy(ids, idsByLabel)
@@ -336,7 +428,7 @@ func (s *store) ListLabel() ([]storage.Label, error) {
 		for it.Seek(PrefixLabel); it.Valid(); it.Next() {
 			item := it.Item()
 			k := item.Key()
-			s := strings.Split(deletePrefixKey(k), "=")
+			s := strings.SplitN(deletePrefixKey(k), "=", 2)
 			labels = append(labels, storage.Label{
 				Key:   s[0],
 				Value: s[1],
@@ -3,6 +3,7 @@ package badger
 import (
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -41,6 +42,7 @@ var (
 			SampleType:     "heap_alloc_objects",
 			ProfileType:    "heap",
 			JobName:        "profiler-server",
+			Host:           "127.0.0.1:9000",
 			Value:          100,
 			Labels: []storage.Label{{
 				Key:   "env",
@@ -55,6 +57,7 @@ var (
 			SampleType:     "heap_alloc_space",
 			ProfileType:    "heap",
 			JobName:        "profiler-server",
+			Host:           "127.0.0.1:9000",
 			Value:          200,
 			Labels: []storage.Label{{
 				Key:   "env",
@@ -69,6 +72,7 @@ var (
 			SampleType:     "heap_inuse_objects",
 			ProfileType:    "heap",
 			JobName:        "server2",
+			Host:           "127.0.0.1:9001",
 			Value:          300,
 			Labels: []storage.Label{{
 				Key:   "env",
@@ -83,6 +87,7 @@ var (
 			SampleType:     "heap_inuse_space",
 			ProfileType:    "heap",
 			JobName:        "server2",
+			Host:           "127.0.0.1:9001",
 			Value:          400,
 			Labels: []storage.Label{{
 				Key:   "env",
@@ -97,6 +102,7 @@ var (
 			SampleType:     "heap_inuse_space",
 			ProfileType:    "heap",
 			JobName:        "server3",
+			Host:           "127.0.0.1:9002",
 			Value:          400,
 			Labels: []storage.Label{{
 				Key:   "env",
@@ -150,6 +156,90 @@ func TestProfile(t *testing.T) {
 	require.NotEqual(t, nil, err)
 }
 
+func TestProfileWithMeta(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	defer os.RemoveAll(dir)
+	require.Equal(t, nil, err)
+	s := NewStore(DefaultOptions(dir))
+	defer s.Release()
+	require.NotEqual(t, nil, s)
+
+	metas := []*storage.ProfileMeta{
+		{
+			SampleType:  "trace",
+			ProfileType: "trace",
+			JobName:     "profiler-server",
+			Host:        "127.0.0.1:9000",
+		},
+	}
+	id, err := s.SaveProfileWithMeta("profiler-server-trace", []byte("trace"), metas, 3*time.Second)
+	require.Equal(t, nil, err)
+
+	name, data, err := s.GetProfile(id)
+	require.Equal(t, nil, err)
+	require.Equal(t, "profiler-server-trace", name)
+	require.Equal(t, []byte("trace"), data)
+
+	min := time.Now().Add(-1 * time.Hour)
+	max := time.Now().Add(time.Second)
+	targets, err := s.ListProfileMeta("trace", min, max)
+	require.Equal(t, nil, err)
+	require.Equal(t, 1, len(targets))
+	require.Equal(t, 1, len(targets[0].ProfileMetas))
+	require.Equal(t, id, targets[0].ProfileMetas[0].ProfileID)
+
+	// Waiting for the overdue
+	time.Sleep(3 * time.Second)
+	_, _, err = s.GetProfile(id)
+	require.NotEqual(t, nil, err)
+
+	targets, err = s.ListProfileMeta("trace", min, max)
+	require.Equal(t, nil, err)
+	require.Equal(t, 0, len(targets))
+}
+
+func TestProfilesWithMeta(t *testing.T) {
+	dir, err := ioutil.TempDir("./", "temp-*")
+	defer os.RemoveAll(dir)
+	require.Equal(t, nil, err)
+	s := NewStore(DefaultOptions(dir))
+	defer s.Release()
+
+	newProfile := func(job string) *storage.ProfileWithMeta {
+		return &storage.ProfileWithMeta{
+			Name:  job + "-cpu",
+			Data:  []byte(job),
+			Metas: []*storage.ProfileMeta{{SampleType: "cpu", ProfileType: "cpu", JobName: job, Host: "127.0.0.1:9000"}},
+			TTL:   time.Hour,
+		}
+	}
+	min := time.Now().Add(-1 * time.Hour)
+	max := time.Now().Add(time.Second)
+
+	// None of the profiles is saved if one of them fails
+	invalid := newProfile("invalid")
+	invalid.Metas[0].Labels = []storage.Label{{Key: "long", Value: strings.Repeat("x", 2000)}}
+	_, err = s.SaveProfilesWithMeta([]*storage.ProfileWithMeta{newProfile("a"), invalid})
+	require.NotEqual(t, nil, err)
+	targets, err := s.ListProfileMeta("cpu", min, max)
+	require.Equal(t, nil, err)
+	require.Equal(t, 0, len(targets))
+
+	ids, err := s.SaveProfilesWithMeta([]*storage.ProfileWithMeta{newProfile("a"), newProfile("b")})
+	require.Equal(t, nil, err)
+	require.Equal(t, 2, len(ids))
+	for i, job := range []string{"a", "b"} {
+		name, data, err := s.GetProfile(ids[i])
+		require.Equal(t, nil, err)
+		require.Equal(t, job+"-cpu", name)
+		require.Equal(t, []byte(job), data)
+	}
+	targets, err = s.ListProfileMeta("cpu", min, max)
+	require.Equal(t, nil, err)
+	require.Equal(t, 1, len(targets))
+	require.Equal(t, 2, len(targets[0].ProfileMetas))
+}
+
 func TestProfileMeta(t *testing.T) {
 	dir, err := ioutil.TempDir("./", "temp-*")
 	defer os.RemoveAll(dir)
@@ -161,7 +251,7 @@ func TestProfileMeta(t *testing.T) {
 	var filters = []storage.LabelFilter{
 		{
 			Label: storage.Label{
-				Key:   "_target",
+				Key:   JobLabel,
 				Value: "profiler-server",
 			},
 		},
@@ -235,19 +325,19 @@ func TestProfileMetaArray(t *testing.T) {
 	var filters = []storage.LabelFilter{
 		{
 			Label: storage.Label{
-				Key:   "_target",
+				Key:   JobLabel,
 				Value: "profiler-server",
 			},
 		},
 		{
 			Label: storage.Label{
-				Key:   "_target",
+				Key:   JobLabel,
 				Value: "server2",
 			},
 		},
 		{
 			Label: storage.Label{
-				Key:   "_target",
+				Key:   JobLabel,
 				Value: "server3",
 			},
 		},
@@ -264,7 +354,7 @@ func TestProfileMetaArray(t *testing.T) {
 
 		profileMetas, err = s.ListProfileMeta("heap_inuse_space", min, max, storage.LabelFilter{
 			Label: storage.Label{
-				Key:   "_target",
+				Key:   JobLabel,
 				Value: "server2",
 			},
 		})
@@ -2,13 +2,25 @@ package storage
 
 import (
 	"bytes"
+	"encoding/binary"
 	"time"
 )
 
+// TimeKeyLen The length of time key built by BuildTimeKey
+const TimeKeyLen = 8
+
 func CompareKey(k, max []byte) bool {
 	return bytes.Compare(k, max) <= 0
 }
 
+// BuildTimeKey Encode datetime as big-endian UTC unix nanoseconds, keys sort in time order byte by byte
 func BuildTimeKey(datetime time.Time) []byte {
-	return []byte(datetime.Local().Format(time.RFC3339))
+	b := make([]byte, TimeKeyLen)
+	binary.BigEndian.PutUint64(b, uint64(datetime.UnixNano()))
+	return b
+}
+
+// ParseTimeKey Decode the time key built by BuildTimeKey
+func ParseTimeKey(b []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(b)))
 }
@@ -34,6 +34,18 @@ func TestCompareKey(t *testing.T) {
 			key2: BuildTimeKey(now.Add(1 * time.Millisecond)),
 			want: true,
 		},
+		{
+			name: "greater millisecond diff",
+			key1: BuildTimeKey(now.Add(1 * time.Millisecond)),
+			key2: BuildTimeKey(now),
+			want: false,
+		},
+		{
+			name: "equal other location",
+			key1: BuildTimeKey(now.In(time.FixedZone("UTC+8", 8*60*60))),
+			key2: BuildTimeKey(now.UTC()),
+			want: true,
+		},
 		{
 			name: "greater",
 			key1: BuildTimeKey(now),
@@ -50,3 +62,10 @@ func TestCompareKey(t *testing.T) {
 	}
 
 }
+
+func TestParseTimeKey(t *testing.T) {
+	now := time.Now()
+	key := BuildTimeKey(now)
+	assert.Equal(t, TimeKeyLen, len(key))
+	assert.Equal(t, now.UnixNano(), ParseTimeKey(key).UnixNano())
+}
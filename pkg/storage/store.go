@@ -19,6 +19,14 @@ type Store interface {
 	// SaveProfileMeta Save profile meta data
 	SaveProfileMeta(metas []*ProfileMeta, ttl time.Duration) error
 
+	// SaveProfileWithMeta Save profile binaries and its meta data in one transaction, return profile id
+	// The ProfileID of every meta is set to the returned id
+	SaveProfileWithMeta(name string, data []byte, metas []*ProfileMeta, ttl time.Duration) (string, error)
+
+	// SaveProfilesWithMeta Save several profiles and their meta data in one transaction, return the profile ids.
+	// None of them is saved if it fails
+	SaveProfilesWithMeta(profiles []*ProfileWithMeta) ([]string, error)
+
 	// ListProfileMeta Get profile mete data list
 	ListProfileMeta(sampleType string, startTime, endTime time.Time, filters ...LabelFilter) ([]*ProfileMetaByTarget, error)
 
@@ -35,6 +43,14 @@ type Store interface {
 	Release()
 }
 
+// ProfileWithMeta A profile to save with its meta data
+type ProfileWithMeta struct {
+	Name  string
+	Data  []byte
+	Metas []*ProfileMeta
+	TTL   time.Duration
+}
+
 type ProfileMeta struct {
 	ProfileID      string
 	ProfileType    string
@@ -47,11 +63,21 @@ type ProfileMeta struct {
 	Timestamp      int64
 	Duration       int64
 	Labels         []Label
+	// SampleLabels The distinct pprof labels found in the profile samples
+	SampleLabels []Label
+	// SampleLabelValues The sample type values summed by each of SampleLabels, in the same order.
+	// It is empty if the meta is saved before the values are recorded
+	SampleLabelValues []int64
+	// SampleLabelsTruncated Some of the sample labels are not recorded for the limits of the meta
+	SampleLabelsTruncated bool
 }
 
+// MaxMetaSize The max encoded size of a profile meta, badger WithValueThreshold is 1kb
+const MaxMetaSize = 1 << 10
+
 func (meta *ProfileMeta) Encode() ([]byte, error) {
 	b, err := msgpack.Marshal(meta)
-	if len(b) > (1 << 10) {
+	if len(b) > MaxMetaSize {
 		return nil, errors.New("meta size > (1 << 10) , badger WithValueThreshold is 1kb")
 	}
 	if err != nil {
@@ -69,6 +95,22 @@ type Label struct {
 	Value string
 }
 
+// JobLabel HostLabel AppLabel The label keys of the target of a profile
+const (
+	JobLabel  = "_job"
+	HostLabel = "_host"
+	AppLabel  = "_app"
+)
+
+// SampleLabelPrefix Prefix of the pprof sample label keys in index and label list,
+// keeps them apart from the target labels
+const SampleLabelPrefix = "_pprof."
+
+// SampleLabelKey Return the index label key of pprof sample label key
+func SampleLabelKey(key string) string {
+	return SampleLabelPrefix + key
+}
+
 type ProfileMetaByTarget struct {
 	TargetName   string
 	ProfileMetas []*ProfileMeta
@@ -6,10 +6,12 @@ import (
 )
 
 var (
-	idReg, _   = regexp.Compile(`/([\d]+)(/|$)`)
+	idReg, _   = regexp.Compile(`/([\d]+(@[\w.:-]+)?(\+[\d]+(@[\w.:-]+)?)*)(/|$)`)
 	typeReg, _ = regexp.Compile(`si=(profile|heap|allocs|black|mutex)_`)
 )
 
+// ExtractProfileID Extract the profile id from path, it can also be the profile ids joined by "+".
+// The id of a profile of a federated peer is id@peer, where peer is the name of the peer
 func ExtractProfileID(path string) string {
 	return strings.ReplaceAll(idReg.FindString(path), "/", "")
 }
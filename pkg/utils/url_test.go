@@ -38,6 +38,36 @@ func TestExtractProfileId(t *testing.T) {
 			want:    "",
 			wantErr: false,
 		},
+		{
+			name:    "/10009+10010/top",
+			input:   "/api/pprof/ui/10009+10010/top",
+			want:    "10009+10010",
+			wantErr: false,
+		},
+		{
+			name:    "/10009+/",
+			input:   "/api/pprof/ui/10009+/",
+			want:    "",
+			wantErr: false,
+		},
+		{
+			name:    "/10009@1+10010/top",
+			input:   "/api/pprof/ui/10009@1+10010/top",
+			want:    "10009@1+10010",
+			wantErr: false,
+		},
+		{
+			name:    "/10009@cprofiler-1:8080+10010@shard.a/top",
+			input:   "/api/pprof/ui/10009@cprofiler-1:8080+10010@shard.a/top",
+			want:    "10009@cprofiler-1:8080+10010@shard.a",
+			wantErr: false,
+		},
+		{
+			name:    "/10009@/",
+			input:   "/api/pprof/ui/10009@/",
+			want:    "",
+			wantErr: false,
+		},
 		{
 			name:    "/10009asd/",
 			input:   "/api/pprof/ui/10009asd/",
@@ -8,12 +8,12 @@ import (
 	"os"
 	"os/signal"
 	"runtime"
+	"strings"
 	"syscall"
 	"time"
 
 	"cprofiler/pkg/apiserver"
 	"cprofiler/pkg/collector"
-	"cprofiler/pkg/storage"
 	"cprofiler/pkg/storage/badger"
 
 	log "github.com/sirupsen/logrus"
@@ -24,6 +24,26 @@ var (
 	dataPath       string
 	dataGCInternal time.Duration
 	uiGCInternal   time.Duration
+	uiMaxEntries   int
+	uiMaxBytes     int64
+	uiLoadWait     time.Duration
+	uiWarmInternal time.Duration
+	uiWarmCount    int
+	uiWarmMaxBytes int64
+	uiWarmLookback time.Duration
+
+	ingestExpiration   time.Duration
+	otlpResourceLabels string
+
+	remoteWriteQueuePath string
+	remoteWriteToken     string
+
+	scrapeConcurrency int
+
+	shardIndex        int
+	shardCount        int
+	federationPeers   string
+	federationTimeout time.Duration
 )
 
 var buildstamp = ""
@@ -37,28 +57,94 @@ func main() {
 	flag.StringVar(&dataPath, "data-path", "./data/cprofiler/badger", "Collector Data file path")
 	flag.DurationVar(&dataGCInternal, "data-gc-internal", 5*time.Minute, "Collector Data gc internal")
 	flag.DurationVar(&uiGCInternal, "ui-gc-internal", 2*time.Minute, "Trace and pprof ui gc internal, must be greater than or equal to 1m")
+	flag.IntVar(&uiMaxEntries, "ui-max-entries", 64, "Max number of loaded profiles of trace and pprof ui each, 0 means no limit")
+	flag.Int64Var(&uiMaxBytes, "ui-max-bytes", 512<<20, "Max total size of loaded profiles of trace and pprof ui each, a profile counts 24 times and a trace 16 times of its data size, 0 means no limit")
+	flag.DurationVar(&uiLoadWait, "ui-load-wait", 3*time.Second, "Time a trace or pprof ui request waits for loading its profile, then it gets a progress page")
+	flag.DurationVar(&uiWarmInternal, "ui-warm-internal", 0, "Internal of warming the latest profiles of each job into trace and pprof ui, 0 means no warming")
+	flag.IntVar(&uiWarmCount, "ui-warm-count", 1, "Number of the latest profiles of each job and profile type to warm")
+	flag.Int64Var(&uiWarmMaxBytes, "ui-warm-max-bytes", 256<<20, "Max total data size of the profiles warmed each time, 0 means no limit")
+	flag.DurationVar(&uiWarmLookback, "ui-warm-lookback", 2*time.Hour, "Time range of the profiles to warm looking back from now, it should be longer than the longest scrape interval")
+	flag.DurationVar(&ingestExpiration, "ingest-expiration", 168*time.Hour, "Expiration of the profiles pushed to /ingest")
+	flag.StringVar(&otlpResourceLabels, "otlp-resource-labels", strings.Join(apiserver.DefaultOTLPResourceLabels, ","), "Comma separated OTLP resource attributes recorded as the labels of the pushed profiles")
+	flag.StringVar(&remoteWriteQueuePath, "remote-write-queue-path", "./data/cprofiler/remote-write", "Directory of the queues of the profiles forwarded to the remote write endpoints")
+	flag.StringVar(&remoteWriteToken, "remote-write-token", "", "Bearer token /api/remote_write requires, empty means no authentication")
+	flag.IntVar(&scrapeConcurrency, "scrape-concurrency", 0, "Max number of concurrent scrapes of all targets, 0 means no limit")
+	flag.IntVar(&shardIndex, "shard-index", 0, "Shard index of this replica, it only scrapes the hosts in its shard")
+	flag.IntVar(&shardCount, "shard-count", 0, "Number of shards the hosts are split into, 0 or 1 means no sharding")
+	flag.StringVar(&federationPeers, "federation-peers", "", "Comma separated peer replicas of name=url, such as cprofiler-1=http://cprofiler-1:8080, the apis and uis query their profiles as well. The name is in the ids of the peer profiles, it is the host of the url if omitted")
+	flag.DurationVar(&federationTimeout, "federation-timeout", 30*time.Second, "Timeout of querying a federation peer")
 
 	flag.Parse()
 
-	log.WithFields(log.Fields{"configPath": configPath, "dataPath": dataPath, "dataGCInternal": dataGCInternal.String(), "uiGCInternal": uiGCInternal.String()}).
+	log.WithFields(log.Fields{"configPath": configPath, "dataPath": dataPath, "dataGCInternal": dataGCInternal.String(), "uiGCInternal": uiGCInternal.String(), "uiMaxEntries": uiMaxEntries, "uiMaxBytes": uiMaxBytes, "uiLoadWait": uiLoadWait.String(),
+		"uiWarmInternal": uiWarmInternal.String(), "uiWarmCount": uiWarmCount, "uiWarmMaxBytes": uiWarmMaxBytes, "uiWarmLookback": uiWarmLookback.String(),
+		"ingestExpiration": ingestExpiration.String(), "otlpResourceLabels": otlpResourceLabels, "remoteWriteQueuePath": remoteWriteQueuePath,
+		"scrapeConcurrency": scrapeConcurrency, "shardIndex": shardIndex, "shardCount": shardCount, "federationPeers": federationPeers, "federationTimeout": federationTimeout.String()}).
 		Info("flag parse")
 
 	if uiGCInternal < time.Minute {
 		log.Fatal("ui-gc-internal must be greater than or equal to 1m")
 		return
 	}
+	if shardCount > 1 && (shardIndex < 0 || shardIndex >= shardCount) {
+		log.Fatal("shard-index must be in [0, shard-count)")
+		return
+	}
+	var peers []apiserver.Peer
+	peerNames := make(map[string]bool)
+	for _, s := range strings.Split(federationPeers, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		peer, err := apiserver.ParsePeer(s)
+		if err != nil {
+			log.Fatalf("federation-peers: %s", err)
+			return
+		}
+		if peerNames[peer.Name] {
+			log.Fatalf("federation-peers: duplicate peer name %q", peer.Name)
+			return
+		}
+		peerNames[peer.Name] = true
+		peers = append(peers, peer)
+	}
+
+	var resourceLabels []string
+	for _, label := range strings.Split(otlpResourceLabels, ",") {
+		if label = strings.TrimSpace(label); label != "" {
+			resourceLabels = append(resourceLabels, label)
+		}
+	}
 
 	startHttpServe()
 
 	// New Store
 	store := badger.NewStore(badger.DefaultOptions(dataPath).WithGCInternal(dataGCInternal))
 	// Run collector
-	collectorManger := runCollector(configPath, store)
+	collectorManger := runCollector(configPath, collector.DefaultOptions(store).
+		WithRemoteWriteQueuePath(remoteWriteQueuePath).
+		WithShard(shardIndex, shardCount).
+		WithScrapeConcurrency(scrapeConcurrency))
 	// Run api server
-	apiServer := runAPIServer(store, uiGCInternal)
+	apiServer := runAPIServer(apiserver.DefaultOptions(store).
+		WithAddr(":8080").
+		WithGCInternal(uiGCInternal).
+		WithUIMaxEntries(uiMaxEntries).
+		WithUIMaxBytes(uiMaxBytes).
+		WithUILoadWait(uiLoadWait).
+		WithWarmInternal(uiWarmInternal).
+		WithWarmCount(uiWarmCount).
+		WithWarmMaxBytes(uiWarmMaxBytes).
+		WithWarmLookback(uiWarmLookback).
+		WithIngestExpiration(ingestExpiration).
+		WithOTLPResourceLabels(resourceLabels).
+		WithRemoteWriteToken(remoteWriteToken).
+		WithPeers(peers).
+		WithFederationTimeout(federationTimeout).
+		WithScrapeStatus(collectorManger.ScrapeStatus))
 
 	// receive signal exit
-	quit := make(chan os.Signal)
+	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	s := <-quit
 	log.Info("signal receive exit ", s)
@@ -78,19 +164,16 @@ func version() {
 }
 
 // runAPIServer Run apis ,pprof ui ,trace ui
-func runAPIServer(store storage.Store, gcInternal time.Duration) *apiserver.APIServer {
-	apiServer := apiserver.NewAPIServer(
-		apiserver.DefaultOptions(store).
-			WithAddr(":8080").
-			WithGCInternal(gcInternal))
+func runAPIServer(opt apiserver.Options) *apiserver.APIServer {
+	apiServer := apiserver.NewAPIServer(opt)
 
 	apiServer.Run()
 	return apiServer
 }
 
 // runCollector Run collector manger
-func runCollector(configPath string, store storage.Store) *collector.Manger {
-	m := collector.NewManger(store)
+func runCollector(configPath string, opt collector.Options) *collector.Manger {
+	m := collector.NewManger(opt)
 	err := collector.LoadConfig(configPath, func(config collector.CollectorConfig) {
 		log.Info("config change, reload collector!!!")
 		m.Load(config)